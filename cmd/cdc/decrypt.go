@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/MathewBravo/cdc-pipeline/pkg/fieldcrypt"
+)
+
+// runDecrypt handles "cdc decrypt", which recovers values written by the
+// encrypt mask. Values given as arguments are printed one per line; without
+// arguments it reads JSON documents from stdin and writes them back, one per
+// line, with every encrypted string decrypted.
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyringPath := fs.String("keyring", "", "path to the keyring file")
+	fs.Parse(args)
+	if *keyringPath == "" {
+		fmt.Println("usage: cdc decrypt -keyring path [value...]")
+		os.Exit(2)
+	}
+
+	keys, err := fieldcrypt.LoadKeyring(*keyringPath)
+	if err != nil {
+		log.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	if fs.NArg() > 0 {
+		for _, value := range fs.Args() {
+			plain, err := keys.Decrypt(value)
+			if err != nil {
+				log.Fatalf("Failed to decrypt %q: %v", value, err)
+			}
+			fmt.Println(plain)
+		}
+		return
+	}
+
+	if err := decryptStream(keys, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// decryptStream copies JSON documents from in to out with every encrypted
+// string decrypted. Numbers are kept as written, so ids above 2^53 survive.
+func decryptStream(keys *fieldcrypt.Keyring, in io.Reader, out, warnings io.Writer) error {
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	for n := 1; ; n++ {
+		var doc any
+		if err := dec.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("document %d is not JSON: %w", n, err)
+		}
+		doc, err := keys.DecryptAll(doc)
+		if err != nil {
+			fmt.Fprintf(warnings, "WARN: document %d: %v\n", n, err)
+		}
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/pkg/fieldcrypt"
+)
+
+func TestDecryptStreamKeepsNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	keyring := "active: k1\nkeys:\n  k1: " + base64.StdEncoding.EncodeToString(make([]byte, 32)) + "\n"
+	if err := os.WriteFile(path, []byte(keyring), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := fieldcrypt.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, err := keys.Encrypt("", fieldcrypt.ModeRandom, "a<b>@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := `{"id":9007199254740993,"price":0.10,"email":"` + email + `"}` + "\n" + `[1e3]`
+	var out, warnings bytes.Buffer
+	if err := decryptStream(keys, strings.NewReader(in), &out, &warnings); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"email":"a<b>@example.com","id":9007199254740993,"price":0.10}` + "\n" + `[1e3]` + "\n"
+	if out.String() != want {
+		t.Errorf("output = %s, want %s", out.String(), want)
+	}
+	if warnings.Len() > 0 {
+		t.Errorf("unexpected warnings: %s", warnings.String())
+	}
+
+	if err := decryptStream(keys, strings.NewReader(`{"a":`), &out, &warnings); err == nil {
+		t.Error("expected an error for truncated JSON")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/internal/pipeline"
+	"github.com/MathewBravo/cdc-pipeline/internal/sink"
+)
+
+// runDLQ handles "cdc dlq replay", which re-sends dead-lettered events
+// through the sink that dead-lettered them. Events the filter, a transform or
+// a script failed on never reached a sink: that stage runs again and the
+// outputs are routed, scanned and sent to every sink that can resend, as the
+// pipeline would have done.
+// Events the pipeline blocked or could not route are kept.
+func runDLQ(args []string) {
+	if len(args) == 0 || args[0] != "replay" {
+		fmt.Println("usage: cdc dlq replay [-config path]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("dlq replay", flag.ExitOnError)
+	configPath := fs.String("config", "./data/config.yaml", "path to the config file")
+	fs.Parse(args[1:])
+
+	cfg, err := configs.LoadWithoutSource(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	sinkCfgs := cfg.Sinks
+	if len(sinkCfgs) == 0 {
+		sinkCfgs = []configs.SinkConfig{cfg.Sink}
+	}
+	targets := make(map[string]func(events.ChangeEvent) error)
+	var names, skipped []string
+	for i := range sinkCfgs {
+		sinkCfg := &sinkCfgs[i]
+		var name string
+		switch sinkCfg.Type {
+		case "", "kafka":
+			s, err := sink.NewKafkaSink(sinkCfg, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to create kafka sink: %v", err)
+			}
+			defer s.Close()
+			name = nameOr(sinkCfg.Name, "kafka")
+			targets[name] = s.Resend
+		case "nats":
+			s, err := sink.NewNATSSink(sinkCfg, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to create nats sink: %v", err)
+			}
+			defer s.Close()
+			name = nameOr(sinkCfg.Name, "nats")
+			targets[name] = s.Resend
+		case "redis":
+			s, err := sink.NewRedisSink(sinkCfg, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to create redis sink: %v", err)
+			}
+			defer s.Close()
+			name = nameOr(sinkCfg.Name, "redis")
+			targets[name] = s.Resend
+		case "elasticsearch", "opensearch":
+			s, err := sink.NewElasticsearchSink(sinkCfg, nil, nil)
+			if err != nil {
+				log.Fatalf("Failed to create elasticsearch sink: %v", err)
+			}
+			name = nameOr(sinkCfg.Name, "elasticsearch")
+			targets[name] = s.Resend
+		default:
+			skipped = append(skipped, nameOr(sinkCfg.Name, sinkCfg.Type))
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(targets) == 0 {
+		log.Fatalf("DLQ replay needs a kafka, nats, redis or elasticsearch sink in the config")
+	}
+
+	if len(skipped) > 0 {
+		fmt.Printf("WARN: %s cannot resend, events the filter, a transform or a script failed on are not replayed there\n", strings.Join(skipped, ", "))
+	}
+
+	p, err := pipeline.NewPipeline(&cfg.Pipeline, nil)
+	if err != nil {
+		log.Fatalf("Failed to create pipeline: %v", err)
+	}
+	rerun := func(entry dlq.Entry) error {
+		outputs, err := p.Rerun(entry)
+		if err != nil {
+			return err
+		}
+		for _, out := range outputs {
+			for _, name := range names {
+				if err := targets[name](out); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	resend := func(entry dlq.Entry) error {
+		switch entry.Sink {
+		case "pii scan":
+			return fmt.Errorf("blocked for unmasked PII, add masks and resync the rows from the source")
+		case "route":
+			return fmt.Errorf("no route matched the event, fix the routes and resync the rows from the source")
+		}
+		if entry.Stage != "" {
+			return rerun(entry)
+		}
+		target, ok := targets[entry.Sink]
+		if !ok {
+			return fmt.Errorf("sink %q is not configured or cannot resend", entry.Sink)
+		}
+		return target(entry.Event)
+	}
+
+	replayed, failed, err := dlq.Replay(&cfg.DLQ, resend)
+	fmt.Printf("Replayed %d dead letters, %d still failing\n", replayed, failed)
+	if err != nil {
+		log.Fatalf("Replay stopped: %v", err)
+	}
+}
+
+func nameOr(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
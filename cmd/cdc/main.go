@@ -9,12 +9,23 @@ import (
 
 	"github.com/MathewBravo/cdc-pipeline/internal/configs"
 	"github.com/MathewBravo/cdc-pipeline/internal/connector"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
 	i "github.com/MathewBravo/cdc-pipeline/internal/init"
+	"github.com/MathewBravo/cdc-pipeline/internal/metrics"
 	"github.com/MathewBravo/cdc-pipeline/internal/pipeline"
 	"github.com/MathewBravo/cdc-pipeline/internal/sink"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		runDLQ(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		runDecrypt(os.Args[2:])
+		return
+	}
+
 	i.Init()
 
 	cfg, err := configs.Load("./data/config.yaml")
@@ -23,6 +34,11 @@ func main() {
 	}
 	fmt.Println("Config loaded successfully")
 
+	if cfg.Metrics.Addr != "" {
+		metrics.Serve(cfg.Metrics.Addr)
+		fmt.Printf("Metrics served on %s/debug/vars\n", cfg.Metrics.Addr)
+	}
+
 	conn := connector.NewPGConnector(cfg.Source)
 	eventCh, err := conn.Start()
 	if err != nil {
@@ -30,19 +46,32 @@ func main() {
 	}
 	fmt.Println("Connector started")
 
-	p := pipeline.NewPipeline(&cfg.Pipeline)
+	deadLetters, err := dlq.New(&cfg.DLQ)
+	if err != nil {
+		log.Fatalf("Failed to open dead-letter queue: %v", err)
+	}
+
+	p, err := pipeline.NewPipeline(&cfg.Pipeline, deadLetters)
+	if err != nil {
+		log.Fatalf("Failed to create pipeline: %v", err)
+	}
 	outputCh := p.Start(eventCh)
 	fmt.Println("Pipeline started")
 
-	s, err := sink.NewKafkaSink(&cfg.Sink)
+	var s sink.Sink
+	if len(cfg.Sinks) > 0 {
+		s, err = sink.NewFanoutSink(cfg.Sinks, conn.Ack, deadLetters)
+	} else {
+		s, err = sink.New(&cfg.Sink, conn.Ack, deadLetters)
+	}
 	if err != nil {
-		log.Fatalf("Failed to create kafka sink: %v", err)
+		log.Fatalf("Failed to create sink: %v", err)
 	}
 	err = s.Start(outputCh)
 	if err != nil {
-		log.Fatalf("Failed to start kafka sink: %v", err)
+		log.Fatalf("Failed to start sink: %v", err)
 	}
-	fmt.Println("Kafka sink started")
+	fmt.Println("Sink started")
 
 	fmt.Println("\nCDC Pipeline running. Press Ctrl+C to stop.")
 	fmt.Println("Check Kafka UI at http://localhost:8080 to see messages")
@@ -55,9 +84,13 @@ func main() {
 	// Graceful shutdown in reverse order
 	fmt.Println("\nShutting down...")
 
-	fmt.Println("Stopping Kafka sink...")
+	fmt.Println("Stopping sink...")
 	s.Stop()
 
+	if deadLetters != nil {
+		deadLetters.Close()
+	}
+
 	fmt.Println("Stopping connector...")
 	if err := conn.Stop(); err != nil {
 		log.Printf("Error stopping connector: %v", err)
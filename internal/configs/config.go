@@ -1,10 +1,19 @@
 package configs
 
 import (
+	"bytes"
 	"fmt"
+	"maps"
 	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/MathewBravo/cdc-pipeline/internal/expr"
+	"github.com/MathewBravo/cdc-pipeline/internal/script"
+	"github.com/MathewBravo/cdc-pipeline/pkg/fieldcrypt"
 	"github.com/goccy/go-yaml"
 )
 
@@ -13,6 +22,9 @@ type Config struct {
 	CDC      CDCConfig      `yaml:"cdc"`
 	Pipeline PipelineConfig `yaml:"pipeline"`
 	Sink     SinkConfig     `yaml:"sink"`
+	Sinks    []SinkConfig   `yaml:"sinks"`
+	DLQ      DLQConfig      `yaml:"dlq"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
 }
 
 type SourceConfig struct {
@@ -35,28 +47,324 @@ type PipelineConfig struct {
 	Tables         map[string]TableOptions `yaml:"tables"`
 	DefaultRoute   string                  `yaml:"default_route"`
 	ExcludedTables []string                `yaml:"excluded_tables"`
+	PIIKeys        PIIKeysConfig           `yaml:"pii_keys"`
+	Encryption     EncryptionConfig        `yaml:"encryption"`
+	PIIScan        PIIScanConfig           `yaml:"pii_scan"`
+	// DefaultRouteTemplate is DefaultRoute compiled by Load.
+	DefaultRouteTemplate *template.Template `yaml:"-"`
+
+	matcher *tableMatcher
 }
 
 type SinkConfig struct {
-	Type          string        `yaml:"type"`
-	Brokers       []string      `yaml:"brokers"`
-	Compression   string        `yaml:"compression"`
-	BatchSize     int           `yaml:"batch_size"`
-	FlushInterval time.Duration `yaml:"flush_interval"`
+	Type           string                 `yaml:"type"`
+	Name           string                 `yaml:"name"`
+	Optional       bool                   `yaml:"optional"`
+	Buffer         int                    `yaml:"buffer"`
+	FailurePolicy  string                 `yaml:"failure_policy"`
+	Brokers        []string               `yaml:"brokers"`
+	Compression    string                 `yaml:"compression"`
+	BatchSize      int                    `yaml:"batch_size"`
+	FlushInterval  time.Duration          `yaml:"flush_interval"`
+	Topics         map[string]TopicConfig `yaml:"topics"`
+	StrictTopics   bool                   `yaml:"strict_topics"`
+	Tombstones     string                 `yaml:"delete_tombstones"`
+	ClaimCheck     ClaimCheckConfig       `yaml:"claim_check"`
+	Partitioner    string                 `yaml:"partitioner"`
+	PartitionKeys  map[string]string      `yaml:"partition_keys"`
+	Producer       ProducerConfig         `yaml:"producer"`
+	NATS           NATSConfig             `yaml:"nats"`
+	Redis          RedisConfig            `yaml:"redis"`
+	Elasticsearch  ElasticsearchConfig    `yaml:"elasticsearch"`
+	AMQP           AMQPConfig             `yaml:"amqp"`
+	SQLite         SQLiteConfig           `yaml:"sqlite"`
+	GRPC           GRPCConfig             `yaml:"grpc"`
+	Live           LiveConfig             `yaml:"live"`
+	Retry          RetryConfig            `yaml:"retry"`
+	CircuitBreaker CircuitBreakerConfig   `yaml:"circuit_breaker"`
+
+	keyMatcher *tableMatcher
+}
+
+// TopicConfig describes a Kafka topic the sink creates when it is missing
+// and checks when it exists. Zero values leave the broker default in place.
+type TopicConfig struct {
+	Partitions        int32         `yaml:"partitions"`
+	ReplicationFactor int16         `yaml:"replication_factor"`
+	CleanupPolicy     string        `yaml:"cleanup_policy"`
+	Retention         time.Duration `yaml:"retention"`
+	MinInsyncReplicas int           `yaml:"min_insync_replicas"`
+}
+
+// ProducerConfig tunes the Kafka producer. Unset values keep the franz-go
+// defaults, acks from all in-sync replicas and idempotent writes, except
+// that retries are bounded by a two minute delivery timeout.
+type ProducerConfig struct {
+	Acks               string        `yaml:"acks"`
+	Idempotent         *bool         `yaml:"idempotent"`
+	MaxInFlight        int           `yaml:"max_in_flight"`
+	RequestTimeout     time.Duration `yaml:"request_timeout"`
+	DeliveryTimeout    time.Duration `yaml:"delivery_timeout"`
+	Retries            *int          `yaml:"retries"`
+	MaxBufferedRecords int           `yaml:"max_buffered_records"`
+	ClientID           string        `yaml:"client_id"`
+}
+
+// ClaimCheckConfig moves events larger than Threshold bytes to a blob store
+// and sends a reference in their place. A zero threshold disables it.
+type ClaimCheckConfig struct {
+	Threshold int             `yaml:"threshold"`
+	Store     BlobStoreConfig `yaml:"store"`
+}
+
+type BlobStoreConfig struct {
+	Type      string `yaml:"type"`
+	Path      string `yaml:"path"`
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	Region    string `yaml:"region"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	AccessKey string `yaml:"-"`
+	SecretKey string `yaml:"-"`
+}
+
+type NATSConfig struct {
+	URL        string        `yaml:"url"`
+	Stream     string        `yaml:"stream"`
+	Subjects   []string      `yaml:"subjects"`
+	MaxPending int           `yaml:"max_pending"`
+	AckTimeout time.Duration `yaml:"ack_timeout"`
+	// MaxRetries bounds the synchronous retries of a message JetStream did
+	// not ack before it is dead-lettered.
+	MaxRetries int `yaml:"max_retries"`
+}
+
+type RedisConfig struct {
+	Addr        string `yaml:"addr"`
+	DB          int    `yaml:"db"`
+	MaxLen      int64  `yaml:"max_len"`
+	ApproxTrim  bool   `yaml:"approx_trim"`
+	CacheRows   bool   `yaml:"cache_rows"`
+	CachePrefix string `yaml:"cache_prefix"`
+	Password    string `yaml:"-"`
+}
+
+type AMQPConfig struct {
+	URL          string `yaml:"url"`
+	Exchange     string `yaml:"exchange"`
+	ExchangeType string `yaml:"exchange_type"`
+	MaxPending   int    `yaml:"max_pending"`
+	Password     string `yaml:"-"`
+}
+
+type ElasticsearchConfig struct {
+	URL            string        `yaml:"url"`
+	Username       string        `yaml:"username"`
+	IndexTemplate  string        `yaml:"index_template"`
+	BulkActions    int           `yaml:"bulk_actions"`
+	FlushInterval  time.Duration `yaml:"flush_interval"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	MaxRetries     int           `yaml:"max_retries"`
+	Password       string        `yaml:"-"`
+}
+
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+type GRPCConfig struct {
+	Addr         string `yaml:"addr"`
+	ReplayBuffer int    `yaml:"replay_buffer"`
+	ClientBuffer int    `yaml:"client_buffer"`
+}
+
+type LiveConfig struct {
+	Addr           string        `yaml:"addr"`
+	ReplayBuffer   int           `yaml:"replay_buffer"`
+	ClientBuffer   int           `yaml:"client_buffer"`
+	SlowClient     string        `yaml:"slow_client"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	AllowedOrigins []string      `yaml:"allowed_origins"`
+}
+
+// RetryConfig is the backoff between the Kafka client's own produce retries.
+// How often a record is retried is set by producer.retries and
+// producer.delivery_timeout.
+type RetryConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	// MaxAttempts and RetryableErrors belonged to a retry loop outside the
+	// client that could reorder records. They are rejected so old configs
+	// do not silently lose their meaning.
+	MaxAttempts     int      `yaml:"max_attempts"`
+	RetryableErrors []string `yaml:"retryable_errors"`
+}
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+type MetricsConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// DLQConfig is where undeliverable events go. A write is retried MaxRetries
+// times; after that OnFailure either blocks the sink until the destination
+// takes it again or drops the event with an error log.
+type DLQConfig struct {
+	Type       string   `yaml:"type"`
+	Path       string   `yaml:"path"`
+	Topic      string   `yaml:"topic"`
+	Brokers    []string `yaml:"brokers"`
+	MaxRetries int      `yaml:"max_retries"`
+	OnFailure  string   `yaml:"on_failure"`
 }
 
 type TableOptions struct {
 	Operations []string  `yaml:"operations"`
 	PIIMasks   []PIIMask `yaml:"pii_masks"`
 	Route      string    `yaml:"route_to"`
+	// Routes are tried in order before Route; the first whose condition
+	// matches wins.
+	Routes []RouteRule `yaml:"routes"`
+	Filter string      `yaml:"filter"`
+	// IncludeColumns and ExcludeColumns take glob patterns. Primary key
+	// columns are always kept.
+	IncludeColumns []string     `yaml:"include_columns"`
+	ExcludeColumns []string     `yaml:"exclude_columns"`
+	Transforms     []Transform  `yaml:"transforms"`
+	PIIScan        string       `yaml:"pii_scan"`
+	Script         ScriptConfig `yaml:"script"`
+	// FilterProgram is Filter compiled by Load.
+	FilterProgram *expr.Program `yaml:"-"`
+	// RouteTemplate is Route compiled by Load.
+	RouteTemplate *template.Template `yaml:"-"`
+}
+
+// ScriptConfig runs a Starlark script on the table's events after the
+// transforms. Each call may take at most MaxSteps execution steps and
+// Timeout of wall time.
+type ScriptConfig struct {
+	Path     string        `yaml:"path"`
+	MaxSteps uint64        `yaml:"max_steps"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Program is the script loaded by Load. It reloads itself when the file
+	// changes.
+	Program *script.Script `yaml:"-"`
 }
 
+// RouteRule sends events whose row matches When to the route template To.
+type RouteRule struct {
+	When string `yaml:"when"`
+	To   string `yaml:"to"`
+
+	WhenProgram *expr.Program      `yaml:"-"`
+	ToTemplate  *template.Template `yaml:"-"`
+}
+
+// RouteFuncs are available in route templates.
+var RouteFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// PIIMask masks a column, or values inside a json/jsonb column when Field is
+// a path such as profile.contact.email or items[*].card_number.
 type PIIMask struct {
 	Field  string `yaml:"field"`
 	Action string `yaml:"action"`
+	// KeyID pins hmac, tokenize and encrypt to one key instead of the
+	// active one. tokenize requires it.
+	KeyID string `yaml:"key_id"`
+	// Mode is random (AES-GCM, the default) or deterministic (AES-SIV) for
+	// encrypt. Deterministic ciphertexts can be joined and grouped on.
+	Mode string `yaml:"mode"`
+
+	// Column and Path are Field split by Load. Path is empty for plain
+	// column masks.
+	Column string     `yaml:"-"`
+	Path   []PathStep `yaml:"-"`
+}
+
+// PIIScanConfig turns on the scanner that looks for PII in string columns
+// without a mask. Policy is off, alert, mask or block; tables can override
+// it with their own pii_scan. Detectors defaults to all of them.
+type PIIScanConfig struct {
+	Policy    string   `yaml:"policy"`
+	Detectors []string `yaml:"detectors"`
+	Report    string   `yaml:"report"`
+}
+
+// ScanPolicy returns the scan policy for a table.
+func (c *PipelineConfig) ScanPolicy(schema, table string) string {
+	if opts, ok := c.TableOptions(schema, table); ok && opts.PIIScan != "" {
+		return opts.PIIScan
+	}
+	return c.PIIScan.Policy
+}
+
+// EncryptionConfig points at the keyring file for encrypt masks, see
+// fieldcrypt.LoadKeyring for its format.
+type EncryptionConfig struct {
+	Keyring string `yaml:"keyring"`
+	// Keys is the keyring loaded by Load.
+	Keys *fieldcrypt.Keyring `yaml:"-"`
+}
+
+// PIIKeysConfig holds the secrets for keyed masks. Active is used unless a
+// mask names a key, so rotating means adding a key and switching Active.
+type PIIKeysConfig struct {
+	Active string   `yaml:"active"`
+	Keys   []PIIKey `yaml:"keys"`
+}
+
+// PIIKey reads its secret from the environment variable Env or from File.
+type PIIKey struct {
+	ID     string `yaml:"id"`
+	Env    string `yaml:"env"`
+	File   string `yaml:"file"`
+	Secret []byte `yaml:"-"`
+}
+
+// Transform is one field operation, applied in order:
+//
+//	rename:  Field -> To
+//	cast:    Field to int, float, string, bool or timestamp (Unit s, ms, us
+//	         or ns for epoch numbers, default s)
+//	set:     Field = Value
+//	compute: Field = Template rendered over the row
+//	flatten: Field's JSON object -> Field<Separator>key..., recursively
+//	unnest:  Field's JSON object -> one column per top level key
+type Transform struct {
+	Action    string `yaml:"action"`
+	Field     string `yaml:"field"`
+	To        string `yaml:"to"`
+	Unit      string `yaml:"unit"`
+	Value     any    `yaml:"value"`
+	Template  string `yaml:"template"`
+	Separator string `yaml:"separator"`
+	// Compiled is Template parsed by Load.
+	Compiled *template.Template `yaml:"-"`
 }
 
 func Load(path string) (*Config, error) {
+	cfg, err := LoadWithoutSource(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Source.Password = os.Getenv("PG_PASSWORD")
+	if cfg.Source.Password == "" {
+		return nil, fmt.Errorf("CONFIG ERR: Empty PG_PASSWORD env variable")
+	}
+	return cfg, nil
+}
+
+// LoadWithoutSource loads the config for commands that never connect to
+// Postgres, such as dlq replay, so PG_PASSWORD does not have to be set.
+func LoadWithoutSource(path string) (*Config, error) {
 	fmt.Println("READING CONFIG: ", path)
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -68,21 +376,665 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg.Source.Password = os.Getenv("PG_PASSWORD")
-	if cfg.Source.Password == "" {
-		return nil, fmt.Errorf("CONFIG ERR: Empty PG_PASSWORD env variable")
-	}
-
 	if cfg.CDC.HeartbeatInterval == "" {
 		cfg.CDC.HeartbeatInterval = "10s"
 	}
 	if cfg.Source.SSLMode == "" {
 		cfg.Source.SSLMode = "disable"
 	}
+	if err := setPipelineDefaults(&cfg.Pipeline); err != nil {
+		return nil, err
+	}
+	if len(cfg.Sinks) > 0 {
+		if err := setFanoutDefaults(cfg.Sinks); err != nil {
+			return nil, err
+		}
+	} else if err := setSinkDefaults(&cfg.Sink); err != nil {
+		return nil, err
+	}
+	if err := setDLQDefaults(&cfg); err != nil {
+		return nil, err
+	}
+	if err := checkNATSSubjects(&cfg); err != nil {
+		return nil, err
+	}
+	if err := checkKafkaTopics(&cfg); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// setPipelineDefaults compiles the per-table expressions once so a bad one
+// fails at startup rather than on the first matching event.
+func setPipelineDefaults(cfg *PipelineConfig) error {
+	if cfg.DefaultRoute == "" {
+		return fmt.Errorf("CONFIG ERR: pipeline default_route is required, events from tables without a route would have nowhere to go")
+	}
+	tmpl, err := compileRoute(cfg.DefaultRoute)
+	if err != nil {
+		return fmt.Errorf("CONFIG ERR: default_route %q: %w", cfg.DefaultRoute, err)
+	}
+	if usesRow(tmpl) {
+		return fmt.Errorf("CONFIG ERR: default_route %q uses row values, it is the last fallback and must render for every event; route on row values in a table's routes or route_to", cfg.DefaultRoute)
+	}
+	cfg.DefaultRouteTemplate = tmpl
+
+	if err := setPIIKeyDefaults(&cfg.PIIKeys); err != nil {
+		return err
+	}
+	if cfg.Encryption.Keyring != "" {
+		keys, err := fieldcrypt.LoadKeyring(cfg.Encryption.Keyring)
+		if err != nil {
+			return fmt.Errorf("CONFIG ERR: %w", err)
+		}
+		cfg.Encryption.Keys = keys
+	}
+
+	if cfg.PIIScan.Policy == "" {
+		cfg.PIIScan.Policy = "off"
+	}
+	if err := checkScanPolicy("pipeline", cfg.PIIScan.Policy); err != nil {
+		return err
+	}
+
+	for table, opts := range cfg.Tables {
+		if opts.PIIScan != "" {
+			if err := checkScanPolicy("table "+table, opts.PIIScan); err != nil {
+				return err
+			}
+		}
+		opts.PIIMasks = slices.Clone(opts.PIIMasks)
+		if err := checkPIIMasks(table, opts.PIIMasks, &cfg.PIIKeys, cfg.Encryption.Keys); err != nil {
+			return err
+		}
+		if opts.Route != "" {
+			tmpl, err := compileRoute(opts.Route)
+			if err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s route_to %q: %w", table, opts.Route, err)
+			}
+			opts.RouteTemplate = tmpl
+		}
+		opts.Routes = slices.Clone(opts.Routes)
+		for i := range opts.Routes {
+			rule := &opts.Routes[i]
+			if rule.When == "" || strings.TrimSpace(rule.To) == "" {
+				return fmt.Errorf("CONFIG ERR: table %s route %d needs both when and to", table, i)
+			}
+			program, err := expr.Compile(rule.When)
+			if err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s route condition %q: %w", table, rule.When, err)
+			}
+			rule.WhenProgram = program
+			if rule.ToTemplate, err = compileRoute(rule.To); err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s route %q: %w", table, rule.To, err)
+			}
+		}
+		if opts.Filter != "" {
+			program, err := expr.Compile(opts.Filter)
+			if err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s filter %q: %w", table, opts.Filter, err)
+			}
+			opts.FilterProgram = program
+		}
+		if err := setTransformDefaults(table, opts.Transforms); err != nil {
+			return err
+		}
+		if opts.Script.Path != "" {
+			if err := setScriptDefaults(table, &opts.Script); err != nil {
+				return err
+			}
+		}
+		for _, pattern := range append(opts.IncludeColumns, opts.ExcludeColumns...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s column pattern %q: %w", table, pattern, err)
+			}
+		}
+		cfg.Tables[table] = opts
+	}
+
+	matcher, err := newTableMatcher(slices.Collect(maps.Keys(cfg.Tables)), cfg.ExcludedTables)
+	if err != nil {
+		return err
+	}
+	cfg.matcher = matcher
+	return nil
+}
+
+func setPIIKeyDefaults(cfg *PIIKeysConfig) error {
+	for i := range cfg.Keys {
+		key := &cfg.Keys[i]
+		if key.ID == "" || strings.Contains(key.ID, ":") {
+			return fmt.Errorf("CONFIG ERR: pii key %d needs an id without ':'", i)
+		}
+		switch {
+		case key.Env != "":
+			key.Secret = []byte(os.Getenv(key.Env))
+		case key.File != "":
+			data, err := os.ReadFile(key.File)
+			if err != nil {
+				return fmt.Errorf("CONFIG ERR: pii key %s: %w", key.ID, err)
+			}
+			key.Secret = bytes.TrimSpace(data)
+		}
+		if len(key.Secret) < 16 {
+			return fmt.Errorf("CONFIG ERR: pii key %s must be at least 16 bytes, set it through env or file", key.ID)
+		}
+	}
+	if cfg.Active == "" && len(cfg.Keys) > 0 {
+		cfg.Active = cfg.Keys[len(cfg.Keys)-1].ID
+	}
+	if cfg.Active != "" && !cfg.hasKey(cfg.Active) {
+		return fmt.Errorf("CONFIG ERR: active pii key %q is not defined", cfg.Active)
+	}
+	return nil
+}
+
+func setScriptDefaults(table string, cfg *ScriptConfig) error {
+	if cfg.MaxSteps == 0 {
+		cfg.MaxSteps = 1_000_000
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 100 * time.Millisecond
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("CONFIG ERR: table %s script timeout must be positive", table)
+	}
+	program, err := script.Load(cfg.Path, cfg.MaxSteps, cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("CONFIG ERR: table %s script: %w", table, err)
+	}
+	cfg.Program = program
+	return nil
+}
+
+func checkScanPolicy(where, policy string) error {
+	switch policy {
+	case "off", "alert", "mask", "block":
+		return nil
+	}
+	return fmt.Errorf("CONFIG ERR: %s pii_scan must be off, alert, mask or block, got %q", where, policy)
+}
+
+func (c *PIIKeysConfig) hasKey(id string) bool {
+	return slices.ContainsFunc(c.Keys, func(k PIIKey) bool { return k.ID == id })
+}
+
+func checkPIIMasks(table string, masks []PIIMask, keys *PIIKeysConfig, keyring *fieldcrypt.Keyring) error {
+	for i := range masks {
+		mask := &masks[i]
+		column, path, err := parseFieldPath(mask.Field)
+		if err != nil {
+			return fmt.Errorf("CONFIG ERR: table %s mask field %q: %w", table, mask.Field, err)
+		}
+		mask.Column, mask.Path = column, path
+
+		switch mask.Action {
+		case "redact", "hash", "mask_partial":
+		case "hmac", "tokenize":
+			// Tokens keep the value's shape, so unlike hmac they cannot say
+			// which key made them. Pin the key so rotating the active one
+			// does not silently change every token.
+			if mask.Action == "tokenize" && mask.KeyID == "" {
+				return fmt.Errorf("CONFIG ERR: table %s tokenize mask on %s needs a key_id", table, mask.Field)
+			}
+			if mask.KeyID == "" && keys.Active == "" {
+				return fmt.Errorf("CONFIG ERR: table %s %s mask on %s needs pipeline.pii_keys", table, mask.Action, mask.Field)
+			}
+			if mask.KeyID != "" && !keys.hasKey(mask.KeyID) {
+				return fmt.Errorf("CONFIG ERR: table %s mask on %s uses unknown key %q", table, mask.Field, mask.KeyID)
+			}
+		case "encrypt":
+			if keyring == nil {
+				return fmt.Errorf("CONFIG ERR: table %s encrypt mask on %s needs pipeline.encryption.keyring", table, mask.Field)
+			}
+			if mask.KeyID == "" && keyring.Active() == "" {
+				return fmt.Errorf("CONFIG ERR: table %s encrypt mask on %s needs a key_id or an active key in the keyring", table, mask.Field)
+			}
+			if mask.KeyID != "" && !keyring.HasKey(mask.KeyID) {
+				return fmt.Errorf("CONFIG ERR: table %s mask on %s uses unknown key %q", table, mask.Field, mask.KeyID)
+			}
+			if mask.Mode != "" && mask.Mode != "random" && mask.Mode != "deterministic" {
+				return fmt.Errorf("CONFIG ERR: table %s encrypt mode must be random or deterministic, got %q", table, mask.Mode)
+			}
+		default:
+			return fmt.Errorf("CONFIG ERR: table %s mask action must be redact, hash, mask_partial, hmac, tokenize or encrypt, got %q", table, mask.Action)
+		}
+	}
+	return nil
+}
+
+// compileRoute parses a route template. A plain topic name is a template
+// without actions.
+func compileRoute(route string) (*template.Template, error) {
+	if strings.TrimSpace(route) == "" {
+		return nil, fmt.Errorf("route is empty")
+	}
+	tmpl, err := template.New("route").Funcs(RouteFuncs).Option("missingkey=error").Parse(route)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, checkRoute(tmpl)
+}
+
+func setTransformDefaults(table string, transforms []Transform) error {
+	for i := range transforms {
+		t := &transforms[i]
+		if t.Field == "" {
+			return fmt.Errorf("CONFIG ERR: table %s transform %d (%s) needs a field", table, i, t.Action)
+		}
+		switch t.Action {
+		case "rename":
+			if t.To == "" {
+				return fmt.Errorf("CONFIG ERR: table %s rename of %s needs a target name in to", table, t.Field)
+			}
+		case "cast":
+			switch t.To {
+			case "int", "float", "string", "bool":
+			case "timestamp":
+				switch t.Unit {
+				case "":
+					t.Unit = "s"
+				case "s", "ms", "us", "ns":
+				default:
+					return fmt.Errorf("CONFIG ERR: table %s cast of %s unit must be s, ms, us or ns, got %q", table, t.Field, t.Unit)
+				}
+			default:
+				return fmt.Errorf("CONFIG ERR: table %s cast of %s to must be int, float, string, bool or timestamp, got %q", table, t.Field, t.To)
+			}
+		case "set":
+		case "compute":
+			tmpl, err := template.New(t.Field).Option("missingkey=error").Parse(t.Template)
+			if err != nil {
+				return fmt.Errorf("CONFIG ERR: table %s compute of %s: %w", table, t.Field, err)
+			}
+			t.Compiled = tmpl
+		case "flatten":
+			if t.Separator == "" {
+				t.Separator = "_"
+			}
+		case "unnest":
+		default:
+			return fmt.Errorf("CONFIG ERR: table %s transform action must be rename, cast, set, compute, flatten or unnest, got %q", table, t.Action)
+		}
+	}
+	return nil
+}
+
+func setSinkDefaults(cfg *SinkConfig) error {
+	if err := setRetryDefaults(&cfg.Retry); err != nil {
+		return err
+	}
+	if cfg.CircuitBreaker.FailureThreshold > 0 && cfg.CircuitBreaker.OpenTimeout == 0 {
+		cfg.CircuitBreaker.OpenTimeout = 30 * time.Second
+	}
+
+	switch cfg.Type {
+	case "", "kafka":
+		return setKafkaDefaults(cfg)
+	case "nats":
+		return setNATSDefaults(&cfg.NATS)
+	case "redis":
+		setRedisDefaults(&cfg.Redis)
+	case "amqp", "rabbitmq":
+		return setAMQPDefaults(&cfg.AMQP)
+	case "elasticsearch", "opensearch":
+		setElasticsearchDefaults(&cfg.Elasticsearch)
+	case "sqlite":
+		setSQLiteDefaults(&cfg.SQLite)
+	case "grpc":
+		setGRPCDefaults(&cfg.GRPC)
+	case "live":
+		return setLiveDefaults(&cfg.Live)
+	}
+	return nil
+}
+
+// setFanoutDefaults checks a multi-sink config. Required sinks always block
+// when their buffer is full since the slot cannot move past them anyway.
+// Optional sinks always drop: blocking on one would hold back the required
+// sinks, and with them the slot, after all.
+func setFanoutDefaults(sinks []SinkConfig) error {
+	required := 0
+	names := make(map[string]bool)
+	for i := range sinks {
+		cfg := &sinks[i]
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("%s-%d", cfg.Type, i)
+		}
+		if names[cfg.Name] {
+			return fmt.Errorf("CONFIG ERR: duplicate sink name %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		switch cfg.FailurePolicy {
+		case "":
+			cfg.FailurePolicy = "block"
+			if cfg.Optional {
+				cfg.FailurePolicy = "drop"
+			}
+		case "block", "drop":
+		default:
+			return fmt.Errorf("CONFIG ERR: sink %s failure_policy must be block or drop, got %q", cfg.Name, cfg.FailurePolicy)
+		}
+		if cfg.Optional && cfg.FailurePolicy == "block" {
+			return fmt.Errorf("CONFIG ERR: sink %s is optional and cannot use failure_policy block", cfg.Name)
+		}
+		if !cfg.Optional {
+			required++
+			if cfg.FailurePolicy == "drop" {
+				return fmt.Errorf("CONFIG ERR: sink %s is required and cannot use failure_policy drop", cfg.Name)
+			}
+		}
+		if cfg.Buffer == 0 {
+			cfg.Buffer = 1000
+		}
+
+		if err := setSinkDefaults(cfg); err != nil {
+			return err
+		}
+	}
+	if required == 0 {
+		return fmt.Errorf("CONFIG ERR: at least one sink must be required to acknowledge the slot")
+	}
+	return nil
+}
+
+func setRetryDefaults(cfg *RetryConfig) error {
+	if cfg.MaxAttempts != 0 || len(cfg.RetryableErrors) > 0 {
+		return fmt.Errorf("CONFIG ERR: retry.max_attempts and retry.retryable_errors are no longer supported, records are retried in order by the Kafka client; bound retries with producer.retries or producer.delivery_timeout")
+	}
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = 200 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	if cfg.Multiplier == 0 {
+		cfg.Multiplier = 2
+	}
+	if cfg.InitialBackoff < 0 || cfg.MaxBackoff < cfg.InitialBackoff || cfg.Multiplier < 1 {
+		return fmt.Errorf("CONFIG ERR: retry needs 0 < initial_backoff <= max_backoff and multiplier >= 1")
+	}
+	return nil
+}
+
+func setDLQDefaults(cfg *Config) error {
+	dlq := &cfg.DLQ
+	switch dlq.Type {
+	case "":
+	case "file":
+		if dlq.Path == "" {
+			dlq.Path = "./data/dlq.jsonl"
+		}
+	case "kafka":
+		if dlq.Topic == "" {
+			dlq.Topic = "cdc.dlq"
+		}
+		if kafka, ok := cfg.KafkaSink(); ok && len(dlq.Brokers) == 0 {
+			dlq.Brokers = kafka.Brokers
+		}
+		if len(dlq.Brokers) == 0 {
+			return fmt.Errorf("CONFIG ERR: kafka dlq needs brokers")
+		}
+	default:
+		return fmt.Errorf("CONFIG ERR: dlq type must be file or kafka, got %q", dlq.Type)
+	}
+	if dlq.MaxRetries == 0 {
+		dlq.MaxRetries = 5
+	}
+	if dlq.MaxRetries < 0 {
+		return fmt.Errorf("CONFIG ERR: dlq max_retries cannot be negative")
+	}
+	switch dlq.OnFailure {
+	case "":
+		dlq.OnFailure = "block"
+	case "block", "drop":
+	default:
+		return fmt.Errorf("CONFIG ERR: dlq on_failure must be block or drop, got %q", dlq.OnFailure)
+	}
+	return nil
+}
+
+// KafkaSink returns the Kafka sink config, which is either the single sink or
+// the first Kafka entry under sinks.
+func (c *Config) KafkaSink() (SinkConfig, bool) {
+	if len(c.Sinks) == 0 {
+		return c.Sink, c.Sink.Type == "" || c.Sink.Type == "kafka"
+	}
+	for _, s := range c.Sinks {
+		if s.Type == "kafka" {
+			return s, true
+		}
+	}
+	return SinkConfig{}, false
+}
+
+func setKafkaDefaults(cfg *SinkConfig) error {
+	switch cfg.Tombstones {
+	case "", "none":
+		cfg.Tombstones = "none"
+	case "append", "only":
+	default:
+		return fmt.Errorf("CONFIG ERR: delete_tombstones must be none, append or only, got %q", cfg.Tombstones)
+	}
+	switch cfg.Compression {
+	case "", "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		return fmt.Errorf("CONFIG ERR: compression must be none, gzip, snappy, lz4 or zstd, got %q", cfg.Compression)
+	}
+	switch cfg.Partitioner {
+	case "":
+		cfg.Partitioner = "murmur2"
+	case "murmur2", "sticky", "round_robin":
+	default:
+		return fmt.Errorf("CONFIG ERR: partitioner must be murmur2, sticky or round_robin, got %q", cfg.Partitioner)
+	}
+	matcher, err := newTableMatcher(slices.Collect(maps.Keys(cfg.PartitionKeys)), nil)
+	if err != nil {
+		return err
+	}
+	cfg.keyMatcher = matcher
+	if err := setProducerDefaults(&cfg.Producer); err != nil {
+		return err
+	}
+	if cfg.ClaimCheck.Threshold > 0 {
+		if err := setBlobStoreDefaults(&cfg.ClaimCheck.Store); err != nil {
+			return err
+		}
+	}
+	return checkTopics(cfg.Topics)
+}
+
+// setProducerDefaults rejects combinations that would let retried batches
+// overtake later ones and break per-key ordering. Delivery is bounded by
+// default, so a record the brokers keep refusing eventually fails and counts
+// against the circuit breaker instead of being retried forever.
+func setProducerDefaults(cfg *ProducerConfig) error {
+	switch cfg.Acks {
+	case "":
+		cfg.Acks = "all"
+	case "all", "leader", "none":
+	default:
+		return fmt.Errorf("CONFIG ERR: producer acks must be all, leader or none, got %q", cfg.Acks)
+	}
+	if cfg.Idempotent == nil {
+		idempotent := cfg.Acks == "all"
+		cfg.Idempotent = &idempotent
+	}
+
+	if *cfg.Idempotent {
+		if cfg.Acks != "all" {
+			return fmt.Errorf("CONFIG ERR: idempotent producer requires acks all, got %q", cfg.Acks)
+		}
+		if cfg.MaxInFlight > 5 {
+			return fmt.Errorf("CONFIG ERR: idempotent producer allows at most 5 in-flight requests, got %d", cfg.MaxInFlight)
+		}
+	} else if cfg.MaxInFlight > 1 && (cfg.Retries == nil || *cfg.Retries > 0) {
+		return fmt.Errorf("CONFIG ERR: max_in_flight %d with retries can reorder records, enable idempotence, use 1 or set retries to 0", cfg.MaxInFlight)
+	}
+
+	if cfg.MaxInFlight < 0 || cfg.MaxBufferedRecords < 0 || (cfg.Retries != nil && *cfg.Retries < 0) {
+		return fmt.Errorf("CONFIG ERR: producer max_in_flight, retries and max_buffered_records cannot be negative")
+	}
+	if cfg.DeliveryTimeout == 0 {
+		cfg.DeliveryTimeout = 2 * time.Minute
+	}
+	if cfg.DeliveryTimeout < time.Second {
+		return fmt.Errorf("CONFIG ERR: producer delivery_timeout must be at least 1s, got %s", cfg.DeliveryTimeout)
+	}
+	if cfg.RequestTimeout > cfg.DeliveryTimeout {
+		return fmt.Errorf("CONFIG ERR: producer request_timeout %s is longer than delivery_timeout %s", cfg.RequestTimeout, cfg.DeliveryTimeout)
+	}
+	return nil
+}
+
+func setBlobStoreDefaults(cfg *BlobStoreConfig) error {
+	switch cfg.Type {
+	case "", "dir":
+		cfg.Type = "dir"
+		if cfg.Path == "" {
+			cfg.Path = "./data/claims"
+		}
+	case "s3":
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return fmt.Errorf("CONFIG ERR: s3 blob store needs an endpoint and a bucket")
+		}
+		cfg.AccessKey = os.Getenv("S3_ACCESS_KEY")
+		cfg.SecretKey = os.Getenv("S3_SECRET_KEY")
+	default:
+		return fmt.Errorf("CONFIG ERR: blob store type must be dir or s3, got %q", cfg.Type)
+	}
+	return nil
+}
+
+func checkTopics(topics map[string]TopicConfig) error {
+	for name, topic := range topics {
+		switch topic.CleanupPolicy {
+		case "", "delete", "compact", "compact,delete":
+		default:
+			return fmt.Errorf("CONFIG ERR: topic %s cleanup_policy must be delete, compact or \"compact,delete\", got %q", name, topic.CleanupPolicy)
+		}
+		if topic.ReplicationFactor > 0 && topic.MinInsyncReplicas > int(topic.ReplicationFactor) {
+			return fmt.Errorf("CONFIG ERR: topic %s min_insync_replicas %d is above its replication_factor %d", name, topic.MinInsyncReplicas, topic.ReplicationFactor)
+		}
+	}
+	return nil
+}
+
+func setNATSDefaults(cfg *NATSConfig) error {
+	if cfg.Stream == "" {
+		return fmt.Errorf("CONFIG ERR: nats sink requires a stream name")
+	}
+	if cfg.URL == "" {
+		cfg.URL = "nats://127.0.0.1:4222"
+	}
+	if cfg.MaxPending == 0 {
+		cfg.MaxPending = 256
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 10
+	}
+	if cfg.AckTimeout == 0 {
+		cfg.AckTimeout = 5 * time.Second
+	}
+	return nil
+}
+
+func setRedisDefaults(cfg *RedisConfig) {
+	cfg.Password = os.Getenv("REDIS_PASSWORD")
+	if cfg.Addr == "" {
+		cfg.Addr = "localhost:6379"
+	}
+	if cfg.CachePrefix == "" {
+		cfg.CachePrefix = "cdc:row"
+	}
+}
+
+func setAMQPDefaults(cfg *AMQPConfig) error {
+	if cfg.Exchange == "" {
+		return fmt.Errorf("CONFIG ERR: amqp sink requires an exchange name")
+	}
+	cfg.Password = os.Getenv("AMQP_PASSWORD")
+	if cfg.URL == "" {
+		cfg.URL = "amqp://localhost:5672/"
+	}
+	switch cfg.ExchangeType {
+	case "":
+		cfg.ExchangeType = "topic"
+	case "topic", "direct", "fanout", "headers":
+	default:
+		return fmt.Errorf("CONFIG ERR: amqp exchange_type must be topic, direct, fanout or headers, got %q", cfg.ExchangeType)
+	}
+	if cfg.MaxPending == 0 {
+		cfg.MaxPending = 256
+	}
+	return nil
+}
+
+func setElasticsearchDefaults(cfg *ElasticsearchConfig) {
+	cfg.Password = os.Getenv("ES_PASSWORD")
+	if cfg.URL == "" {
+		cfg.URL = "http://localhost:9200"
+	}
+	if cfg.IndexTemplate == "" {
+		cfg.IndexTemplate = "{{.Route}}"
+	}
+	if cfg.BulkActions == 0 {
+		cfg.BulkActions = 500
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = 30 * time.Second
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+}
+
+func setSQLiteDefaults(cfg *SQLiteConfig) {
+	if cfg.Path == "" {
+		cfg.Path = "./data/mirror.db"
+	}
+}
+
+func setGRPCDefaults(cfg *GRPCConfig) {
+	if cfg.Addr == "" {
+		cfg.Addr = ":50051"
+	}
+	if cfg.ReplayBuffer == 0 {
+		cfg.ReplayBuffer = 10000
+	}
+	if cfg.ClientBuffer == 0 {
+		cfg.ClientBuffer = 1000
+	}
+}
+
+func setLiveDefaults(cfg *LiveConfig) error {
+	switch cfg.SlowClient {
+	case "":
+		cfg.SlowClient = "drop"
+	case "drop", "sample":
+	default:
+		return fmt.Errorf("CONFIG ERR: live slow_client must be drop or sample, got %q", cfg.SlowClient)
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ":8090"
+	}
+	if cfg.ReplayBuffer == 0 {
+		cfg.ReplayBuffer = 1000
+	}
+	if cfg.ClientBuffer == 0 {
+		cfg.ClientBuffer = 256
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 5 * time.Second
+	}
+	return nil
+}
+
 func verifyConfig(config CDCConfig) error {
 	return nil
 }
@@ -0,0 +1,128 @@
+package configs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPIIMasks(t *testing.T) {
+	keys := &PIIKeysConfig{Active: "k2", Keys: []PIIKey{{ID: "k1"}, {ID: "k2"}}}
+	tests := []struct {
+		mask PIIMask
+		err  string
+	}{
+		{mask: PIIMask{Field: "ssn", Action: "hmac"}},
+		{mask: PIIMask{Field: "ssn", Action: "hmac", KeyID: "k1"}},
+		{mask: PIIMask{Field: "email", Action: "tokenize", KeyID: "k1"}},
+		{mask: PIIMask{Field: "email", Action: "tokenize"}, err: "needs a key_id"},
+		{mask: PIIMask{Field: "email", Action: "tokenize", KeyID: "k9"}, err: "unknown key"},
+		{mask: PIIMask{Field: "email", Action: "shred"}, err: "mask action must be"},
+	}
+	for _, tt := range tests {
+		err := checkPIIMasks("users", []PIIMask{tt.mask}, keys, nil)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s on %s: %v", tt.mask.Action, tt.mask.Field, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s on %s: got %v, want an error containing %q", tt.mask.Action, tt.mask.Field, err, tt.err)
+		}
+	}
+}
+
+func TestSetFanoutDefaults(t *testing.T) {
+	feed := func(name string, optional bool, policy string) SinkConfig {
+		return SinkConfig{Type: "grpc", Name: name, Optional: optional, FailurePolicy: policy}
+	}
+	tests := []struct {
+		name  string
+		sinks []SinkConfig
+		err   string
+	}{
+		{name: "defaults", sinks: []SinkConfig{feed("a", false, ""), feed("b", true, "")}},
+		{name: "explicit", sinks: []SinkConfig{feed("a", false, "block"), feed("b", true, "drop")}},
+		{name: "required drop", sinks: []SinkConfig{feed("a", false, "drop")}, err: "required and cannot use failure_policy drop"},
+		{name: "optional block", sinks: []SinkConfig{feed("a", false, ""), feed("b", true, "block")}, err: "optional and cannot use failure_policy block"},
+		{name: "unknown policy", sinks: []SinkConfig{feed("a", false, "retry")}, err: "must be block or drop"},
+		{name: "none required", sinks: []SinkConfig{feed("a", true, "")}, err: "at least one sink must be required"},
+		{name: "duplicate names", sinks: []SinkConfig{feed("a", false, ""), feed("a", false, "")}, err: "duplicate sink name"},
+	}
+	for _, tt := range tests {
+		err := setFanoutDefaults(tt.sinks)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	sinks := []SinkConfig{feed("a", false, ""), feed("b", true, "")}
+	if err := setFanoutDefaults(sinks); err != nil {
+		t.Fatal(err)
+	}
+	if sinks[0].FailurePolicy != "block" || sinks[1].FailurePolicy != "drop" {
+		t.Errorf("policies = %s, %s, want block, drop", sinks[0].FailurePolicy, sinks[1].FailurePolicy)
+	}
+}
+
+func TestSetKafkaDefaults(t *testing.T) {
+	on, off := true, false
+	zero, three := 0, 3
+	tests := []struct {
+		name string
+		cfg  SinkConfig
+		err  string
+	}{
+		{name: "defaults", cfg: SinkConfig{}},
+		{name: "idempotent with acks all", cfg: SinkConfig{Producer: ProducerConfig{Acks: "all", Idempotent: &on, MaxInFlight: 5}}},
+		{name: "idempotent with leader acks", cfg: SinkConfig{Producer: ProducerConfig{Acks: "leader", Idempotent: &on}}, err: "requires acks all"},
+		{name: "idempotent with no acks", cfg: SinkConfig{Producer: ProducerConfig{Acks: "none", Idempotent: &on}}, err: "requires acks all"},
+		{name: "idempotent with 6 in flight", cfg: SinkConfig{Producer: ProducerConfig{MaxInFlight: 6}}, err: "at most 5 in-flight"},
+		{name: "leader acks turn idempotence off", cfg: SinkConfig{Producer: ProducerConfig{Acks: "leader", MaxInFlight: 1}}},
+		{name: "not idempotent, in flight with retries", cfg: SinkConfig{Producer: ProducerConfig{Idempotent: &off, MaxInFlight: 2}}, err: "can reorder records"},
+		{name: "not idempotent, in flight with bounded retries", cfg: SinkConfig{Producer: ProducerConfig{Idempotent: &off, MaxInFlight: 2, Retries: &three}}, err: "can reorder records"},
+		{name: "not idempotent, in flight without retries", cfg: SinkConfig{Producer: ProducerConfig{Idempotent: &off, MaxInFlight: 2, Retries: &zero}}},
+		{name: "not idempotent, one in flight", cfg: SinkConfig{Producer: ProducerConfig{Idempotent: &off, MaxInFlight: 1}}},
+		{name: "negative in flight", cfg: SinkConfig{Producer: ProducerConfig{MaxInFlight: -1}}, err: "cannot be negative"},
+		{name: "unknown acks", cfg: SinkConfig{Producer: ProducerConfig{Acks: "some"}}, err: "acks must be"},
+		{name: "short delivery timeout", cfg: SinkConfig{Producer: ProducerConfig{DeliveryTimeout: time.Millisecond}}, err: "at least 1s"},
+		{name: "request timeout over delivery timeout", cfg: SinkConfig{Producer: ProducerConfig{RequestTimeout: time.Minute, DeliveryTimeout: 30 * time.Second}}, err: "longer than delivery_timeout"},
+		{name: "gzip", cfg: SinkConfig{Compression: "gzip"}},
+		{name: "snappy", cfg: SinkConfig{Compression: "snappy"}},
+		{name: "lz4", cfg: SinkConfig{Compression: "lz4"}},
+		{name: "zstd", cfg: SinkConfig{Compression: "zstd"}},
+		{name: "no compression", cfg: SinkConfig{Compression: "none"}},
+		{name: "misspelled compression", cfg: SinkConfig{Compression: "zstandard"}, err: "compression must be"},
+		{name: "upper case compression", cfg: SinkConfig{Compression: "GZIP"}, err: "compression must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setKafkaDefaults(&tt.cfg)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSetProducerDefaults(t *testing.T) {
+	cfg := ProducerConfig{}
+	if err := setProducerDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Acks != "all" || cfg.Idempotent == nil || !*cfg.Idempotent || cfg.DeliveryTimeout != 2*time.Minute {
+		t.Errorf("defaults = acks %s, idempotent %v, delivery_timeout %s, want all, true and 2m", cfg.Acks, *cfg.Idempotent, cfg.DeliveryTimeout)
+	}
+
+	cfg = ProducerConfig{Acks: "leader"}
+	if err := setProducerDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if *cfg.Idempotent {
+		t.Error("idempotence defaulted on with leader acks")
+	}
+}
@@ -0,0 +1,64 @@
+package configs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathStep is one step into a JSON document: an object key, an array index,
+// or [*] for every element of an array or every value of an object.
+type PathStep struct {
+	Key   string
+	Index int
+	Any   bool
+}
+
+func (s PathStep) IsIndex() bool {
+	return s.Key == "" && !s.Any
+}
+
+// parseFieldPath splits a mask field into its column and the path inside
+// the column's JSON value:
+//
+//	email                  -> email, no path
+//	profile.contact.email  -> profile, contact.email
+//	items[*].card_number   -> items, [*].card_number
+//	phones[0]              -> phones, [0]
+func parseFieldPath(field string) (string, []PathStep, error) {
+	var steps []PathStep
+	for i, part := range strings.Split(field, ".") {
+		key, rest, bracket := strings.Cut(part, "[")
+		if key == "" && (i == 0 || !bracket) {
+			return "", nil, fmt.Errorf("empty segment")
+		}
+		if bracket && rest == "" {
+			return "", nil, fmt.Errorf("missing ]")
+		}
+		if key != "" {
+			steps = append(steps, PathStep{Key: key})
+		}
+		for rest != "" {
+			inner, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return "", nil, fmt.Errorf("missing ]")
+			}
+			switch n, err := strconv.Atoi(inner); {
+			case inner == "*":
+				steps = append(steps, PathStep{Any: true})
+			case err == nil && n >= 0:
+				steps = append(steps, PathStep{Index: n})
+			default:
+				return "", nil, fmt.Errorf("index must be * or a non-negative number, got %q", inner)
+			}
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return "", nil, fmt.Errorf("unexpected %q after ]", after)
+			}
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	if len(steps) == 0 || steps[0].Key == "" {
+		return "", nil, fmt.Errorf("must start with a column name")
+	}
+	return steps[0].Key, steps[1:], nil
+}
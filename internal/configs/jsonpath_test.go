@@ -0,0 +1,45 @@
+package configs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		field  string
+		column string
+		path   []PathStep
+		err    bool
+	}{
+		{field: "email", column: "email"},
+		{field: "profile.contact.email", column: "profile", path: []PathStep{{Key: "contact"}, {Key: "email"}}},
+		{field: "items[*].card_number", column: "items", path: []PathStep{{Any: true}, {Key: "card_number"}}},
+		{field: "phones[0]", column: "phones", path: []PathStep{{Index: 0}}},
+		{field: "grid[1][2]", column: "grid", path: []PathStep{{Index: 1}, {Index: 2}}},
+		{field: "a..b", err: true},
+		{field: ".a", err: true},
+		{field: "[0]", err: true},
+		{field: "a[", err: true},
+		{field: "a[1", err: true},
+		{field: "a[-1]", err: true},
+		{field: "a[x]", err: true},
+		{field: "a[1]b", err: true},
+	}
+	for _, tt := range tests {
+		column, path, err := parseFieldPath(tt.field)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseFieldPath(%q) = %q %v, want an error", tt.field, column, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFieldPath(%q): %v", tt.field, err)
+			continue
+		}
+		if column != tt.column || !slices.Equal(path, tt.path) {
+			t.Errorf("parseFieldPath(%q) = %q %v, want %q %v", tt.field, column, path, tt.column, tt.path)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package configs
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+)
+
+// maxRouteLen is Kafka's limit on topic names.
+const maxRouteLen = 249
+
+func isRouteChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
+
+// RouteName makes a rendered route a legal Kafka topic name, which keeps it
+// usable as a NATS subject prefix, Redis stream or index name too: other
+// characters become underscores and it is cut at 249 bytes. Row values in a
+// route such as orders.{{.Row.region}} may contain anything.
+func RouteName(route string) string {
+	route = strings.Map(func(r rune) rune {
+		if isRouteChar(r) {
+			return r
+		}
+		return '_'
+	}, route)
+	if len(route) > maxRouteLen {
+		route = route[:maxRouteLen]
+	}
+	if route == "." || route == ".." {
+		return "_"
+	}
+	return route
+}
+
+// routes lists the configured routes as written: default_route, then every
+// route_to and routes target, without duplicates.
+func (c *PipelineConfig) routes() []string {
+	var routes []string
+	for _, opts := range c.Tables {
+		if opts.Route != "" {
+			routes = append(routes, opts.Route)
+		}
+		for _, rule := range opts.Routes {
+			routes = append(routes, rule.To)
+		}
+	}
+	slices.Sort(routes)
+	routes = slices.DeleteFunc(slices.Compact(routes), func(r string) bool { return r == c.DefaultRoute })
+	return append([]string{c.DefaultRoute}, routes...)
+}
+
+// checkKafkaTopics matches the topics of Kafka sinks to the pipeline's
+// routes. Settings are keyed by route, so a key that is no route would never
+// apply, and a route without settings would leave its topics to broker
+// auto-creation while the others are managed.
+func checkKafkaTopics(cfg *Config) error {
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{cfg.Sink}
+	}
+	routes := cfg.Pipeline.routes()
+	for _, sink := range sinks {
+		if (sink.Type != "" && sink.Type != "kafka") || len(sink.Topics) == 0 {
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(sink.Topics)) {
+			if !slices.Contains(routes, key) {
+				return fmt.Errorf("CONFIG ERR: topics entry %q is not a configured route, key topic settings by default_route, route_to or a routes target as written", key)
+			}
+		}
+		for _, route := range routes {
+			if _, ok := sink.Topics[route]; !ok {
+				return fmt.Errorf("CONFIG ERR: route %q has no entry under topics", route)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRoute rejects literal text that RouteName would rewrite, so a typo
+// in a route is reported instead of silently renamed. Whitespace around the
+// route is trimmed when it is rendered and allowed.
+func checkRoute(tmpl *template.Template) error {
+	root := tmpl.Tree.Root.Nodes
+	var err error
+	walkRoute(tmpl.Tree.Root, func(node parse.Node) {
+		text, ok := node.(*parse.TextNode)
+		if !ok || err != nil {
+			return
+		}
+		s := string(text.Text)
+		if node == root[0] {
+			s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		}
+		if node == root[len(root)-1] {
+			s = strings.TrimRightFunc(s, unicode.IsSpace)
+		}
+		for _, r := range s {
+			if !isRouteChar(r) {
+				err = fmt.Errorf("route has %q, only letters, digits, '.', '_' and '-' are allowed", r)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// usesRow reports whether a route renders row values, which may be missing
+// or empty for some events.
+func usesRow(tmpl *template.Template) bool {
+	found := false
+	walkRoute(tmpl.Tree.Root, func(node parse.Node) {
+		var idents []string
+		switch n := node.(type) {
+		case *parse.FieldNode:
+			idents = n.Ident
+		case *parse.VariableNode:
+			idents = n.Ident[1:]
+		case *parse.ChainNode:
+			idents = n.Field
+		case *parse.DotNode:
+			// the whole data, e.g. passed to a function
+			found = true
+		}
+		if len(idents) > 0 && (idents[0] == "Row" || idents[0] == "Before" || idents[0] == "After") {
+			found = true
+		}
+	})
+	return found
+}
+
+func walkRoute(node parse.Node, visit func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	visit(node)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkRoute(child, visit)
+		}
+	case *parse.ActionNode:
+		walkRoute(n.Pipe, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkRoute(cmd, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkRoute(arg, visit)
+		}
+	case *parse.ChainNode:
+		walkRoute(n.Node, visit)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, visit)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, visit func(parse.Node)) {
+	walkRoute(n.Pipe, visit)
+	if n.List != nil {
+		walkRoute(n.List, visit)
+	}
+	if n.ElseList != nil {
+		walkRoute(n.ElseList, visit)
+	}
+}
@@ -0,0 +1,98 @@
+package configs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"cdc.public.users", "cdc.public.users"},
+		{"orders.EU-west_1", "orders.EU-west_1"},
+		{"orders.São Paulo/2", "orders.S_o_Paulo_2"},
+		{"..", "_"},
+		{"", ""},
+		{strings.Repeat("a", 300), strings.Repeat("a", 249)},
+	}
+	for _, tt := range tests {
+		if got := RouteName(tt.in); got != tt.want {
+			t.Errorf("RouteName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRoute(t *testing.T) {
+	tests := []struct {
+		route   string
+		usesRow bool
+		err     bool
+	}{
+		{route: "cdc.{{.NameSpace}}.{{.Table}}.{{lower .Operation}}"},
+		{route: "orders.{{.Row.region}}", usesRow: true},
+		{route: "{{if .After}}{{.After.id}}{{end}}.x", usesRow: true},
+		{route: "{{with $.Before}}old{{end}}", usesRow: true},
+		{route: "{{ .Table }}"},
+		{route: "cdc:users", err: true},
+		{route: "orders {{.Table}}", err: true},
+		{route: "  ", err: true},
+		{route: "{{.Table", err: true},
+	}
+	for _, tt := range tests {
+		tmpl, err := compileRoute(tt.route)
+		if tt.err {
+			if err == nil {
+				t.Errorf("compileRoute(%q) succeeded, want an error", tt.route)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("compileRoute(%q): %v", tt.route, err)
+			continue
+		}
+		if got := usesRow(tmpl); got != tt.usesRow {
+			t.Errorf("usesRow(%q) = %v, want %v", tt.route, got, tt.usesRow)
+		}
+	}
+}
+
+func TestDefaultRouteMustNotUseRow(t *testing.T) {
+	cfg := PipelineConfig{DefaultRoute: "cdc.{{.Row.tenant}}"}
+	if err := setPipelineDefaults(&cfg); err == nil {
+		t.Fatal("expected a row-dependent default_route to be rejected")
+	}
+	cfg = PipelineConfig{DefaultRoute: "cdc.{{.Table}}"}
+	if err := setPipelineDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckKafkaTopics(t *testing.T) {
+	pipeline := PipelineConfig{
+		DefaultRoute: "cdc.{{.Table}}",
+		Tables: map[string]TableOptions{
+			"orders": {Route: "orders", Routes: []RouteRule{{When: "true", To: "orders.{{.Row.region}}"}}},
+			"users":  {Route: "cdc.{{.Table}}"},
+		},
+	}
+	all := map[string]TopicConfig{"cdc.{{.Table}}": {}, "orders": {}, "orders.{{.Row.region}}": {}}
+	tests := []struct {
+		name   string
+		topics map[string]TopicConfig
+		typ    string
+		err    bool
+	}{
+		{name: "every route", topics: all},
+		{name: "no topics", topics: nil},
+		{name: "topic name instead of route", topics: map[string]TopicConfig{"cdc.{{.Table}}": {}, "orders": {}, "orders.eu": {}}, err: true},
+		{name: "route left out", topics: map[string]TopicConfig{"cdc.{{.Table}}": {}, "orders": {}}, err: true},
+		{name: "not kafka", topics: map[string]TopicConfig{"users": {}}, typ: "nats"},
+	}
+	for _, tt := range tests {
+		cfg := Config{Pipeline: pipeline, Sinks: []SinkConfig{{Type: tt.typ, Topics: tt.topics}}}
+		if err := checkKafkaTopics(&cfg); (err != nil) != tt.err {
+			t.Errorf("%s: checkKafkaTopics = %v, want error %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package configs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// SubjectToken makes s usable as one token of a NATS subject or AMQP routing
+// key: dots, whitespace and the wildcards of both become underscores, and an
+// empty token becomes a single underscore.
+func SubjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '*' || r == '>' || r == '#' || unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// SubjectRoute cleans a rendered route token by token, keeping its dots.
+func SubjectRoute(route string) string {
+	tokens := strings.Split(route, ".")
+	for i, token := range tokens {
+		tokens[i] = SubjectToken(token)
+	}
+	return strings.Join(tokens, ".")
+}
+
+// routeSubjects returns subject patterns covering every subject the
+// pipeline's routes can publish to. A route that renders row values is
+// covered from its last dot before the first action; routes that start with
+// an action can publish anywhere and are returned in open.
+func (c *PipelineConfig) routeSubjects() (subjects, open []string) {
+	for _, route := range c.routes() {
+		static := route
+		if i := strings.Index(route, "{{"); i >= 0 {
+			dot := strings.LastIndex(route[:i], ".")
+			if dot <= 0 {
+				open = append(open, route)
+				continue
+			}
+			static = route[:dot]
+		}
+		subject := SubjectRoute(static) + ".>"
+		if !slices.Contains(subjects, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+	slices.Sort(subjects)
+	return subjects, open
+}
+
+// SubjectsCover reports whether every subject matching pattern also matches
+// one of subjects.
+func SubjectsCover(subjects []string, pattern string) bool {
+	return slices.ContainsFunc(subjects, func(s string) bool {
+		return subjectCovers(s, pattern)
+	})
+}
+
+func subjectCovers(subject, pattern string) bool {
+	s := strings.Split(subject, ".")
+	p := strings.Split(pattern, ".")
+	for i, token := range s {
+		if i >= len(p) {
+			return false
+		}
+		switch {
+		case token == ">":
+			return true
+		case p[i] == ">":
+			return false
+		case token != "*" && token != p[i]:
+			return false
+		}
+	}
+	return len(s) == len(p)
+}
+
+// checkNATSSubjects gives NATS sinks without subjects a stream that covers
+// the configured routes, and rejects subjects that leave a route out, since
+// JetStream would never ack what is published there.
+func checkNATSSubjects(cfg *Config) error {
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{cfg.Sink}
+	}
+	for i := range sinks {
+		if sinks[i].Type != "nats" {
+			continue
+		}
+		nats := &sinks[i].NATS
+		subjects, open := cfg.Pipeline.routeSubjects()
+		if len(nats.Subjects) == 0 {
+			if len(open) > 0 {
+				return fmt.Errorf("CONFIG ERR: nats stream subjects cannot be derived from route %q, set nats.subjects", open[0])
+			}
+			nats.Subjects = subjects
+			continue
+		}
+		for _, subject := range subjects {
+			if !SubjectsCover(nats.Subjects, subject) {
+				return fmt.Errorf("CONFIG ERR: nats subjects %v do not cover route subject %s", nats.Subjects, subject)
+			}
+		}
+	}
+	if len(cfg.Sinks) == 0 {
+		cfg.Sink = sinks[0]
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package configs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSubjectCovers(t *testing.T) {
+	tests := []struct {
+		subject string
+		pattern string
+		want    bool
+	}{
+		{"cdc.>", "cdc.>", true},
+		{"cdc.>", "cdc.users.>", true},
+		{">", "orders.>", true},
+		{"cdc.users.>", "cdc.>", false},
+		{"cdc.*", "cdc.users", true},
+		{"cdc.*", "cdc.users.>", false},
+		{"cdc.*.>", "cdc.users.>", true},
+		{"orders.>", "cdc.>", false},
+		{"cdc", "cdc.>", false},
+	}
+	for _, tt := range tests {
+		if got := subjectCovers(tt.subject, tt.pattern); got != tt.want {
+			t.Errorf("subjectCovers(%q, %q) = %v, want %v", tt.subject, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRouteSubjects(t *testing.T) {
+	cfg := PipelineConfig{
+		DefaultRoute: "cdc",
+		Tables: map[string]TableOptions{
+			"orders": {
+				Route:  "orders.{{.Row.region}}",
+				Routes: []RouteRule{{When: "true", To: "vip orders"}},
+			},
+			"audit": {Route: "{{.Table}}.log"},
+		},
+	}
+	subjects, open := cfg.routeSubjects()
+	if want := []string{"cdc.>", "orders.>", "vip_orders.>"}; !slices.Equal(subjects, want) {
+		t.Errorf("subjects = %v, want %v", subjects, want)
+	}
+	if want := []string{"{{.Table}}.log"}; !slices.Equal(open, want) {
+		t.Errorf("open = %v, want %v", open, want)
+	}
+}
+
+func TestCheckNATSSubjects(t *testing.T) {
+	pipeline := PipelineConfig{
+		DefaultRoute: "cdc",
+		Tables:       map[string]TableOptions{"orders": {Route: "orders"}},
+	}
+
+	cfg := Config{Pipeline: pipeline, Sink: SinkConfig{Type: "nats"}}
+	if err := checkNATSSubjects(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cdc.>", "orders.>"}; !slices.Equal(cfg.Sink.NATS.Subjects, want) {
+		t.Errorf("derived subjects = %v, want %v", cfg.Sink.NATS.Subjects, want)
+	}
+
+	cfg = Config{Pipeline: pipeline, Sinks: []SinkConfig{{Type: "nats", NATS: NATSConfig{Subjects: []string{"cdc.>"}}}}}
+	if err := checkNATSSubjects(&cfg); err == nil {
+		t.Error("expected subjects without orders.> to be rejected")
+	}
+}
@@ -0,0 +1,188 @@
+package configs
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// tablePattern is one key of pipeline.tables or entry of excluded_tables.
+// Keys without a schema match the table in any schema.
+//
+//	users           exact table name
+//	public.users    exact schema-qualified name
+//	sales.*         glob (path.Match syntax)
+//	/^t_\d+\.log$/  regular expression, always against schema.table
+type tablePattern struct {
+	key       string
+	glob      string
+	re        *regexp.Regexp
+	qualified bool
+}
+
+func parseTablePattern(key string) (tablePattern, error) {
+	p := tablePattern{key: key, qualified: strings.Contains(key, ".")}
+	switch {
+	case len(key) > 2 && strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/"):
+		re, err := regexp.Compile("^(?:" + key[1:len(key)-1] + ")$")
+		if err != nil {
+			return p, err
+		}
+		p.re = re
+		p.qualified = true
+	case strings.ContainsAny(key, "*?["):
+		if _, err := path.Match(key, ""); err != nil {
+			return p, err
+		}
+		p.glob = key
+	}
+	return p, nil
+}
+
+func (p tablePattern) isExact() bool {
+	return p.re == nil && p.glob == ""
+}
+
+func (p tablePattern) matches(schema, table string) bool {
+	name := table
+	if p.qualified {
+		name = schema + "." + table
+	}
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.glob != "":
+		ok, _ := path.Match(p.glob, name)
+		return ok
+	default:
+		return p.key == name
+	}
+}
+
+// tableMatcher resolves an event's table to the config key that covers it,
+// for pipeline.tables and for a Kafka sink's partition_keys. Precedence,
+// first match wins:
+//
+//  1. exact schema.table
+//  2. exact table
+//  3. globs, schema-qualified before unqualified, then longer patterns
+//     before shorter ones, then alphabetically
+//  4. regular expressions, alphabetically
+type tableMatcher struct {
+	exact    map[string]bool
+	patterns []tablePattern
+	excluded []tablePattern
+	cache    sync.Map
+}
+
+func newTableMatcher(keys []string, excluded []string) (*tableMatcher, error) {
+	m := &tableMatcher{exact: make(map[string]bool)}
+	for _, key := range keys {
+		p, err := parseTablePattern(key)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG ERR: table pattern %q: %w", key, err)
+		}
+		if p.isExact() {
+			m.exact[key] = true
+		} else {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	slices.SortFunc(m.patterns, func(a, b tablePattern) int {
+		if (a.re == nil) != (b.re == nil) {
+			if a.re == nil {
+				return -1
+			}
+			return 1
+		}
+		if a.re == nil && a.qualified != b.qualified {
+			if a.qualified {
+				return -1
+			}
+			return 1
+		}
+		if a.re == nil && len(a.key) != len(b.key) {
+			return cmp.Compare(len(b.key), len(a.key))
+		}
+		return strings.Compare(a.key, b.key)
+	})
+
+	for _, key := range excluded {
+		p, err := parseTablePattern(key)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG ERR: excluded table pattern %q: %w", key, err)
+		}
+		m.excluded = append(m.excluded, p)
+	}
+	return m, nil
+}
+
+// lookup returns the matching key.
+func (m *tableMatcher) lookup(schema, table string) (string, bool) {
+	qualified := schema + "." + table
+	if cached, ok := m.cache.Load(qualified); ok {
+		key := cached.(string)
+		return key, key != ""
+	}
+
+	key := ""
+	if m.exact[qualified] {
+		key = qualified
+	} else if m.exact[table] {
+		key = table
+	} else {
+		for _, p := range m.patterns {
+			if p.matches(schema, table) {
+				key = p.key
+				break
+			}
+		}
+	}
+	m.cache.Store(qualified, key)
+	return key, key != ""
+}
+
+// TableOptions returns the options for a table, see tableMatcher for how
+// keys are matched.
+func (c *PipelineConfig) TableOptions(schema, table string) (TableOptions, bool) {
+	if c.matcher == nil {
+		opts, ok := c.Tables[table]
+		return opts, ok
+	}
+	key, ok := c.matcher.lookup(schema, table)
+	if !ok {
+		return TableOptions{}, false
+	}
+	return c.Tables[key], true
+}
+
+// IsExcluded reports whether any excluded_tables entry matches the table.
+// Entries use the same syntax as table keys.
+func (c *PipelineConfig) IsExcluded(schema, table string) bool {
+	if c.matcher == nil {
+		return slices.Contains(c.ExcludedTables, table)
+	}
+	for _, p := range c.matcher.excluded {
+		if p.matches(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// PartitionKey returns the partition_keys entry for a table, matched like
+// pipeline.tables keys.
+func (c *SinkConfig) PartitionKey(schema, table string) (string, bool) {
+	if c.keyMatcher == nil {
+		if _, ok := c.PartitionKeys[schema+"."+table]; ok {
+			return schema + "." + table, true
+		}
+		_, ok := c.PartitionKeys[table]
+		return table, ok
+	}
+	return c.keyMatcher.lookup(schema, table)
+}
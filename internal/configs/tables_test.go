@@ -0,0 +1,149 @@
+package configs
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestTableOptions(t *testing.T) {
+	keys := []string{
+		"users",
+		"audit.users",
+		"sales.*",
+		"*.orders",
+		"sales.order_*",
+		"log_*",
+		`/^shard_\d+\.events$/`,
+		`/^.*\.events$/`,
+	}
+	tables := make(map[string]TableOptions, len(keys))
+	for _, key := range keys {
+		tables[key] = TableOptions{Route: key}
+	}
+	matcher, err := newTableMatcher(keys, []string{"tmp_*", "archive.*", `/^.*_bak_\d+$/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &PipelineConfig{Tables: tables, matcher: matcher}
+
+	tests := []struct {
+		schema, table string
+		want          string
+	}{
+		{"audit", "users", "audit.users"},
+		{"public", "users", "users"},
+		{"sales", "users", "users"},
+		{"sales", "order_items", "sales.order_*"},
+		{"sales", "orders", "*.orders"},
+		{"sales", "customers", "sales.*"},
+		{"public", "orders", "*.orders"},
+		{"public", "log_2024", "log_*"},
+		{"shard_7", "events", `/^.*\.events$/`},
+		{"public", "events", `/^.*\.events$/`},
+		{"public", "accounts", ""},
+		// a second lookup is served from the cache
+		{"sales", "order_items", "sales.order_*"},
+		{"public", "accounts", ""},
+	}
+	for _, tt := range tests {
+		opts, ok := cfg.TableOptions(tt.schema, tt.table)
+		if ok != (tt.want != "") || opts.Route != tt.want {
+			t.Errorf("TableOptions(%s, %s) = %q, %v, want %q", tt.schema, tt.table, opts.Route, ok, tt.want)
+		}
+	}
+
+	excluded := []struct {
+		schema, table string
+		want          bool
+	}{
+		{"public", "tmp_import", true},
+		{"archive", "orders", true},
+		{"public", "orders_bak_3", true},
+		{"public", "orders_bak", false},
+		{"public", "orders", false},
+	}
+	for _, tt := range excluded {
+		if got := cfg.IsExcluded(tt.schema, tt.table); got != tt.want {
+			t.Errorf("IsExcluded(%s, %s) = %v, want %v", tt.schema, tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestTableMatcherOrder(t *testing.T) {
+	// both globs and both regular expressions match sales.order_items
+	tables := map[string]TableOptions{
+		"sales.*":         {Route: "schema glob"},
+		"sales.order_*":   {Route: "longer glob"},
+		"order_*":         {Route: "unqualified glob"},
+		`/^sales\..*$/`:   {Route: "b regexp"},
+		`/^.*\.order_.*/`: {Route: "a regexp"},
+	}
+	tests := []struct {
+		drop string
+		want string
+	}{
+		{want: "longer glob"},
+		{drop: "sales.order_*", want: "schema glob"},
+		{drop: "sales.*", want: "unqualified glob"},
+		{drop: "order_*", want: "a regexp"},
+		{drop: `/^.*\.order_.*/`, want: "b regexp"},
+	}
+	for _, tt := range tests {
+		delete(tables, tt.drop)
+		matcher, err := newTableMatcher(slices.Collect(maps.Keys(tables)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &PipelineConfig{Tables: tables, matcher: matcher}
+		opts, _ := cfg.TableOptions("sales", "order_items")
+		if opts.Route != tt.want {
+			t.Errorf("without %q matched %q, want %q", tt.drop, opts.Route, tt.want)
+		}
+	}
+}
+
+func TestTablePatternErrors(t *testing.T) {
+	for _, key := range []string{"sales.[", `/(/`} {
+		if _, err := newTableMatcher([]string{key}, nil); err == nil {
+			t.Errorf("expected table pattern %q to be rejected", key)
+		}
+		if _, err := newTableMatcher(nil, []string{key}); err == nil {
+			t.Errorf("expected excluded table pattern %q to be rejected", key)
+		}
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	cfg := &SinkConfig{PartitionKeys: map[string]string{
+		"public.orders":      "{{.Row.customer_id}}",
+		"orders":             "{{.Row.id}}",
+		"sales.*":            "{{.Row.region}}",
+		`/^shard_\d+\.log$/`: "{{.Row.device}}",
+	}}
+	if err := setKafkaDefaults(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		schema, table string
+		want          string
+	}{
+		{"public", "orders", "public.orders"},
+		{"sales", "orders", "orders"},
+		{"sales", "invoices", "sales.*"},
+		{"shard_3", "log", `/^shard_\d+\.log$/`},
+		{"public", "invoices", ""},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.PartitionKey(tt.schema, tt.table)
+		if ok != (tt.want != "") || (ok && got != tt.want) {
+			t.Errorf("PartitionKey(%s, %s) = %q, %v, want %q", tt.schema, tt.table, got, ok, tt.want)
+		}
+	}
+
+	cfg = &SinkConfig{PartitionKeys: map[string]string{"sales.[": "{{.Row.id}}"}}
+	if err := setKafkaDefaults(cfg); err == nil {
+		t.Error("expected partition key pattern sales.[ to be rejected")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/MathewBravo/cdc-pipeline/internal/configs"
@@ -24,6 +25,17 @@ type PostgresConnector struct {
 	wg              sync.WaitGroup
 	relationCache   map[uint32]pglogrepl.RelationMessage
 	lastRecievedLSN pglogrepl.LSN
+	currentXid      uint32
+	inTxn           bool
+	// lastCommitLSN is the end of the last transaction handed to the
+	// pipeline and serverWALEnd the server's position from its last
+	// keepalive. Once the commit is acked nothing is in flight, so the flush
+	// position can move up to the server's.
+	lastCommitLSN pglogrepl.LSN
+	serverWALEnd  pglogrepl.LSN
+	// ackedLSN is advanced by the sink once events are delivered and is what
+	// gets reported to Postgres as flushed.
+	ackedLSN atomic.Uint64
 }
 
 func NewPGConnector(cfg configs.SourceConfig) *PostgresConnector {
@@ -58,7 +70,7 @@ func (p *PostgresConnector) Start() (<-chan events.ChangeEvent, error) {
 }
 
 func (p *PostgresConnector) Stop() error {
-	p.stopChan <- struct{}{}
+	close(p.stopChan)
 	p.replConn.Close(context.Background())
 	p.wg.Wait()
 	close(p.eventChan)
@@ -69,11 +81,14 @@ func (p *PostgresConnector) buildConnString() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?replication=database", p.config.User, p.config.Password, p.config.Host, p.config.Port, p.config.Database)
 }
 
+// statusInterval is how often a standby status update is sent. It must stay
+// well below the server's wal_sender_timeout (60s by default).
+const statusInterval = 10 * time.Second
+
 func (p *PostgresConnector) replicationLoop() {
 	defer p.wg.Done()
 
 	fmt.Println("DEBUG: Beginning replication loop...")
-	ctx := context.Background()
 
 	lsn, err := fetchLastLSN()
 	if err != nil {
@@ -82,24 +97,17 @@ func (p *PostgresConnector) replicationLoop() {
 	}
 
 	p.lastRecievedLSN = lsn
+	p.ackedLSN.Store(uint64(lsn))
 
 	fmt.Println("DEBUG: Starting logical replication...")
-
-	opts := pglogrepl.StartReplicationOptions{
-		PluginArgs: []string{
-			"proto_version '1'",
-			fmt.Sprintf("publication_names '%s'", p.config.PublicationName),
-		},
-	}
-
-	if err := pglogrepl.StartReplication(ctx, p.replConn, p.config.SlotName, p.lastRecievedLSN, opts); err != nil {
+	if err := p.startReplication(); err != nil {
 		fmt.Printf("REPLICATION ERROR: failed to start replication: %v\n", err)
 		return
 	}
 
 	fmt.Println("Replication Successfully started!")
 
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(statusInterval)
 	defer ticker.Stop()
 
 	for {
@@ -109,7 +117,6 @@ func (p *PostgresConnector) replicationLoop() {
 			p.replConn.Close(context.Background())
 			return
 		case <-ticker.C:
-			// TODO: Send standby status update
 			fmt.Println("Heartbeat tick")
 			p.sendStatusUpdate()
 		default:
@@ -121,13 +128,84 @@ func (p *PostgresConnector) replicationLoop() {
 					continue
 				}
 				fmt.Printf("Error receiving message: %v\n", err)
-				return
+				if !p.reconnect() {
+					return
+				}
+				continue
 			}
 			p.ReadMessage(msg)
 		}
 	}
 }
 
+func (p *PostgresConnector) startReplication() error {
+	opts := pglogrepl.StartReplicationOptions{
+		PluginArgs: []string{
+			"proto_version '1'",
+			fmt.Sprintf("publication_names '%s'", p.config.PublicationName),
+		},
+	}
+	return pglogrepl.StartReplication(context.Background(), p.replConn, p.config.SlotName, p.lastRecievedLSN, opts)
+}
+
+// reconnect replaces a broken replication connection and resumes from the
+// last acknowledged LSN. Events after it are sent again, which sinks already
+// have to tolerate after a restart. It returns false once the connector is
+// stopped.
+func (p *PostgresConnector) reconnect() bool {
+	p.replConn.Close(context.Background())
+	backoff := 100 * time.Millisecond
+	for {
+		select {
+		case <-p.stopChan:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+
+		conn, err := pgconn.Connect(context.Background(), p.buildConnString())
+		if err != nil {
+			fmt.Printf("CONN ERR: reconnect failed: %v\n", err)
+			continue
+		}
+		p.replConn = conn
+		p.lastRecievedLSN = pglogrepl.LSN(p.ackedLSN.Load())
+		p.currentXid = 0
+		p.inTxn = false
+		if err := p.startReplication(); err != nil {
+			fmt.Printf("REPLICATION ERROR: failed to restart replication: %v\n", err)
+			conn.Close(context.Background())
+			continue
+		}
+		fmt.Printf("Replication restarted from %s\n", p.lastRecievedLSN)
+		return true
+	}
+}
+
+// emit hands an event to the pipeline. While the pipeline is backed up, for
+// example because a sink's circuit breaker is open, it keeps sending status
+// updates so the server does not drop the connection after
+// wal_sender_timeout. It returns false once the connector is stopped.
+func (p *PostgresConnector) emit(ce events.ChangeEvent) bool {
+	select {
+	case p.eventChan <- ce:
+		return true
+	default:
+	}
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case p.eventChan <- ce:
+			return true
+		case <-ticker.C:
+			p.sendStatusUpdate()
+		case <-p.stopChan:
+			return false
+		}
+	}
+}
+
 func (p *PostgresConnector) ReadMessage(bm pgproto3.BackendMessage) {
 	copyD, ok := bm.(*pgproto3.CopyData)
 	if !ok {
@@ -158,6 +236,12 @@ func (p *PostgresConnector) ReadMessage(bm pgproto3.BackendMessage) {
 
 		p.handleLogicalReplicationMessage(wd)
 	case 'k':
+		pkm, err := pglogrepl.ParsePrimaryKeepaliveMessage(data[1:])
+		if err != nil {
+			fmt.Println("Could not parse keepalive: ", err)
+		} else {
+			p.serverWALEnd = pkm.ServerWALEnd
+		}
 		p.sendStatusUpdate()
 	default:
 		fmt.Printf("Unknown replication message type: %c (0x%02x)\n", msgType, msgType)
@@ -173,6 +257,14 @@ func (p *PostgresConnector) handleLogicalReplicationMessage(walMessage pglogrepl
 		}
 		p.relationCache[relationMsg.RelationID] = *relationMsg
 		return
+	case pglogrepl.MessageTypeBegin:
+		beginMsg, ok := walMessage.(*pglogrepl.BeginMessage)
+		if !ok {
+			return
+		}
+		p.currentXid = beginMsg.Xid
+		p.inTxn = true
+		return
 	case pglogrepl.MessageTypeUpdate:
 		updateMsg, ok := walMessage.(*pglogrepl.UpdateMessage)
 		if !ok {
@@ -191,10 +283,12 @@ func (p *PostgresConnector) handleLogicalReplicationMessage(walMessage pglogrepl
 			After:     newData,
 			Lsn:       p.lastRecievedLSN.String(),
 			PK:        getPKColumns(&relMsg),
+			Xid:       p.currentXid,
+			Columns:   getColumns(&relMsg),
 		}
 
 		fmt.Println(ce.Pretty())
-		p.eventChan <- ce
+		p.emit(ce)
 		return
 	case pglogrepl.MessageTypeDelete:
 		deleteMsg, ok := walMessage.(*pglogrepl.DeleteMessage)
@@ -213,10 +307,12 @@ func (p *PostgresConnector) handleLogicalReplicationMessage(walMessage pglogrepl
 			After:     nil,
 			Lsn:       p.lastRecievedLSN.String(),
 			PK:        getPKColumns(&relMsg),
+			Xid:       p.currentXid,
+			Columns:   getColumns(&relMsg),
 		}
 
 		fmt.Println(ce.Pretty())
-		p.eventChan <- ce
+		p.emit(ce)
 		return
 	case pglogrepl.MessageTypeInsert:
 		insertMsg, ok := walMessage.(*pglogrepl.InsertMessage)
@@ -235,14 +331,28 @@ func (p *PostgresConnector) handleLogicalReplicationMessage(walMessage pglogrepl
 			After:     newData,
 			Lsn:       p.lastRecievedLSN.String(),
 			PK:        getPKColumns(&relMsg),
+			Xid:       p.currentXid,
+			Columns:   getColumns(&relMsg),
 		}
 
 		fmt.Println(ce.Pretty())
-		p.eventChan <- ce
+		p.emit(ce)
 		return
 
 	case pglogrepl.MessageTypeCommit:
-		lsnStr := p.lastRecievedLSN.String()
+		commitMsg, ok := walMessage.(*pglogrepl.CommitMessage)
+		if !ok {
+			return
+		}
+		p.inTxn = false
+		p.lastCommitLSN = commitMsg.TransactionEndLSN
+		p.emit(events.ChangeEvent{
+			Operation: events.OperationCommit,
+			Lsn:       commitMsg.TransactionEndLSN.String(),
+			Xid:       p.currentXid,
+		})
+
+		lsnStr := pglogrepl.LSN(p.ackedLSN.Load()).String()
 		fmt.Println("DEBUG: Writing lsn log")
 		logLSN(lsnStr)
 
@@ -305,11 +415,38 @@ func parseByOID(data []byte, oid uint32) any {
 	}
 }
 
+func (p *PostgresConnector) Ack(lsn string) {
+	parsed, err := pglogrepl.ParseLSN(lsn)
+	if err != nil {
+		fmt.Println("LSN ERR: Could not parse acked LSN: ", err)
+		return
+	}
+	p.advance(parsed)
+}
+
+func (p *PostgresConnector) advance(parsed pglogrepl.LSN) {
+	for {
+		current := p.ackedLSN.Load()
+		if uint64(parsed) <= current || p.ackedLSN.CompareAndSwap(current, uint64(parsed)) {
+			return
+		}
+	}
+}
+
+// sendStatusUpdate reports the acked position as flushed. When the last
+// transaction sent to the pipeline has been acked and no other is open, the
+// WAL up to the server's last keepalive holds nothing for this slot, so the
+// position moves up to it and the server can recycle WAL while the tables
+// are idle.
 func (p *PostgresConnector) sendStatusUpdate() {
+	if !p.inTxn && uint64(p.lastCommitLSN) <= p.ackedLSN.Load() {
+		p.advance(p.serverWALEnd)
+	}
+	acked := pglogrepl.LSN(p.ackedLSN.Load())
 	err := pglogrepl.SendStandbyStatusUpdate(context.Background(), p.replConn, pglogrepl.StandbyStatusUpdate{
-		WALWritePosition: p.lastRecievedLSN,
-		WALFlushPosition: p.lastRecievedLSN,
-		WALApplyPosition: p.lastRecievedLSN,
+		WALWritePosition: max(p.lastRecievedLSN, acked),
+		WALFlushPosition: acked,
+		WALApplyPosition: acked,
 		ClientTime:       time.Now(),
 		ReplyRequested:   false,
 	})
@@ -375,3 +512,11 @@ func getPKColumns(relMSG *pglogrepl.RelationMessage) []string {
 	}
 	return pkCols
 }
+
+func getColumns(relMSG *pglogrepl.RelationMessage) []events.Column {
+	cols := make([]events.Column, len(relMSG.Columns))
+	for i, col := range relMSG.Columns {
+		cols[i] = events.Column{Name: col.Name, TypeOID: col.DataType}
+	}
+	return cols
+}
@@ -0,0 +1,410 @@
+package dlq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Stages mark entries the pipeline failed on. Their event is the one that
+// stage was given, so a replay has to run it and everything after it again.
+const (
+	StageFilter    = "filter"
+	StageTransform = "transform"
+	StageScript    = "script"
+)
+
+// Entry is one dead-lettered event. RawEvent is only set when the event was
+// not kept in full, because it could not be serialized or was too large for
+// a Kafka DLQ, in which case it cannot be replayed as is. Stage is set when
+// the event still has pipeline stages to go through.
+type Entry struct {
+	Event         events.ChangeEvent `json:"event"`
+	RawEvent      string             `json:"raw_event,omitempty"`
+	Error         string             `json:"error"`
+	Sink          string             `json:"sink"`
+	Stage         string             `json:"stage,omitempty"`
+	Attempts      int                `json:"attempts"`
+	FirstFailedAt time.Time          `json:"first_failed_at"`
+	LastFailedAt  time.Time          `json:"last_failed_at"`
+}
+
+type Writer interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// New returns nil when no dead-letter destination is configured. The writer
+// applies the config's failure policy: a write is retried max_retries times,
+// then an error is returned, or with on_failure drop the entry is logged and
+// dropped.
+func New(cfg *configs.DLQConfig) (Writer, error) {
+	var w Writer
+	var err error
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "file":
+		w, err = NewFileWriter(cfg.Path)
+	case "kafka":
+		w, err = NewKafkaWriter(cfg)
+	default:
+		return nil, fmt.Errorf("DLQ ERR: unknown dlq type %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policyWriter{Writer: w, retries: cfg.MaxRetries, drop: cfg.OnFailure == "drop"}, nil
+}
+
+type policyWriter struct {
+	Writer
+	retries int
+	drop    bool
+}
+
+func (w *policyWriter) Write(entry Entry) error {
+	backoff := 100 * time.Millisecond
+	err := w.Writer.Write(entry)
+	for attempt := 0; err != nil && attempt < w.retries; attempt++ {
+		fmt.Printf("ERROR: Dead-letter write failed, retrying: %v\n", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 5*time.Second)
+		err = w.Writer.Write(entry)
+	}
+	if err != nil && w.drop {
+		fmt.Printf("ERROR: Dropping event from %s.%s at %s, it could not be dead-lettered: %v (original error: %s)\n", entry.Event.NameSpace, entry.Event.Table, entry.Event.Lsn, err, entry.Error)
+		return nil
+	}
+	return err
+}
+
+// NewEntry builds an entry for an event that just failed for the first time.
+func NewEntry(event events.ChangeEvent, sink string, attempts int, err error) Entry {
+	now := time.Now().UTC()
+	return Entry{
+		Event:         event,
+		Error:         err.Error(),
+		Sink:          sink,
+		Attempts:      attempts,
+		FirstFailedAt: now,
+		LastFailedAt:  now,
+	}
+}
+
+// encode falls back to a printed copy of the event when it cannot be
+// marshaled, so the failure is still recorded.
+func encode(entry Entry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err == nil {
+		return data, nil
+	}
+	entry.RawEvent = fmt.Sprintf("%+v", entry.Event)
+	entry.Event.Before = nil
+	entry.Event.After = nil
+	return json.Marshal(entry)
+}
+
+type FileWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileWriter(path string) (*FileWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("DLQ ERR: could not open %s: %w", path, err)
+	}
+	return &FileWriter{path: path, file: f}, nil
+}
+
+func (w *FileWriter) Write(entry Entry) error {
+	data, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *FileWriter) Close() error {
+	return w.file.Close()
+}
+
+type KafkaWriter struct {
+	client *kgo.Client
+	topic  string
+}
+
+func NewKafkaWriter(cfg *configs.DLQConfig) (*KafkaWriter, error) {
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaWriter{client: cl, topic: cfg.Topic}, nil
+}
+
+func (w *KafkaWriter) Write(entry Entry) error {
+	data, err := encode(entry)
+	if err != nil {
+		return err
+	}
+	err = w.produce(entry.Event.Lsn, data)
+	if !errors.Is(err, kerr.MessageTooLarge) {
+		return err
+	}
+
+	// The event is too large for the topic, most likely for the same reason
+	// it failed in the first place. Keep the failure without the row images
+	// rather than blocking on an entry the broker will never take.
+	entry.RawEvent = fmt.Sprintf("event of %d bytes was too large for the dead-letter topic", len(data))
+	entry.Event.Before = nil
+	entry.Event.After = nil
+	if data, err = encode(entry); err != nil {
+		return err
+	}
+	return w.produce(entry.Event.Lsn, data)
+}
+
+func (w *KafkaWriter) produce(lsn string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return w.client.ProduceSync(ctx, &kgo.Record{
+		Topic: w.topic,
+		Key:   []byte(lsn),
+		Value: data,
+	}).FirstErr()
+}
+
+func (w *KafkaWriter) Close() error {
+	w.client.Close()
+	return nil
+}
+
+// Replay sends every stored entry through resend. Entries that fail again
+// are kept with their attempt count bumped; everything else is removed from
+// the dead-letter destination.
+func Replay(cfg *configs.DLQConfig, resend func(Entry) error) (replayed int, failed int, err error) {
+	switch cfg.Type {
+	case "file":
+		return replayFile(cfg.Path, resend)
+	case "kafka":
+		return replayKafka(cfg, resend)
+	default:
+		return 0, 0, fmt.Errorf("DLQ ERR: no dlq configured")
+	}
+}
+
+func retry(entry *Entry, resend func(Entry) error) error {
+	var err error
+	if entry.RawEvent != "" {
+		err = errors.New("event was not kept in full when it was dead-lettered")
+	} else {
+		err = resend(*entry)
+	}
+	if err != nil {
+		entry.Attempts++
+		entry.Error = err.Error()
+		entry.LastFailedAt = time.Now().UTC()
+	}
+	return err
+}
+
+func replayFile(path string, resend func(Entry) error) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var remaining []Entry
+	replayed := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, 0, fmt.Errorf("DLQ ERR: corrupt entry in %s: %w", path, err)
+		}
+		if err := retry(&entry, resend); err != nil {
+			fmt.Printf("ERROR: Replay of %s.%s at %s failed: %v\n", entry.Event.NameSpace, entry.Event.Table, entry.Event.Lsn, err)
+			remaining = append(remaining, entry)
+			continue
+		}
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// rewrite with only the entries that still fail; the original stays in
+	// place unless the new file is complete
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return replayed, len(remaining), err
+	}
+	w := bufio.NewWriter(out)
+	for _, entry := range remaining {
+		data, err := encode(entry)
+		if err == nil {
+			_, err = w.Write(append(data, '\n'))
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return replayed, len(remaining), err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return replayed, len(remaining), err
+	}
+	return replayed, len(remaining), os.Rename(tmp, path)
+}
+
+// replayKafka reads the topic through a consumer group so entries are only
+// replayed once. It notes each partition's end offset before it starts and
+// stops once the group has consumed up to them, however long joining the
+// group takes. Entries that fail again are produced back to the topic past
+// those offsets and are left for the next run.
+func replayKafka(cfg *configs.DLQConfig, resend func(Entry) error) (int, int, error) {
+	group := cfg.Topic + "-replay"
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ConsumeTopics(cfg.Topic),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.AutoCommitMarks(),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cl.Close()
+
+	remaining, err := unreplayed(cl, cfg.Topic, group)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	writer := &KafkaWriter{client: cl, topic: cfg.Topic}
+	replayed, failed := 0, 0
+	for len(remaining) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		fetches := cl.PollFetches(ctx)
+		cancel()
+		if fetches.IsClientClosed() {
+			return replayed, failed, nil
+		}
+		for _, fe := range fetches.Errors() {
+			if !errors.Is(fe.Err, context.DeadlineExceeded) {
+				return replayed, failed, fe.Err
+			}
+		}
+
+		for _, record := range fetches.Records() {
+			end, ok := remaining[record.Partition]
+			if !ok || record.Offset >= end {
+				continue
+			}
+			if record.Offset == end-1 {
+				delete(remaining, record.Partition)
+			}
+
+			var entry Entry
+			if err := json.Unmarshal(record.Value, &entry); err != nil {
+				return replayed, failed, fmt.Errorf("DLQ ERR: corrupt entry at offset %d: %w", record.Offset, err)
+			}
+			if err := retry(&entry, resend); err != nil {
+				fmt.Printf("ERROR: Replay of %s.%s at %s failed: %v\n", entry.Event.NameSpace, entry.Event.Table, entry.Event.Lsn, err)
+				if err := writer.Write(entry); err != nil {
+					return replayed, failed, err
+				}
+				failed++
+			} else {
+				replayed++
+			}
+			cl.MarkCommitRecords(record)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		err := cl.CommitMarkedOffsets(ctx)
+		cancel()
+		if err != nil {
+			return replayed, failed, err
+		}
+	}
+	return replayed, failed, nil
+}
+
+// unreplayed returns the end offset of every partition that still has
+// entries past the group's committed offset.
+func unreplayed(cl *kgo.Client, topic, group string) (map[int32]int64, error) {
+	adm := kadm.NewClient(cl)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ends, err := adm.ListEndOffsets(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("DLQ ERR: could not list end offsets of %s: %w", topic, err)
+	}
+	starts, err := adm.ListStartOffsets(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("DLQ ERR: could not list start offsets of %s: %w", topic, err)
+	}
+	committed, err := adm.FetchOffsets(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("DLQ ERR: could not fetch offsets of group %s: %w", group, err)
+	}
+
+	remaining := make(map[int32]int64)
+	var listErr error
+	ends.Each(func(end kadm.ListedOffset) {
+		if end.Err != nil {
+			listErr = end.Err
+			return
+		}
+		from := int64(0)
+		if start, ok := starts.Lookup(topic, end.Partition); ok {
+			from = start.Offset
+		}
+		if c, ok := committed.Lookup(topic, end.Partition); ok {
+			from = max(from, c.At)
+		}
+		if from < end.Offset {
+			remaining[end.Partition] = end.Offset
+		}
+	})
+	return remaining, listErr
+}
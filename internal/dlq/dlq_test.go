@@ -0,0 +1,181 @@
+package dlq
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func entryAt(lsn string) Entry {
+	return NewEntry(events.ChangeEvent{NameSpace: "public", Table: "users", Lsn: lsn}, "kafka", 1, errors.New("boom"))
+}
+
+// failOn fails the resend of one LSN.
+func failOn(lsn string) func(Entry) error {
+	return func(e Entry) error {
+		if e.Event.Lsn == lsn {
+			return errors.New("still down")
+		}
+		return nil
+	}
+}
+
+func TestReplayFileKeepsFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	w, err := NewFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lsn := range []string{"0/1", "0/2", "0/3"} {
+		if err := w.Write(entryAt(lsn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	replayed, failed, err := Replay(&configs.DLQConfig{Type: "file", Path: path}, failOn("0/2"))
+	if err != nil || replayed != 2 || failed != 1 {
+		t.Fatalf("Replay = %d, %d, %v; want 2, 1, nil", replayed, failed, err)
+	}
+
+	var kept []Entry
+	replayed, failed, err = Replay(&configs.DLQConfig{Type: "file", Path: path}, func(e Entry) error {
+		kept = append(kept, e)
+		return nil
+	})
+	if err != nil || replayed != 1 || failed != 0 {
+		t.Fatalf("second Replay = %d, %d, %v; want 1, 0, nil", replayed, failed, err)
+	}
+	if kept[0].Event.Lsn != "0/2" || kept[0].Attempts != 2 || kept[0].Error != "still down" {
+		t.Errorf("kept entry = %+v", kept[0])
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestReplayKafka(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "cdc.dlq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	cfg := &configs.DLQConfig{Type: "kafka", Topic: "cdc.dlq", Brokers: cluster.ListenAddrs()}
+
+	w, err := NewKafkaWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lsn := range []string{"0/1", "0/2", "0/3", "0/4"} {
+		if err := w.Write(entryAt(lsn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	replayed, failed, err := Replay(cfg, failOn("0/3"))
+	if err != nil || replayed != 3 || failed != 1 {
+		t.Fatalf("Replay = %d, %d, %v; want 3, 1, nil", replayed, failed, err)
+	}
+
+	// only the entry that failed is left
+	var seen []string
+	replayed, failed, err = Replay(cfg, func(e Entry) error {
+		seen = append(seen, e.Event.Lsn)
+		return nil
+	})
+	if err != nil || replayed != 1 || failed != 0 {
+		t.Fatalf("second Replay = %d, %d, %v; want 1, 0, nil", replayed, failed, err)
+	}
+	if len(seen) != 1 || seen[0] != "0/3" {
+		t.Errorf("second replay saw %v, want [0/3]", seen)
+	}
+
+	// nothing left, returns without waiting for records
+	start := time.Now()
+	replayed, failed, err = Replay(cfg, failOn(""))
+	if err != nil || replayed != 0 || failed != 0 {
+		t.Fatalf("third Replay = %d, %d, %v; want 0, 0, nil", replayed, failed, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("empty replay took %s", time.Since(start))
+	}
+}
+
+func TestKafkaWriterSlimsOversizedEntries(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc.dlq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	cfg := &configs.DLQConfig{Type: "kafka", Topic: "cdc.dlq", Brokers: cluster.ListenAddrs()}
+
+	w, err := NewKafkaWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := entryAt("0/1")
+	entry.Event.After = map[string]any{"body": strings.Repeat("x", 2<<20)}
+	if err := w.Write(entry); err != nil {
+		t.Fatalf("oversized entry was not written: %v", err)
+	}
+	w.Close()
+
+	replayed, failed, err := Replay(cfg, func(Entry) error {
+		t.Error("an entry without its row images was resent")
+		return nil
+	})
+	if err != nil || replayed != 0 || failed != 1 {
+		t.Fatalf("Replay = %d, %d, %v; want 0, 1, nil", replayed, failed, err)
+	}
+}
+
+type flakyWriter struct {
+	failures int
+	writes   int
+}
+
+func (w *flakyWriter) Write(Entry) error {
+	w.writes++
+	if w.writes <= w.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (w *flakyWriter) Close() error { return nil }
+
+func TestPolicyWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		drop     bool
+		wantErr  bool
+		writes   int
+	}{
+		{name: "succeeds after a retry", failures: 1, retries: 2, writes: 2},
+		{name: "blocks after retries", failures: 5, retries: 1, wantErr: true, writes: 2},
+		{name: "drops after retries", failures: 5, retries: 1, drop: true, writes: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &flakyWriter{failures: tt.failures}
+			w := &policyWriter{Writer: inner, retries: tt.retries, drop: tt.drop}
+			err := w.Write(entryAt("0/1"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if inner.writes != tt.writes {
+				t.Errorf("writes = %d, want %d", inner.writes, tt.writes)
+			}
+		})
+	}
+}
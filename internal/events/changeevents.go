@@ -11,6 +11,11 @@ const (
 	OperationUpdate Operation = iota
 	OperationInsert
 	OperationDelete
+	// OperationCommit marks the end of a source transaction. Its Lsn is the
+	// commit's end LSN. It carries no row and is never delivered; sinks ack
+	// it once everything before it is delivered, which lets the slot advance
+	// past transactions whose events were all filtered out.
+	OperationCommit
 )
 
 type ChangeEvent struct {
@@ -22,6 +27,14 @@ type ChangeEvent struct {
 	Lsn       string
 	Route     string
 	PK        []string
+	Xid       uint32
+	// Columns describes the source relation for sinks that need the table
+	// shape. It is not part of the serialized event.
+	Columns []Column `json:"-"`
+	// RouteSource is the configured route, as written, that Route was
+	// rendered from. It is empty when a script set the route and is not
+	// part of the serialized event.
+	RouteSource string `json:"-"`
 	// !TODO: Include later, info about the system, commit time
 	// TsMs      time.Time
 	// TsNs      time.Time
@@ -32,6 +45,11 @@ type ChangeEvent struct {
 	// name      string
 }
 
+type Column struct {
+	Name    string
+	TypeOID uint32
+}
+
 func (o Operation) ToString() string {
 	switch o {
 	case OperationInsert:
@@ -40,11 +58,40 @@ func (o Operation) ToString() string {
 		return "UPDATE"
 	case OperationDelete:
 		return "DELETE"
+	case OperationCommit:
+		return "COMMIT"
 	default:
 		return "UNKNOWN"
 	}
 }
 
+// IsCommit reports whether the event is a commit marker rather than a change.
+func (e *ChangeEvent) IsCommit() bool {
+	return e.Operation == OperationCommit
+}
+
+// Row returns the latest image of the row: After, or Before for deletes.
+func (e *ChangeEvent) Row() map[string]any {
+	if e.Operation == OperationDelete {
+		return e.Before
+	}
+	return e.After
+}
+
+// PKValues returns the values of the primary key columns in PK order.
+func (e *ChangeEvent) PKValues() []string {
+	return e.PKValuesOf(e.Row())
+}
+
+// PKValuesOf reads the primary key columns out of the given row image.
+func (e *ChangeEvent) PKValuesOf(row map[string]any) []string {
+	values := make([]string, len(e.PK))
+	for i, col := range e.PK {
+		values[i] = fmt.Sprintf("%v", row[col])
+	}
+	return values
+}
+
 func (e *ChangeEvent) Pretty() string {
 	b, err := json.MarshalIndent(e, "", "  ")
 	if err != nil {
@@ -0,0 +1,401 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+type literal struct{ value any }
+
+func (n literal) eval(map[string]any) (any, error) { return n.value, nil }
+
+// variable reads a top level name. Unknown names are null rather than an
+// error so expressions can test for optional context.
+type variable struct{ name string }
+
+func (n variable) eval(env map[string]any) (any, error) { return env[n.name], nil }
+
+// indexNode reads a map key or list element. Indexing null gives null, so
+// after.status is simply null on a delete.
+type indexNode struct{ target, key node }
+
+func (n indexNode) eval(env map[string]any) (any, error) {
+	target, err := n.target.eval(env)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case map[string]any:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, got %s", typeName(key))
+		}
+		return t[k], nil
+	case []any:
+		f, ok := ToFloat(key)
+		if !ok {
+			return nil, fmt.Errorf("list index must be a number, got %s", typeName(key))
+		}
+		i := int(f)
+		if i < 0 || i >= len(t) {
+			return nil, nil
+		}
+		return t[i], nil
+	default:
+		return nil, fmt.Errorf("cannot index %s", typeName(target))
+	}
+}
+
+type listNode struct{ items []node }
+
+func (n listNode) eval(env map[string]any) (any, error) {
+	list := make([]any, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(l) {
+		return true, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if !truthy(l) {
+		return false, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(env map[string]any) (any, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	}
+
+	// ordering against null is false rather than an error, like SQL
+	if l == nil || r == nil {
+		return false, nil
+	}
+	c, err := compare(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+type inNode struct{ item, list node }
+
+func (n inNode) eval(env map[string]any) (any, error) {
+	item, err := n.item.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	list, err := n.list.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch l := list.(type) {
+	case nil:
+		return false, nil
+	case []any:
+		for _, v := range l {
+			if equal(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		k, ok := item.(string)
+		_, found := l[k]
+		return ok && found, nil
+	case string:
+		s, ok := item.(string)
+		return ok && strings.Contains(l, s), nil
+	default:
+		return nil, fmt.Errorf("cannot use in with %s", typeName(list))
+	}
+}
+
+type arithNode struct {
+	op          string
+	left, right node
+}
+
+func (n arithNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "+" {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok || rok {
+			if !lok {
+				ls = toString(l)
+			}
+			if !rok {
+				rs = toString(r)
+			}
+			return ls + rs, nil
+		}
+	}
+	lf, lok := ToFloat(l)
+	rf, rok := ToFloat(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, typeName(l), typeName(r))
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	default:
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+}
+
+type callNode struct {
+	name string
+	fn   func(args []any) (any, error)
+	args []node
+}
+
+func (n callNode) eval(env map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	default:
+		if f, ok := ToFloat(v); ok {
+			return f != 0
+		}
+		return true
+	}
+}
+
+// ToFloat widens any Go integer or float to a float64. Integers beyond 2^53
+// lose precision.
+func ToFloat(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int8:
+		return float64(t), true
+	case int16:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case uint:
+		return float64(t), true
+	case uint8:
+		return float64(t), true
+	case uint16:
+		return float64(t), true
+	case uint32:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	}
+	return 0, false
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	}
+	if f, ok := ToFloat(v); ok {
+		return fmt.Sprintf("%v", f)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func equal(l, r any) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	if lf, ok := ToFloat(l); ok {
+		rf, ok := ToFloat(r)
+		return ok && lf == rf
+	}
+	if _, ok := l.(time.Time); ok {
+		c, err := compare(l, r)
+		return err == nil && c == 0
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+// compare orders numbers, strings and timestamps. A timestamp compares with
+// an RFC 3339 string, so after.created_at > '2024-01-01T00:00:00Z' works.
+func compare(l, r any) (int, error) {
+	if lf, ok := ToFloat(l); ok {
+		if rf, ok := ToFloat(r); ok {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+	lt, lok := toTime(l)
+	rt, rok := toTime(r)
+	if lok && rok {
+		return lt.Compare(rt), nil
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeName(l), typeName(r))
+}
+
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	case time.Time:
+		return "timestamp"
+	}
+	if _, ok := ToFloat(v); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,121 @@
+// Package expr is a small expression language for matching rows, e.g.
+//
+//	after.status == 'active' && after.region in ['eu', 'us']
+//
+// It has null-safe field access (a.b, a['b'], a[0]), comparisons, in and
+// not in, && || !, arithmetic with + - * / % (+ also joins strings), list
+// literals and a few functions: lower, upper, len, contains, starts_with,
+// ends_with.
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// Program is a compiled expression, safe for concurrent use.
+type Program struct {
+	source string
+	root   node
+}
+
+func Compile(src string) (*Program, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", describe(t), t.pos)
+	}
+	return &Program{source: src, root: root}, nil
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+func (p *Program) Eval(env map[string]any) (any, error) {
+	return p.root.eval(env)
+}
+
+// Match evaluates the expression as a condition. Null counts as false.
+func (p *Program) Match(env map[string]any) (bool, error) {
+	v, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// EventEnv exposes an event to expressions as before, after, row (after, or
+// before for deletes), op, schema, table and route.
+func EventEnv(event *events.ChangeEvent) map[string]any {
+	return map[string]any{
+		"before": nilIfEmpty(event.Before),
+		"after":  nilIfEmpty(event.After),
+		"row":    nilIfEmpty(event.Row()),
+		"op":     event.Operation.ToString(),
+		"schema": event.NameSpace,
+		"table":  event.Table,
+		"route":  event.Route,
+	}
+}
+
+// nilIfEmpty keeps a missing image null instead of a typed nil map.
+func nilIfEmpty(m map[string]any) any {
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+type builtin struct {
+	arity int
+	call  func(args []any) (any, error)
+}
+
+var builtins = map[string]builtin{
+	"lower": {1, stringFunc(strings.ToLower)},
+	"upper": {1, stringFunc(strings.ToUpper)},
+	"len": {1, func(args []any) (any, error) {
+		switch t := args[0].(type) {
+		case nil:
+			return 0.0, nil
+		case string:
+			return float64(len(t)), nil
+		case []any:
+			return float64(len(t)), nil
+		case map[string]any:
+			return float64(len(t)), nil
+		}
+		return nil, fmt.Errorf("cannot take the length of %s", typeName(args[0]))
+	}},
+	"contains":    {2, stringPredicate(strings.Contains)},
+	"starts_with": {2, stringPredicate(strings.HasPrefix)},
+	"ends_with":   {2, stringPredicate(strings.HasSuffix)},
+}
+
+func stringFunc(fn func(string) string) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return fn(toString(args[0])), nil
+	}
+}
+
+func stringPredicate(fn func(s, sub string) bool) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		if args[0] == nil || args[1] == nil {
+			return false, nil
+		}
+		return fn(toString(args[0]), toString(args[1])), nil
+	}
+}
@@ -0,0 +1,194 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func testEnv() map[string]any {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return EventEnv(&events.ChangeEvent{
+		Operation: events.OperationUpdate,
+		NameSpace: "public",
+		Table:     "orders",
+		Before:    map[string]any{"status": "pending", "total": int32(90)},
+		After: map[string]any{
+			"status":     "active",
+			"region":     "eu",
+			"total":      int64(120),
+			"discount":   2.5,
+			"note":       nil,
+			"created_at": created,
+			"tags":       []any{"vip", "gift"},
+			"meta":       map[string]any{"source": "web", "items": []any{map[string]any{"sku": "A1"}}},
+		},
+	})
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want any
+	}{
+		{src: "after.status", want: "active"},
+		{src: "after['region']", want: "eu"},
+		{src: "after.tags[1]", want: "gift"},
+		{src: "after.tags[5]", want: nil},
+		{src: "after.meta.items[0].sku", want: "A1"},
+		{src: "after.missing.deeper", want: nil},
+		{src: "unknown", want: nil},
+		{src: "op", want: "UPDATE"},
+		{src: "1 + 2 * 3", want: 7.0},
+		{src: "(1 + 2) * 3", want: 9.0},
+		{src: "-after.total + 20", want: -100.0},
+		{src: "after.total % 50", want: 20.0},
+		{src: "after.total / 4", want: 30.0},
+		{src: "'id-' + after.total", want: "id-120"},
+		{src: "after.status + '/' + after.region", want: "active/eu"},
+		{src: "upper(after.region)", want: "EU"},
+		{src: "lower(after.note)", want: nil},
+		{src: "len(after.tags)", want: 2.0},
+		{src: "len(after.note)", want: 0.0},
+		{src: "[1, 'a', null]", want: []any{1.0, "a", nil}},
+		{src: `"it's" + 'a \'quote\''`, want: "it'sa 'quote'"},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := p.Eval(env)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%q) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{src: "after.status == 'active'", want: true},
+		{src: "after.status != 'active'", want: false},
+		{src: "after.status == 'active' && after.region in ['eu', 'us']", want: true},
+		{src: "after.region not in ['eu', 'us']", want: false},
+		{src: "after.status == 'closed' || before.status == 'pending'", want: true},
+		{src: "!(after.total > 100)", want: false},
+		{src: "after.total == 120", want: true},
+		{src: "before.total < after.total", want: true},
+		{src: "after.total >= 120 && after.discount <= 2.5", want: true},
+		{src: "after.created_at > '2024-01-01T00:00:00Z'", want: true},
+		{src: "after.created_at == '2024-03-01T12:00:00Z'", want: true},
+		{src: "after.note == null", want: true},
+		{src: "after.note > 1", want: false},
+		{src: "after.note", want: false},
+		{src: "after.missing", want: false},
+		{src: "'vip' in after.tags", want: true},
+		{src: "'source' in after.meta", want: true},
+		{src: "'ct' in after.status", want: true},
+		{src: "'x' in after.note", want: false},
+		{src: "contains(after.status, 'tiv')", want: true},
+		{src: "starts_with(table, 'ord') && ends_with(schema, 'lic')", want: true},
+		{src: "contains(after.note, 'x')", want: false},
+		{src: "after.total", want: true},
+		{src: "after.total - 120", want: false},
+		{src: "''", want: false},
+		{src: "[]", want: true},
+		// the right side is not evaluated once the left decides
+		{src: "false && 1 / 0", want: false},
+		{src: "true || 1 / 0", want: true},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := p.Match(env)
+		if err != nil {
+			t.Errorf("Match(%q): %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{src: "after.status == 'active", err: "unterminated string"},
+		{src: "after.status = 'active'", err: "unexpected '='"},
+		{src: "after.status ==", err: "end of expression"},
+		{src: "(1 + 2", err: `expected ")"`},
+		{src: "[1, 2", err: "expected"},
+		{src: "1 2", err: `unexpected "2"`},
+		{src: "shout(after.status)", err: `unknown function "shout"`},
+		{src: "lower(a, b)", err: "lower"},
+		{src: "1.2.3", err: "invalid number"},
+		{src: "after.", err: "position"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Compile(%q) = %v, want an error containing %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{src: "after.total / 0", err: "division by zero"},
+		{src: "after.total % 0", err: "division by zero"},
+		{src: "after.tags * 2", err: "cannot apply * to list and number"},
+		{src: "after.status > 3", err: "cannot compare string with number"},
+		{src: "after.tags['x']", err: "list index must be a number"},
+		{src: "after.meta[0]", err: "map key must be a string"},
+		{src: "after.status.x", err: "cannot index string"},
+		{src: "1 in 2", err: "cannot use in with number"},
+		{src: "len(after.total)", err: "len: cannot take the length of number"},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		_, err = p.Eval(env)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Eval(%q) = %v, want an error containing %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestToFloat(t *testing.T) {
+	for _, v := range []any{int(3), int8(3), int16(3), int32(3), int64(3), uint(3), uint8(3), uint16(3), uint32(3), uint64(3), float32(3), float64(3)} {
+		if f, ok := ToFloat(v); !ok || f != 3 {
+			t.Errorf("ToFloat(%T) = %v, %v", v, f, ok)
+		}
+	}
+	for _, v := range []any{nil, "3", true, time.Time{}} {
+		if _, ok := ToFloat(v); ok {
+			t.Errorf("ToFloat(%#v) succeeded", v)
+		}
+	}
+}
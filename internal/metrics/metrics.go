@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// Counters and gauges are published through expvar, keyed by sink name, and
+// served as JSON on /debug/vars.
+var (
+	SinkDelivered = expvar.NewMap("sink_delivered")
+	SinkFailures  = expvar.NewMap("sink_failures")
+	// SinkBreakerState is 0 closed, 1 open, 2 half-open.
+	SinkBreakerState = expvar.NewMap("sink_breaker_state")
+)
+
+func SetGauge(m *expvar.Map, key string, value int64) {
+	v := new(expvar.Int)
+	v.Set(value)
+	m.Set(key, v)
+}
+
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Printf("ERROR: Metrics server stopped: %v\n", err)
+		}
+	}()
+}
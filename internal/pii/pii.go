@@ -0,0 +1,121 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+// Keyring holds the secrets for keyed masks. Outputs only depend on the key
+// and the value, so the same value masks the same way in every table and
+// topic and can still be joined on.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+func NewKeyring(cfg *configs.PIIKeysConfig) *Keyring {
+	k := &Keyring{active: cfg.Active, keys: make(map[string][]byte, len(cfg.Keys))}
+	for _, key := range cfg.Keys {
+		k.keys[key.ID] = key.Secret
+	}
+	return k
+}
+
+// key returns the named key, or the active one when id is empty.
+func (k *Keyring) key(id string) (string, []byte, error) {
+	if id == "" {
+		id = k.active
+	}
+	secret, ok := k.keys[id]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown pii key %q", id)
+	}
+	return id, secret, nil
+}
+
+// HMAC returns "<key id>:<hex HMAC-SHA256>". The key ID lets consumers tell
+// which generation of a rotated key produced a value.
+func (k *Keyring) HMAC(keyID, value string) (string, error) {
+	id, secret, err := k.key(keyID)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return id + ":" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Tokenize replaces every letter and digit with a pseudo-random one of the
+// same class, keyed by the whole value, and keeps everything else. The
+// result has the same length and shape, so "jane.doe@example.com" becomes
+// something like "qhxt.bma@wkrzfql.npv". Tokens do not carry the key ID, so
+// config requires tokenize masks to pin one with key_id.
+func (k *Keyring) Tokenize(keyID, value string) (string, error) {
+	_, secret, err := k.key(keyID)
+	if err != nil {
+		return "", err
+	}
+	stream := newKeystream(secret, value)
+
+	var out strings.Builder
+	out.Grow(len(value))
+	for _, r := range value {
+		switch {
+		case unicode.IsDigit(r):
+			out.WriteByte('0' + stream.below(10))
+		case unicode.IsUpper(r):
+			out.WriteByte('A' + stream.below(26))
+		case unicode.IsLetter(r):
+			out.WriteByte('a' + stream.below(26))
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String(), nil
+}
+
+// keystream is HMAC-SHA256(key, value || counter) read a byte at a time.
+type keystream struct {
+	secret  []byte
+	value   string
+	counter uint32
+	block   []byte
+}
+
+func newKeystream(secret []byte, value string) *keystream {
+	return &keystream{secret: secret, value: value}
+}
+
+func (s *keystream) next() byte {
+	if len(s.block) == 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte("tokenize:"))
+		mac.Write([]byte(s.value))
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], s.counter)
+		mac.Write(ctr[:])
+		s.counter++
+		s.block = mac.Sum(nil)
+	}
+	b := s.block[0]
+	s.block = s.block[1:]
+	return b
+}
+
+// below returns a uniform byte in [0, n). Bytes from the top partial range
+// are skipped, since taking them modulo n would favour the low values.
+func (s *keystream) below(n byte) byte {
+	limit := 256 - 256%int(n)
+	for {
+		if b := s.next(); int(b) < limit {
+			return b % n
+		}
+	}
+}
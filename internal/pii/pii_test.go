@@ -0,0 +1,164 @@
+package pii
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+func testKeyring() *Keyring {
+	return NewKeyring(&configs.PIIKeysConfig{
+		Active: "k2",
+		Keys: []configs.PIIKey{
+			{ID: "k1", Secret: []byte("0123456789abcdef")},
+			{ID: "k2", Secret: []byte("fedcba9876543210")},
+		},
+	})
+}
+
+func TestHMAC(t *testing.T) {
+	keys := testKeyring()
+	const email = "jane.doe@example.com"
+
+	got, err := keys.HMAC("k1", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HMAC-SHA256 of the value under k1's secret
+	if want := "k1:8706b335df2909c4450fa41aa0259e25360ef6c0abf87138d9bfb692c3db7f33"; got != want {
+		t.Errorf("HMAC(k1) = %q, want %q", got, want)
+	}
+	if again, _ := keys.HMAC("k1", email); again != got {
+		t.Errorf("HMAC is not deterministic: %q then %q", got, again)
+	}
+	if other, _ := keys.HMAC("k1", "john.doe@example.com"); other == got {
+		t.Error("different values produced the same HMAC")
+	}
+
+	k2, err := keys.HMAC("k2", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k2[3:] == got[3:] {
+		t.Error("different keys produced the same HMAC")
+	}
+	// without a key ID the active key is used
+	if active, _ := keys.HMAC("", email); active != k2 {
+		t.Errorf("HMAC with the active key = %q, want k2's %q", active, k2)
+	}
+	if _, err := keys.HMAC("k9", email); err == nil {
+		t.Error("expected an unknown key to fail")
+	}
+}
+
+func TestHMACKeyRotation(t *testing.T) {
+	const email = "jane.doe@example.com"
+	before, _ := testKeyring().HMAC("", email)
+	pinned, _ := testKeyring().HMAC("k1", email)
+
+	// k3 becomes active while k1 and k2 stay for masks that pin them
+	rotated := NewKeyring(&configs.PIIKeysConfig{
+		Active: "k3",
+		Keys: []configs.PIIKey{
+			{ID: "k1", Secret: []byte("0123456789abcdef")},
+			{ID: "k2", Secret: []byte("fedcba9876543210")},
+			{ID: "k3", Secret: []byte("a new secret!!!!")},
+		},
+	})
+	after, err := rotated.HMAC("", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(before, "k2:") || !strings.HasPrefix(after, "k3:") {
+		t.Errorf("HMACs before and after rotation = %q and %q, want them tagged k2 and k3", before, after)
+	}
+	if before[3:] == after[3:] {
+		t.Error("rotating the key did not change the HMAC")
+	}
+	if old, _ := rotated.HMAC("k2", email); old != before {
+		t.Errorf("HMAC under the retired k2 = %q, want %q as before the rotation", old, before)
+	}
+	if still, _ := rotated.HMAC("k1", email); still != pinned {
+		t.Errorf("HMAC pinned to k1 = %q after the rotation, want %q", still, pinned)
+	}
+}
+
+func TestTokenizeKeepsShape(t *testing.T) {
+	keys := testKeyring()
+	for _, value := range []string{"jane.doe@example.com", "+1 (555) 010-9999", "Zoë-42", ""} {
+		got, err := keys.Tokenize("k1", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := keys.Tokenize("k1", value)
+		if got != again {
+			t.Errorf("Tokenize(%q) is not deterministic: %q then %q", value, got, again)
+		}
+		in, out := []rune(value), []rune(got)
+		if len(in) != len(out) {
+			t.Fatalf("Tokenize(%q) = %q, length changed", value, got)
+		}
+		for i, r := range in {
+			switch {
+			case unicode.IsDigit(r):
+				if out[i] < '0' || out[i] > '9' {
+					t.Errorf("Tokenize(%q) = %q, digit became %q", value, got, out[i])
+				}
+			case unicode.IsUpper(r):
+				if out[i] < 'A' || out[i] > 'Z' {
+					t.Errorf("Tokenize(%q) = %q, upper case letter became %q", value, got, out[i])
+				}
+			case unicode.IsLetter(r):
+				if out[i] < 'a' || out[i] > 'z' {
+					t.Errorf("Tokenize(%q) = %q, letter became %q", value, got, out[i])
+				}
+			case out[i] != r:
+				t.Errorf("Tokenize(%q) = %q, %q was not kept", value, got, r)
+			}
+		}
+	}
+
+	other, _ := keys.Tokenize("k2", "jane.doe@example.com")
+	same, _ := keys.Tokenize("k1", "jane.doe@example.com")
+	if other == same {
+		t.Error("different keys produced the same token")
+	}
+	if _, err := keys.Tokenize("k9", "x"); err == nil {
+		t.Error("expected an unknown key to fail")
+	}
+}
+
+func TestTokenizeIsUniform(t *testing.T) {
+	// With %10 on a byte, 0-5 come up 26/256 of the time and 6-9 only
+	// 25/256, a skew of about 2% that this many samples would show.
+	keys := testKeyring()
+	var counts [10]int
+	total := 0
+	for i := range 100000 {
+		token, err := keys.Tokenize("k1", fmt.Sprintf("%08d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range token {
+			counts[c-'0']++
+			total++
+		}
+	}
+	want := total / 10
+	for digit, n := range counts {
+		if n < want*99/100 || n > want*101/100 {
+			t.Errorf("digit %d came up %d times, want about %d", digit, n, want)
+		}
+	}
+}
+
+func TestKeystreamBelow(t *testing.T) {
+	s := newKeystream([]byte("0123456789abcdef"), "x")
+	s.block = []byte{255, 252, 3}
+	if got := s.below(10); got != 3 {
+		t.Errorf("below(10) = %d, want 3 after skipping 255 and 252", got)
+	}
+}
@@ -0,0 +1,180 @@
+package pii
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Detectors are the PII kinds the scanner knows, in the order they are
+// tried. Earlier detectors win when matches overlap, so a card number is not
+// also reported as a phone number.
+var Detectors = []string{"email", "credit_card", "national_id", "ip", "phone"}
+
+type detector struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+var allDetectors = map[string]detector{
+	"email": {
+		name: "email",
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	"credit_card": {
+		name:  "credit_card",
+		re:    regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid: validCard,
+	},
+	// US social security numbers and UK national insurance numbers.
+	"national_id": {
+		name:  "national_id",
+		re:    regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b|\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`),
+		valid: validNationalID,
+	},
+	"ip": {
+		name:  "ip",
+		re:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`),
+		valid: func(m string) bool { return net.ParseIP(m) != nil },
+	},
+	"phone": {
+		name:  "phone",
+		re:    regexp.MustCompile(`\+\d{1,3}[ .-]?(?:\(\d{1,4}\)|\d{1,4})(?:[ .-]?\d{2,4}){2,4}\b|(?:\(\d{3}\) ?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b`),
+		valid: func(m string) bool { n := digits(m); return len(n) >= 10 && len(n) <= 15 },
+	},
+}
+
+// Scanner looks for PII in free text.
+type Scanner struct {
+	detectors []detector
+}
+
+// NewScanner uses the named detectors, or all of them when names is empty.
+func NewScanner(names []string) (*Scanner, error) {
+	if len(names) == 0 {
+		names = Detectors
+	}
+	s := &Scanner{}
+	for _, name := range Detectors {
+		if slices.Contains(names, name) {
+			s.detectors = append(s.detectors, allDetectors[name])
+		}
+	}
+	for _, name := range names {
+		if _, ok := allDetectors[name]; !ok {
+			return nil, fmt.Errorf("unknown pii detector %q", name)
+		}
+	}
+	return s, nil
+}
+
+// Scan returns the kinds of PII found in value and value with every match
+// replaced by REDACTED.
+func (s *Scanner) Scan(value string) (found []string, masked string) {
+	masked = value
+	for _, d := range s.detectors {
+		hit := false
+		masked = d.re.ReplaceAllStringFunc(masked, func(m string) string {
+			if d.valid != nil && !d.valid(m) {
+				return m
+			}
+			hit = true
+			return "REDACTED"
+		})
+		if hit {
+			found = append(found, d.name)
+		}
+	}
+	return found, masked
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// validCard checks the length and the Luhn checksum.
+func validCard(m string) bool {
+	n := digits(m)
+	if len(n) < 13 || len(n) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range len(n) {
+		d := int(n[len(n)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validNationalID rejects SSNs that are never issued: area 000, 666 or
+// 900-999, group 00 or serial 0000.
+func validNationalID(m string) bool {
+	if !strings.Contains(m, "-") {
+		return true
+	}
+	area, group, serial := m[0:3], m[4:6], m[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// Finding is one line of the scan report. It names where PII was found,
+// never the value itself.
+type Finding struct {
+	Time      time.Time `json:"time"`
+	Lsn       string    `json:"lsn"`
+	Schema    string    `json:"schema"`
+	Table     string    `json:"table"`
+	Column    string    `json:"column"`
+	Detectors []string  `json:"detectors"`
+	Policy    string    `json:"policy"`
+}
+
+// Report appends findings to a JSON lines file.
+type Report struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenReport(path string) (*Report, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("PII ERR: could not open report %s: %w", path, err)
+	}
+	return &Report{file: f}, nil
+}
+
+func (r *Report) Write(f Finding) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+func (r *Report) Close() error {
+	return r.file.Close()
+}
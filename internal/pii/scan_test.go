@@ -0,0 +1,93 @@
+package pii
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidCard(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"5500-0055-5555-5559", true},
+		{"378282246310005", true},
+		{"4111111111111112", false},
+		{"411111111111", false},
+		{"41111111111111111111", false},
+	}
+	for _, tt := range tests {
+		if got := validCard(tt.in); got != tt.want {
+			t.Errorf("validCard(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidNationalID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"123-45-6789", true},
+		{"000-45-6789", false},
+		{"666-45-6789", false},
+		{"912-45-6789", false},
+		{"123-00-6789", false},
+		{"123-45-0000", false},
+		{"AB 12 34 56 C", true},
+	}
+	for _, tt := range tests {
+		if got := validNationalID(tt.in); got != tt.want {
+			t.Errorf("validNationalID(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	s, err := NewScanner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in     string
+		found  []string
+		masked string
+	}{
+		{"write to ann@example.com", []string{"email"}, "write to REDACTED"},
+		{"card 4111 1111 1111 1111 on file", []string{"credit_card"}, "card REDACTED on file"},
+		// fails Luhn, and is too long to be a phone number
+		{"order 4111111111111112", nil, "order 4111111111111112"},
+		{"ssn 123-45-6789", []string{"national_id"}, "ssn REDACTED"},
+		{"ssn 666-45-6789", nil, "ssn 666-45-6789"},
+		{"nino AB123456C", []string{"national_id"}, "nino REDACTED"},
+		{"from 192.168.1.20", []string{"ip"}, "from REDACTED"},
+		{"version 1.2.3.400", nil, "version 1.2.3.400"},
+		{"v6 2001:db8::1", []string{"ip"}, "v6 REDACTED"},
+		{"call +1 415 555 0100", []string{"phone"}, "call REDACTED"},
+		{"call (415) 555-0100", []string{"phone"}, "call REDACTED"},
+		{"ann@example.com, 415-555-0100", []string{"email", "phone"}, "REDACTED, REDACTED"},
+		{"nothing to see", nil, "nothing to see"},
+	}
+	for _, tt := range tests {
+		found, masked := s.Scan(tt.in)
+		if !slices.Equal(found, tt.found) || masked != tt.masked {
+			t.Errorf("Scan(%q) = %v, %q; want %v, %q", tt.in, found, masked, tt.found, tt.masked)
+		}
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	s, err := NewScanner([]string{"phone", "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// detectors run in their fixed order whatever the config lists
+	if found, _ := s.Scan("4111111111111111 ann@example.com"); !slices.Equal(found, []string{"email"}) {
+		t.Errorf("found %v, want [email]", found)
+	}
+	if _, err := NewScanner([]string{"email", "passport"}); err == nil {
+		t.Error("expected an error for an unknown detector")
+	}
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func TestFailedFilterIsDeadLettered(t *testing.T) {
+	const tables = `
+  default_route: cdc
+  tables:
+    orders:
+      operations: [INSERT, UPDATE, DELETE]
+      exclude_columns: [note]
+`
+	cfg := loadPipelineConfig(t, tables+`      filter: "row.qty > 2"
+`)
+	deadLetters := &memoryDLQ{}
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(lsn string, qty any) events.ChangeEvent {
+		return events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: lsn, After: map[string]any{"qty": qty, "note": "x"}}
+	}
+	in := make(chan events.ChangeEvent)
+	out := p.Start(in)
+	go func() {
+		in <- order("0/1", int64(3))
+		// a string cannot be compared with a number
+		in <- order("0/2", "lots")
+		in <- order("0/3", int64(1))
+		close(in)
+	}()
+
+	var sent []events.ChangeEvent
+	for event := range out {
+		sent = append(sent, event)
+	}
+	if len(sent) != 1 || sent[0].Lsn != "0/1" {
+		t.Errorf("sent %+v, want the event at 0/1", sent)
+	}
+	if len(deadLetters.entries) != 1 {
+		t.Fatalf("dead letters = %+v, want the event at 0/2", deadLetters.entries)
+	}
+	entry := deadLetters.entries[0]
+	if entry.Stage != dlq.StageFilter || entry.Sink != "filter" {
+		t.Errorf("dead letter = %+v, want it marked for a filter rerun", entry)
+	}
+	if entry.Event.After["qty"] != "lots" || entry.Event.After["note"] != "x" {
+		t.Errorf("dead letter row = %v, want it as the filter got it", entry.Event.After)
+	}
+	if _, err := p.Rerun(entry); err == nil {
+		t.Error("expected a rerun through the same filter to fail")
+	}
+
+	// the filter is fixed before the replay
+	fixed, err := NewPipeline(loadPipelineConfig(t, tables+`      filter: "row.qty != null"
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := fixed.Rerun(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs[0].Route != "cdc" {
+		t.Fatalf("outputs = %+v, want the event routed to cdc", outputs)
+	}
+	if _, ok := outputs[0].After["note"]; ok {
+		t.Errorf("after = %v, want note projected away", outputs[0].After)
+	}
+
+	// a filter that now leaves the event out replays it to nowhere
+	dropping, err := NewPipeline(loadPipelineConfig(t, tables+`      filter: "row.qty == null"
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs, err := dropping.Rerun(entry); err != nil || len(outputs) != 0 {
+		t.Errorf("rerun = %+v, %v, want no outputs", outputs, err)
+	}
+}
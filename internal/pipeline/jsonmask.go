@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+// maskJSON applies a path mask inside a json or jsonb column. The connector
+// delivers those as text, which is decoded and written back as text so the
+// column keeps its type. A leaf that cannot be masked is redacted, and a
+// column that cannot be decoded or written back is redacted as a whole; the
+// error is returned for logging either way. Paths that do not exist in a
+// document are skipped.
+func (p *Pipeline) maskJSON(mask configs.PIIMask, row map[string]any) error {
+	v, ok := row[mask.Column]
+	if !ok || v == nil {
+		return nil
+	}
+	text, isText := v.(string)
+	doc := v
+	if isText {
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			row[mask.Column] = "REDACTED"
+			return fmt.Errorf("column %s is not JSON, redacted it: %w", mask.Column, err)
+		}
+	}
+
+	var firstErr error
+	doc = walkPath(doc, mask.Path, func(leaf any) any {
+		masked, err := p.maskLeaf(mask, leaf)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w, redacted", err)
+			}
+			return "REDACTED"
+		}
+		return masked
+	})
+
+	if !isText {
+		row[mask.Column] = doc
+		return firstErr
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		row[mask.Column] = "REDACTED"
+		return fmt.Errorf("column %s could not be re-encoded, redacted it: %w", mask.Column, err)
+	}
+	row[mask.Column] = strings.TrimSuffix(out.String(), "\n")
+	return firstErr
+}
+
+// walkPath calls fn on every non-null value the path reaches and stores
+// what it returns in place.
+func walkPath(v any, steps []configs.PathStep, fn func(any) any) any {
+	if len(steps) == 0 {
+		if v == nil {
+			return nil
+		}
+		return fn(v)
+	}
+	step, rest := steps[0], steps[1:]
+	switch t := v.(type) {
+	case map[string]any:
+		if step.Any {
+			for k, e := range t {
+				t[k] = walkPath(e, rest, fn)
+			}
+		} else if e, ok := t[step.Key]; ok && !step.IsIndex() {
+			t[step.Key] = walkPath(e, rest, fn)
+		}
+	case []any:
+		if step.Any {
+			for i, e := range t {
+				t[i] = walkPath(e, rest, fn)
+			}
+		} else if step.IsIndex() && step.Index < len(t) {
+			t[step.Index] = walkPath(t[step.Index], rest, fn)
+		}
+	}
+	return v
+}
+
+func (p *Pipeline) maskLeaf(mask configs.PIIMask, v any) (any, error) {
+	if mask.Action == "redact" {
+		return "REDACTED", nil
+	}
+	value, err := scalarString(v)
+	if err != nil {
+		return nil, err
+	}
+	switch mask.Action {
+	case "hash":
+		hash := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(hash[:]), nil
+	case "mask_partial":
+		return maskPartial(value), nil
+	}
+	return p.keyedValue(mask, value)
+}
+
+// scalarString formats numbers and booleans the way they appear in the
+// source so they can be masked like strings. Objects and arrays can only be
+// redacted, or masked field by field with a longer path.
+func scalarString(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case map[string]any:
+		return "", fmt.Errorf("value is a JSON object, redact it or mask its fields")
+	case []any:
+		return "", fmt.Errorf("value is a JSON array, redact it or mask its elements with [*]")
+	}
+	return fmt.Sprintf("%v", v), nil
+}
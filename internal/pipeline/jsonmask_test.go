@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+func TestMaskJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		path   []configs.PathStep
+		in     any
+		want   any
+		err    bool
+	}{
+		{
+			name:   "redact a nested key",
+			action: "redact",
+			path:   []configs.PathStep{{Key: "contact"}, {Key: "email"}},
+			in:     `{"contact":{"email":"a@b.io","name":"Ann"}}`,
+			want:   `{"contact":{"email":"REDACTED","name":"Ann"}}`,
+		},
+		{
+			name:   "mask_partial every array element",
+			action: "mask_partial",
+			path:   []configs.PathStep{{Any: true}, {Key: "card"}},
+			in:     `[{"card":"4111111111111111"},{"card":"5500005555555559"},{"other":1}]`,
+			want:   `[{"card":"************1111"},{"card":"************5559"},{"other":1}]`,
+		},
+		{
+			name:   "index and big numbers survive",
+			action: "hash",
+			path:   []configs.PathStep{{Index: 1}},
+			in:     `[9007199254740993,"x"]`,
+			want:   `[9007199254740993,"2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881"]`,
+		},
+		{
+			name:   "missing path is left alone",
+			action: "redact",
+			path:   []configs.PathStep{{Key: "nope"}},
+			in:     `{"a":"<b>"}`,
+			want:   `{"a":"<b>"}`,
+		},
+		{
+			name:   "object leaf is redacted",
+			action: "mask_partial",
+			path:   []configs.PathStep{{Key: "contact"}},
+			in:     `{"contact":{"email":"a@b.io"}}`,
+			want:   `{"contact":"REDACTED"}`,
+			err:    true,
+		},
+		{
+			name:   "column that is not JSON is redacted",
+			action: "mask_partial",
+			path:   []configs.PathStep{{Key: "email"}},
+			in:     `email=a@b.io`,
+			want:   "REDACTED",
+			err:    true,
+		},
+		{
+			name:   "decoded documents are masked in place",
+			action: "redact",
+			path:   []configs.PathStep{{Key: "email"}},
+			in:     map[string]any{"email": "a@b.io"},
+			want:   map[string]any{"email": "REDACTED"},
+		},
+	}
+
+	p := &Pipeline{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := configs.PIIMask{Action: tt.action, Column: "doc", Path: tt.path}
+			row := map[string]any{"doc": tt.in}
+			err := p.maskJSON(mask, row)
+			if (err != nil) != tt.err {
+				t.Errorf("err = %v, want error %v", err, tt.err)
+			}
+			if got := row["doc"]; !equalJSONValue(got, tt.want) {
+				t.Errorf("doc = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalJSONValue(got, want any) bool {
+	if w, ok := want.(map[string]any); ok {
+		g, ok := got.(map[string]any)
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for k, v := range w {
+			if g[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+	return got == want
+}
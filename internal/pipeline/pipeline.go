@@ -3,24 +3,65 @@ package pipeline
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"path"
 	"slices"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
 	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/internal/expr"
+	"github.com/MathewBravo/cdc-pipeline/internal/pii"
+	"github.com/MathewBravo/cdc-pipeline/pkg/fieldcrypt"
 )
 
 type Pipeline struct {
-	config   *configs.PipelineConfig
-	outputCh chan events.ChangeEvent
+	config  *configs.PipelineConfig
+	keys    *pii.Keyring
+	scanner *pii.Scanner
+	report  *pii.Report
+	// deadLetters receives events the pipeline holds back. It may be nil.
+	deadLetters dlq.Writer
+	outputCh    chan events.ChangeEvent
 }
 
-func NewPipeline(cfg *configs.PipelineConfig) *Pipeline {
-	return &Pipeline{
-		config:   cfg,
-		outputCh: make(chan events.ChangeEvent, 100),
+func NewPipeline(cfg *configs.PipelineConfig, deadLetters dlq.Writer) (*Pipeline, error) {
+	p := &Pipeline{
+		config:      cfg,
+		keys:        pii.NewKeyring(&cfg.PIIKeys),
+		deadLetters: deadLetters,
+		outputCh:    make(chan events.ChangeEvent, 100),
 	}
+	if p.scanEnabled() {
+		scanner, err := pii.NewScanner(cfg.PIIScan.Detectors)
+		if err != nil {
+			return nil, err
+		}
+		p.scanner = scanner
+		if cfg.PIIScan.Report != "" {
+			if p.report, err = pii.OpenReport(cfg.PIIScan.Report); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// scanEnabled reports whether any table can end up with a scan policy.
+func (p *Pipeline) scanEnabled() bool {
+	if p.config.PIIScan.Policy != "off" {
+		return true
+	}
+	for _, opts := range p.config.Tables {
+		if opts.PIIScan != "" && opts.PIIScan != "off" {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Pipeline) Start(eventCh <-chan events.ChangeEvent) <-chan events.ChangeEvent {
@@ -30,37 +71,200 @@ func (p *Pipeline) Start(eventCh <-chan events.ChangeEvent) <-chan events.Change
 
 func (p *Pipeline) processLoop(eventCh <-chan events.ChangeEvent) {
 	for event := range eventCh {
+		if event.IsCommit() {
+			p.outputCh <- event
+			continue
+		}
 		if p.isExcluded(event) {
 			continue
 		}
 		if !p.isOperationAllowed(event) {
 			continue
 		}
+		if ok, err := p.matchesFilter(event); err != nil {
+			p.filterFailed(event, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		event = p.projectColumns(event)
 		event = p.applyPIIMasks(event)
-		event = p.determineRoute(event)
-		p.outputCh <- event
+		var err error
+		if event, err = p.applyTransforms(event); err != nil {
+			p.transformFailed(event, err)
+			continue
+		}
+		outputs, err := p.runScript(event)
+		if err != nil {
+			p.scriptFailed(event, err)
+			continue
+		}
+		for _, out := range outputs {
+			out, source, err := p.finish(out)
+			if err != nil {
+				p.deadLetter(out, source, err)
+				continue
+			}
+			p.outputCh <- out
+		}
+	}
+	if p.report != nil {
+		p.report.Close()
 	}
 	close(p.outputCh)
 }
 
+// finish routes and scans one script output. On error it returns the stage
+// that held the event back.
+func (p *Pipeline) finish(event events.ChangeEvent) (events.ChangeEvent, string, error) {
+	if event.Route == "" {
+		event = p.determineRoute(event)
+	} else {
+		event.Route = configs.RouteName(event.Route)
+	}
+	if event.Route == "" {
+		return event, "route", errors.New("no route rendered a name for the event")
+	}
+	// scanned last, so values a transform or script moved or added are
+	// seen as they will be sent
+	event, err := p.scanForPII(event)
+	if err != nil {
+		// the values are what must not leave the pipeline, so the dead
+		// letter keeps only where they were found
+		blocked := events.ChangeEvent{Operation: event.Operation, NameSpace: event.NameSpace, Table: event.Table, Lsn: event.Lsn, Route: event.Route}
+		return blocked, "pii scan", err
+	}
+	return event, "", nil
+}
+
+// Rerun takes an event the filter, the transforms or a script failed on
+// through that stage again and the ones after it, for a DLQ replay. It fails
+// if any of them would hold the event back again. An event the filter now
+// leaves out has no outputs.
+func (p *Pipeline) Rerun(entry dlq.Entry) ([]events.ChangeEvent, error) {
+	event := entry.Event
+	switch entry.Stage {
+	case dlq.StageFilter:
+		ok, err := p.matchesFilter(event)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+		event = p.applyPIIMasks(p.projectColumns(event))
+		fallthrough
+	case dlq.StageTransform:
+		var err error
+		if event, err = p.applyTransforms(event); err != nil {
+			return nil, err
+		}
+	}
+	outputs, err := p.runScript(event)
+	if err != nil {
+		return nil, err
+	}
+	for i := range outputs {
+		out, source, err := p.finish(outputs[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		outputs[i] = out
+	}
+	return outputs, nil
+}
+
 func (p *Pipeline) isExcluded(event events.ChangeEvent) bool {
-	return slices.Contains(p.config.ExcludedTables, event.Table)
+	return p.config.IsExcluded(event.NameSpace, event.Table)
 }
 
 func (p *Pipeline) isOperationAllowed(event events.ChangeEvent) bool {
-	tableOptions, exists := p.config.Tables[event.Table]
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
 	if !exists {
 		return true
 	}
 	return slices.Contains(tableOptions.Operations, event.Operation.ToString())
 }
 
+// matchesFilter runs before masking so the filter sees the real values.
+func (p *Pipeline) matchesFilter(event events.ChangeEvent) (bool, error) {
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
+	if !exists || tableOptions.FilterProgram == nil {
+		return true, nil
+	}
+	ok, err := tableOptions.FilterProgram.Match(expr.EventEnv(&event))
+	if err != nil {
+		return false, fmt.Errorf("filter %q: %w", tableOptions.Filter, err)
+	}
+	return ok, nil
+}
+
+// filterFailed parks an event the filter could not be evaluated on as the
+// filter got it, before projection and masks, marked so a replay runs the
+// filter again.
+func (p *Pipeline) filterFailed(event events.ChangeEvent, err error) {
+	entry := dlq.NewEntry(event, "filter", 1, err)
+	entry.Stage = dlq.StageFilter
+	p.writeDeadLetter(entry)
+}
+
+// projectColumns drops columns per include_columns and exclude_columns from
+// both row images and the column list. Primary key columns always stay since
+// sinks key on them.
+func (p *Pipeline) projectColumns(event events.ChangeEvent) events.ChangeEvent {
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
+	if !exists || (len(tableOptions.IncludeColumns) == 0 && len(tableOptions.ExcludeColumns) == 0) {
+		return event
+	}
+	keep := func(col string) bool {
+		if slices.Contains(event.PK, col) {
+			return true
+		}
+		if len(tableOptions.IncludeColumns) > 0 && !matchesAny(tableOptions.IncludeColumns, col) {
+			return false
+		}
+		return !matchesAny(tableOptions.ExcludeColumns, col)
+	}
+
+	for col := range event.Before {
+		if !keep(col) {
+			delete(event.Before, col)
+		}
+	}
+	for col := range event.After {
+		if !keep(col) {
+			delete(event.After, col)
+		}
+	}
+	event.Columns = slices.DeleteFunc(slices.Clone(event.Columns), func(c events.Column) bool {
+		return !keep(c.Name)
+	})
+	return event
+}
+
+func matchesAny(patterns []string, col string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, col); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Pipeline) applyPIIMasks(event events.ChangeEvent) events.ChangeEvent {
-	tableOptions, exists := p.config.Tables[event.Table]
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
 	if !exists {
 		return event
 	}
 	for _, mask := range tableOptions.PIIMasks {
+		if len(mask.Path) > 0 {
+			for _, row := range []map[string]any{event.Before, event.After} {
+				if err := p.maskJSON(mask, row); err != nil {
+					fmt.Printf("ERROR: %s of %s.%s failed at %s: %v\n", mask.Action, event.Table, mask.Field, event.Lsn, err)
+				}
+			}
+			continue
+		}
 		switch mask.Action {
 		case "redact":
 			if event.Before != nil {
@@ -83,14 +287,24 @@ func (p *Pipeline) applyPIIMasks(event events.ChangeEvent) events.ChangeEvent {
 				event.After[mask.Field] = hex.EncodeToString(hash[:])
 			}
 		case "mask_partial":
-			if event.Before != nil {
-				if fld, ok := event.Before[mask.Field].(string); ok {
-					event.Before[mask.Field] = maskPartial(fld)
+			for _, row := range []map[string]any{event.Before, event.After} {
+				fld, ok := row[mask.Field]
+				if !ok || fld == nil {
+					continue
+				}
+				value, err := scalarString(fld)
+				if err != nil {
+					fmt.Printf("ERROR: mask_partial of %s.%s failed at %s, redacting: %v\n", event.Table, mask.Field, event.Lsn, err)
+					row[mask.Field] = "REDACTED"
+					continue
 				}
+				row[mask.Field] = maskPartial(value)
 			}
-			if event.After != nil {
-				if fld, ok := event.After[mask.Field].(string); ok {
-					event.After[mask.Field] = maskPartial(fld)
+		case "hmac", "tokenize", "encrypt":
+			for _, row := range []map[string]any{event.Before, event.After} {
+				if err := p.keyedMask(mask, row); err != nil {
+					fmt.Printf("ERROR: %s of %s.%s failed at %s, redacting: %v\n", mask.Action, event.Table, mask.Field, event.Lsn, err)
+					row[mask.Field] = "REDACTED"
 				}
 			}
 		}
@@ -99,6 +313,36 @@ func (p *Pipeline) applyPIIMasks(event events.ChangeEvent) events.ChangeEvent {
 	return event
 }
 
+// keyedMask replaces a non-null field with its HMAC, token or ciphertext.
+func (p *Pipeline) keyedMask(mask configs.PIIMask, row map[string]any) error {
+	v, ok := row[mask.Field]
+	if !ok || v == nil {
+		return nil
+	}
+	masked, err := p.keyedValue(mask, fmt.Sprintf("%v", v))
+	if err != nil {
+		return err
+	}
+	row[mask.Field] = masked
+	return nil
+}
+
+func (p *Pipeline) keyedValue(mask configs.PIIMask, value string) (string, error) {
+	switch mask.Action {
+	case "hmac":
+		return p.keys.HMAC(mask.KeyID, value)
+	case "tokenize":
+		return p.keys.Tokenize(mask.KeyID, value)
+	case "encrypt":
+		mode := fieldcrypt.ModeRandom
+		if mask.Mode == "deterministic" {
+			mode = fieldcrypt.ModeDeterministic
+		}
+		return p.config.Encryption.Keys.Encrypt(mask.KeyID, mode, value)
+	}
+	return "", fmt.Errorf("unknown keyed mask %q", mask.Action)
+}
+
 func maskPartial(s string) string {
 	n := len(s)
 	if n <= 4 {
@@ -107,14 +351,100 @@ func maskPartial(s string) string {
 	return strings.Repeat("*", n-4) + s[n-4:]
 }
 
+// determineRoute picks the first matching conditional route, then route_to,
+// then default_route. Routes are templates over routeData; one that fails or
+// renders empty falls through to the next.
 func (p *Pipeline) determineRoute(event events.ChangeEvent) events.ChangeEvent {
-	tableOptions, exists := p.config.Tables[event.Table]
-	if !exists {
-		event.Route = p.config.DefaultRoute
-		return event
+	data := newRouteData(&event)
+	if tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table); exists {
+		var env map[string]any
+		for _, rule := range tableOptions.Routes {
+			if env == nil {
+				env = expr.EventEnv(&event)
+			}
+			ok, err := rule.WhenProgram.Match(env)
+			if err != nil {
+				fmt.Printf("ERROR: Route condition %q for table %s failed at %s: %v\n", rule.When, event.Table, event.Lsn, err)
+				continue
+			}
+			if ok {
+				if route := renderRoute(rule.ToTemplate, data, event); route != "" {
+					event.Route, event.RouteSource = route, rule.To
+					return event
+				}
+			}
+		}
+		if tableOptions.RouteTemplate != nil {
+			if route := renderRoute(tableOptions.RouteTemplate, data, event); route != "" {
+				event.Route, event.RouteSource = route, tableOptions.Route
+				return event
+			}
+		}
 	}
-	route := tableOptions.Route
-	fmt.Println("ROUTE: ", route)
-	event.Route = route
+	event.Route, event.RouteSource = renderRoute(p.config.DefaultRouteTemplate, data, event), p.config.DefaultRoute
 	return event
 }
+
+// routeData is what route templates see, e.g.
+// cdc.{{.NameSpace}}.{{.Table}}.{{lower .Operation}} or orders.{{.Row.tenant_id}}.
+type routeData struct {
+	NameSpace string
+	Table     string
+	Operation string
+	Lsn       string
+	Before    map[string]any
+	After     map[string]any
+	Row       map[string]any
+}
+
+func newRouteData(event *events.ChangeEvent) routeData {
+	return routeData{
+		NameSpace: event.NameSpace,
+		Table:     event.Table,
+		Operation: event.Operation.ToString(),
+		Lsn:       event.Lsn,
+		Before:    event.Before,
+		After:     event.After,
+		Row:       event.Row(),
+	}
+}
+
+func renderRoute(tmpl *template.Template, data routeData, event events.ChangeEvent) string {
+	var route strings.Builder
+	if err := tmpl.Execute(&route, data); err != nil {
+		fmt.Printf("ERROR: Route template for table %s failed at %s: %v\n", event.Table, event.Lsn, err)
+		return ""
+	}
+	// a field of a missing row image renders as "<no value>"
+	if strings.Contains(route.String(), "<no value>") {
+		fmt.Printf("ERROR: Route template for table %s at %s references a missing value\n", event.Table, event.Lsn)
+		return ""
+	}
+	return configs.RouteName(strings.TrimSpace(route.String()))
+}
+
+// deadLetter parks an event the pipeline cannot pass on. Without a DLQ it is
+// only logged. The writer already retried and applied on_failure, so an
+// error means the policy is to block: the pipeline keeps trying and holds
+// every event behind this one, and with them the slot.
+func (p *Pipeline) deadLetter(event events.ChangeEvent, source string, err error) {
+	p.writeDeadLetter(dlq.NewEntry(event, source, 1, err))
+}
+
+func (p *Pipeline) writeDeadLetter(entry dlq.Entry) {
+	event := entry.Event
+	fmt.Printf("ERROR: %s failed on %s.%s at %s: %s\n", entry.Sink, event.NameSpace, event.Table, event.Lsn, entry.Error)
+	if p.deadLetters == nil {
+		return
+	}
+	backoff := 100 * time.Millisecond
+	for {
+		dlqErr := p.deadLetters.Write(entry)
+		if dlqErr == nil {
+			return
+		}
+		fmt.Printf("ERROR: Could not dead-letter event at %s, holding the pipeline: %v\n", event.Lsn, dlqErr)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func TestProjectColumns(t *testing.T) {
+	row := func() map[string]any {
+		return map[string]any{"id": 1, "email": "a@example.com", "name": "ada", "secret_token": "s", "secret_hint": "h"}
+	}
+	columns := []events.Column{{Name: "id"}, {Name: "email"}, {Name: "name"}, {Name: "secret_token"}, {Name: "secret_hint"}}
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+	}{
+		{name: "no projection", want: []string{"email", "id", "name", "secret_hint", "secret_token"}},
+		{name: "include", include: []string{"email", "name"}, want: []string{"email", "id", "name"}},
+		{name: "include glob", include: []string{"secret_*"}, want: []string{"id", "secret_hint", "secret_token"}},
+		{name: "exclude", exclude: []string{"secret_*"}, want: []string{"email", "id", "name"}},
+		{name: "both, exclude applies to what is included", include: []string{"name", "secret_*"}, exclude: []string{"secret_token"}, want: []string{"id", "name", "secret_hint"}},
+		{name: "primary key survives exclude", exclude: []string{"id", "email"}, want: []string{"id", "name", "secret_hint", "secret_token"}},
+		{name: "primary key survives include", include: []string{"nothing_*"}, want: []string{"id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &configs.PipelineConfig{Tables: map[string]configs.TableOptions{
+				"users": {IncludeColumns: tt.include, ExcludeColumns: tt.exclude},
+			}}
+			p := &Pipeline{config: cfg}
+
+			for _, op := range []events.Operation{events.OperationInsert, events.OperationUpdate, events.OperationDelete} {
+				event := events.ChangeEvent{Operation: op, NameSpace: "public", Table: "users", PK: []string{"id"}, Columns: columns}
+				if op != events.OperationInsert {
+					event.Before = row()
+				}
+				if op != events.OperationDelete {
+					event.After = row()
+				}
+				event = p.projectColumns(event)
+
+				for image, values := range map[string]map[string]any{"before": event.Before, "after": event.After} {
+					if values == nil {
+						continue
+					}
+					if got := slices.Sorted(maps.Keys(values)); !slices.Equal(got, tt.want) {
+						t.Errorf("%s %s = %v, want %v", op.ToString(), image, got, tt.want)
+					}
+				}
+				var names []string
+				for _, c := range event.Columns {
+					names = append(names, c.Name)
+				}
+				if slices.Sort(names); !slices.Equal(names, tt.want) {
+					t.Errorf("%s columns = %v, want %v", op.ToString(), names, tt.want)
+				}
+			}
+			if columns[3].Name != "secret_token" || columns[4].Name != "secret_hint" {
+				t.Errorf("column list %v was changed in place", columns)
+			}
+		})
+	}
+}
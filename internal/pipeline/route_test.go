@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// loadPipelineConfig compiles a pipeline section the way the config loader
+// does.
+func loadPipelineConfig(t *testing.T, pipeline string) *configs.PipelineConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("pipeline:\n"+pipeline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := configs.LoadWithoutSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cfg.Pipeline
+}
+
+type memoryDLQ struct {
+	entries []dlq.Entry
+}
+
+func (m *memoryDLQ) Write(entry dlq.Entry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memoryDLQ) Close() error { return nil }
+
+func TestRoutes(t *testing.T) {
+	cfg := loadPipelineConfig(t, `
+  default_route: '{{if ne .Table "unrouted"}}cdc.{{.Table}}{{end}}'
+  tables:
+    orders:
+      operations: [INSERT, UPDATE, DELETE]
+      routes:
+        - when: "row.total > 100"
+          to: orders.large
+      route_to: "orders.{{.Row.region}}"
+`)
+	deadLetters := &memoryDLQ{}
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan events.ChangeEvent)
+	out := p.Start(in)
+	go func() {
+		for _, event := range []events.ChangeEvent{
+			{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/1", After: map[string]any{"total": 500, "region": "eu"}},
+			{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/2", After: map[string]any{"total": 5, "region": "São Paulo"}},
+			{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/3", After: map[string]any{"total": 5}},
+			{Operation: events.OperationInsert, NameSpace: "public", Table: "users", Lsn: "0/4", After: map[string]any{"id": 1}},
+			{Operation: events.OperationInsert, NameSpace: "public", Table: "unrouted", Lsn: "0/5", After: map[string]any{"id": 1}},
+		} {
+			in <- event
+		}
+		close(in)
+	}()
+
+	want := map[string]string{
+		"0/1": "orders.large",
+		"0/2": "orders.S_o_Paulo",
+		// no region, falls through to the default
+		"0/3": "cdc.orders",
+		"0/4": "cdc.users",
+	}
+	got := make(map[string]string)
+	for event := range out {
+		got[event.Lsn] = event.Route
+	}
+	for lsn, route := range want {
+		if got[lsn] != route {
+			t.Errorf("event at %s routed to %q, want %q", lsn, got[lsn], route)
+		}
+	}
+	if _, ok := got["0/5"]; ok {
+		t.Error("event without a route was passed on")
+	}
+	if len(deadLetters.entries) != 1 || deadLetters.entries[0].Event.Lsn != "0/5" || deadLetters.entries[0].Sink != "route" {
+		t.Errorf("dead letters = %+v, want the unrouted event", deadLetters.entries)
+	}
+}
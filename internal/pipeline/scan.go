@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/internal/pii"
+)
+
+// scanForPII runs on the events a table's script returns, after masks and
+// transforms, and looks at the string columns that have no mask of their
+// own. Depending on the table's policy a finding is only reported, the
+// matches are replaced with REDACTED, or the event is held back. It returns
+// an error naming the columns and detectors when the event must not be sent.
+func (p *Pipeline) scanForPII(event events.ChangeEvent) (events.ChangeEvent, error) {
+	if p.scanner == nil {
+		return event, nil
+	}
+	policy := p.config.ScanPolicy(event.NameSpace, event.Table)
+	if policy == "off" {
+		return event, nil
+	}
+
+	masked := map[string]bool{}
+	if tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table); exists {
+		for _, mask := range tableOptions.PIIMasks {
+			if len(mask.Path) == 0 {
+				masked[mask.Column] = true
+			}
+		}
+	}
+
+	findings := map[string][]string{}
+	for _, row := range []map[string]any{event.Before, event.After} {
+		for col, v := range row {
+			s, ok := v.(string)
+			if !ok || masked[col] {
+				continue
+			}
+			found, redacted := p.scanner.Scan(s)
+			if len(found) == 0 {
+				continue
+			}
+			for _, d := range found {
+				if !slices.Contains(findings[col], d) {
+					findings[col] = append(findings[col], d)
+				}
+			}
+			if policy == "mask" {
+				row[col] = redacted
+			}
+		}
+	}
+	if len(findings) == 0 {
+		return event, nil
+	}
+
+	var found []string
+	for _, col := range slices.Sorted(maps.Keys(findings)) {
+		found = append(found, fmt.Sprintf("%s (%s)", col, strings.Join(findings[col], ", ")))
+		fmt.Printf("WARN: PII (%v) found in unmasked column %s.%s at %s, policy %s\n", findings[col], event.Table, col, event.Lsn, policy)
+		if p.report == nil {
+			continue
+		}
+		err := p.report.Write(pii.Finding{
+			Time:      time.Now().UTC(),
+			Lsn:       event.Lsn,
+			Schema:    event.NameSpace,
+			Table:     event.Table,
+			Column:    col,
+			Detectors: findings[col],
+			Policy:    policy,
+		})
+		if err != nil {
+			fmt.Printf("ERROR: Failed to write PII report: %v\n", err)
+		}
+	}
+	if policy == "block" {
+		return event, fmt.Errorf("unmasked PII in %s", strings.Join(found, ", "))
+	}
+	return event, nil
+}
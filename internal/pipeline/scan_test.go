@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func TestScanRunsAfterScriptAndDeadLettersBlocked(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "users.star")
+	err := os.WriteFile(script, []byte(`
+def transform(event):
+    row = event["after"]
+    if row["id"] == 2:
+        row["contact"] = "ann@example.com"
+    return event
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadPipelineConfig(t, `
+  default_route: cdc
+  tables:
+    users:
+      operations: [INSERT, UPDATE, DELETE]
+      pii_scan: block
+      script:
+        path: `+script+`
+`)
+	deadLetters := &memoryDLQ{}
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan events.ChangeEvent)
+	out := p.Start(in)
+	go func() {
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "users", Lsn: "0/1", After: map[string]any{"id": 1}}
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "users", Lsn: "0/2", After: map[string]any{"id": 2}}
+		close(in)
+	}()
+
+	var sent []string
+	for event := range out {
+		sent = append(sent, event.Lsn)
+	}
+	if len(sent) != 1 || sent[0] != "0/1" {
+		t.Errorf("sent %v, want [0/1]", sent)
+	}
+	if len(deadLetters.entries) != 1 {
+		t.Fatalf("dead letters = %+v, want the event the script added an email to", deadLetters.entries)
+	}
+	entry := deadLetters.entries[0]
+	if entry.Event.Lsn != "0/2" || entry.Sink != "pii scan" || entry.Event.Route != "cdc" || entry.Event.Table != "users" {
+		t.Errorf("dead letter = %+v", entry)
+	}
+	// the unmasked values stay out of the DLQ
+	if entry.Event.Before != nil || entry.Event.After != nil || entry.RawEvent != "" {
+		t.Errorf("dead letter keeps row images: before %v, after %v, raw %q", entry.Event.Before, entry.Event.After, entry.RawEvent)
+	}
+	if entry.Error != "unmasked PII in contact (email)" {
+		t.Errorf("dead letter error = %q, want the column and detector", entry.Error)
+	}
+}
+
+// downDLQ fails its first writes, like a DLQ with on_failure: block that is
+// briefly unreachable.
+type downDLQ struct {
+	memoryDLQ
+	failures int
+}
+
+func (d *downDLQ) Write(entry dlq.Entry) error {
+	if d.failures > 0 {
+		d.failures--
+		return errors.New("dlq is down")
+	}
+	return d.memoryDLQ.Write(entry)
+}
+
+func TestDeadLetterHoldsUntilWritten(t *testing.T) {
+	cfg := loadPipelineConfig(t, "  default_route: cdc\n")
+	deadLetters := &downDLQ{failures: 2}
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deadLetter(events.ChangeEvent{Table: "users", Lsn: "0/1"}, "route", errors.New("boom"))
+	if len(deadLetters.entries) != 1 || deadLetters.failures != 0 {
+		t.Errorf("entries = %d, failures left = %d; want 1, 0", len(deadLetters.entries), deadLetters.failures)
+	}
+}
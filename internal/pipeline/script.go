@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// runScript passes the event through the table's script, if it has one. The
+// script may return any number of events, including none.
+func (p *Pipeline) runScript(event events.ChangeEvent) ([]events.ChangeEvent, error) {
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
+	if !exists || tableOptions.Script.Program == nil {
+		return []events.ChangeEvent{event}, nil
+	}
+	outputs, err := tableOptions.Script.Program.Run(event)
+	if err != nil {
+		return nil, err
+	}
+	for i := range outputs {
+		outputs[i].Columns = scriptColumns(outputs[i])
+	}
+	return outputs, nil
+}
+
+// scriptColumns keeps the source types of columns still in the row and adds
+// the ones the script created.
+func scriptColumns(event events.ChangeEvent) []events.Column {
+	row := event.Row()
+	if row == nil {
+		return event.Columns
+	}
+	var cols []events.Column
+	for _, c := range event.Columns {
+		if _, ok := row[c.Name]; ok {
+			cols = append(cols, c)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(row)) {
+		if !slices.ContainsFunc(cols, func(c events.Column) bool { return c.Name == name }) {
+			cols = append(cols, events.Column{Name: name, TypeOID: oidFor(row[name])})
+		}
+	}
+	return cols
+}
+
+// scriptFailed parks an event the script failed on as the script got it,
+// marked so a replay runs the script again.
+func (p *Pipeline) scriptFailed(event events.ChangeEvent, err error) {
+	tableOptions, _ := p.config.TableOptions(event.NameSpace, event.Table)
+	entry := dlq.NewEntry(event, "script "+tableOptions.Script.Program.Path(), 1, err)
+	entry.Stage = dlq.StageScript
+	p.writeDeadLetter(entry)
+}
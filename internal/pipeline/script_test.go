@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func scriptPipeline(t *testing.T, source string, deadLetters dlq.Writer) *Pipeline {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "orders.star")
+	if err := os.WriteFile(script, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadPipelineConfig(t, `
+  default_route: cdc
+  tables:
+    orders:
+      operations: [INSERT, UPDATE, DELETE]
+      script:
+        path: `+script+`
+`)
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestScriptFailureIsRerunOnReplay(t *testing.T) {
+	deadLetters := &memoryDLQ{}
+	p := scriptPipeline(t, `
+def transform(event):
+    fail("not yet")
+`, deadLetters)
+
+	in := make(chan events.ChangeEvent)
+	out := p.Start(in)
+	go func() {
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/1", After: map[string]any{"id": int32(1)}}
+		close(in)
+	}()
+	for event := range out {
+		t.Errorf("sent %+v, want nothing", event)
+	}
+	if len(deadLetters.entries) != 1 {
+		t.Fatalf("dead letters = %+v, want the event the script failed on", deadLetters.entries)
+	}
+	entry := deadLetters.entries[0]
+	if entry.Stage != dlq.StageScript || entry.Event.Route != "" || entry.Event.After["id"] != int32(1) {
+		t.Fatalf("dead letter = %+v, want the event as the script got it", entry)
+	}
+
+	// the script is fixed before the replay
+	fixed := scriptPipeline(t, `
+def transform(event):
+    event["after"]["total"] = event["after"]["id"] * 10
+    return [event, dict(event, route = "audit")]
+`, nil)
+	outputs, err := fixed.Rerun(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("outputs = %+v, want 2", outputs)
+	}
+	if outputs[0].Route != "cdc" || outputs[1].Route != "audit" {
+		t.Errorf("routes = %q and %q, want cdc and audit", outputs[0].Route, outputs[1].Route)
+	}
+	if outputs[0].After["total"] != int64(10) {
+		t.Errorf("after = %v, want the script's total", outputs[0].After)
+	}
+
+	if _, err := p.Rerun(entry); err == nil {
+		t.Error("expected a rerun through the failing script to fail")
+	}
+}
@@ -0,0 +1,356 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/internal/expr"
+)
+
+// Type OIDs given to columns a transform creates or retypes, so sinks that
+// build tables from Columns see the new shape.
+const (
+	oidBool        = 16
+	oidInt8        = 20
+	oidText        = 25
+	oidFloat8      = 701
+	oidTimestamptz = 1184
+)
+
+// applyTransforms runs after masking, so renamed or computed fields never
+// expose a value a mask would have hidden. When a transform fails it returns
+// the event as it came in, so it can be dead-lettered and replayed.
+func (p *Pipeline) applyTransforms(event events.ChangeEvent) (events.ChangeEvent, error) {
+	tableOptions, exists := p.config.TableOptions(event.NameSpace, event.Table)
+	if !exists || len(tableOptions.Transforms) == 0 {
+		return event, nil
+	}
+	in := event
+	event.PK = slices.Clone(event.PK)
+	event.Columns = slices.Clone(event.Columns)
+	event.Before = maps.Clone(event.Before)
+	event.After = maps.Clone(event.After)
+
+	for _, t := range tableOptions.Transforms {
+		for _, row := range []map[string]any{event.Before, event.After} {
+			if row == nil {
+				continue
+			}
+			if err := applyTransform(t, row, &event); err != nil {
+				return in, fmt.Errorf("%s of %s: %w", t.Action, t.Field, err)
+			}
+		}
+		if t.Action == "rename" {
+			renameKey(&event, t.Field, t.To)
+		}
+	}
+	return event, nil
+}
+
+// transformFailed parks an event a transform failed on as the transforms
+// got it, marked so a replay runs them again.
+func (p *Pipeline) transformFailed(event events.ChangeEvent, err error) {
+	entry := dlq.NewEntry(event, "transform", 1, err)
+	entry.Stage = dlq.StageTransform
+	p.writeDeadLetter(entry)
+}
+
+func applyTransform(t configs.Transform, row map[string]any, event *events.ChangeEvent) error {
+	switch t.Action {
+	case "rename":
+		if v, ok := row[t.Field]; ok {
+			delete(row, t.Field)
+			row[t.To] = v
+		}
+	case "cast":
+		v, ok := row[t.Field]
+		if !ok || v == nil {
+			return nil
+		}
+		cast, oid, err := castValue(v, t.To, t.Unit)
+		if err != nil {
+			return err
+		}
+		row[t.Field] = cast
+		setColumn(event, t.Field, oid)
+	case "set":
+		row[t.Field] = t.Value
+		setColumn(event, t.Field, oidFor(t.Value))
+	case "compute":
+		var out strings.Builder
+		if err := t.Compiled.Execute(&out, templateData(row, event.Columns)); err != nil {
+			return err
+		}
+		row[t.Field] = out.String()
+		setColumn(event, t.Field, oidText)
+	case "flatten", "unnest":
+		v, ok := row[t.Field]
+		if !ok || v == nil {
+			return nil
+		}
+		obj, err := jsonObject(v)
+		if err != nil {
+			return err
+		}
+		delete(row, t.Field)
+		dropColumn(event, t.Field)
+		prefix := t.Field + t.Separator
+		if t.Action == "unnest" {
+			prefix = ""
+		}
+		flatten(obj, prefix, t.Separator, t.Action == "flatten", func(key string, value any) {
+			row[key] = value
+			setColumn(event, key, oidFor(value))
+		})
+	}
+	return nil
+}
+
+// templateData lets compute templates reference any column of the table.
+// Columns missing from a partial before image, and nulls, render as empty
+// strings instead of "<no value>".
+func templateData(row map[string]any, columns []events.Column) map[string]any {
+	data := make(map[string]any, len(row))
+	for _, c := range columns {
+		data[c.Name] = ""
+	}
+	for k, v := range row {
+		if v != nil {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+func castValue(v any, to, unit string) (any, uint32, error) {
+	switch to {
+	case "int":
+		switch t := v.(type) {
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+			if err == nil {
+				return i, oidInt8, nil
+			}
+			f, ferr := strconv.ParseFloat(strings.TrimSpace(t), 64)
+			if ferr != nil {
+				return nil, 0, err
+			}
+			v = f
+		case bool:
+			if t {
+				return int64(1), oidInt8, nil
+			}
+			return int64(0), oidInt8, nil
+		case int:
+			return int64(t), oidInt8, nil
+		case int8:
+			return int64(t), oidInt8, nil
+		case int16:
+			return int64(t), oidInt8, nil
+		case int32:
+			return int64(t), oidInt8, nil
+		case int64:
+			return t, oidInt8, nil
+		case uint8:
+			return int64(t), oidInt8, nil
+		case uint16:
+			return int64(t), oidInt8, nil
+		case uint32:
+			return int64(t), oidInt8, nil
+		case uint64:
+			if t > math.MaxInt64 {
+				return nil, 0, fmt.Errorf("%d is out of range for int", t)
+			}
+			return int64(t), oidInt8, nil
+		}
+		if f, ok := expr.ToFloat(v); ok {
+			i, err := wholeNumber(f)
+			if err != nil {
+				return nil, 0, err
+			}
+			return i, oidInt8, nil
+		}
+	case "float":
+		if s, ok := v.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			return f, oidFloat8, err
+		}
+		if f, ok := expr.ToFloat(v); ok {
+			return f, oidFloat8, nil
+		}
+	case "string":
+		switch t := v.(type) {
+		case string:
+			return t, oidText, nil
+		case time.Time:
+			return t.Format(time.RFC3339Nano), oidText, nil
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), oidText, nil
+		}
+		return fmt.Sprintf("%v", v), oidText, nil
+	case "bool":
+		if s, ok := v.(string); ok {
+			if s == "t" || s == "f" {
+				return s == "t", oidBool, nil
+			}
+			b, err := strconv.ParseBool(s)
+			return b, oidBool, err
+		}
+		if b, ok := v.(bool); ok {
+			return b, oidBool, nil
+		}
+		if f, ok := expr.ToFloat(v); ok {
+			return f != 0, oidBool, nil
+		}
+	case "timestamp":
+		if t, ok := v.(time.Time); ok {
+			return t, oidTimestamptz, nil
+		}
+		if s, ok := v.(string); ok {
+			s = strings.TrimSpace(s)
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, oidTimestamptz, nil
+			}
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				v = n
+			} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+				v = f
+			} else {
+				return nil, 0, fmt.Errorf("%q is neither RFC 3339 nor an epoch", s)
+			}
+		}
+		if t, ok := epoch(v, unit); ok {
+			return t, oidTimestamptz, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("cannot cast %T to %s", v, to)
+}
+
+// wholeNumber converts f to an int64 without truncating it. A fraction would
+// silently change the value, so "3.7" is an error rather than 3.
+func wholeNumber(f float64) (int64, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f) {
+		return 0, fmt.Errorf("%v is not a whole number", f)
+	}
+	if f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%v is out of range for int", f)
+	}
+	return int64(f), nil
+}
+
+// epoch reads n seconds, or the given unit, since 1970. Integers are read
+// exactly and floats keep their fraction down to the nanosecond.
+func epoch(n any, unit string) (time.Time, bool) {
+	perSecond := int64(1)
+	switch unit {
+	case "ms":
+		perSecond = 1e3
+	case "us":
+		perSecond = 1e6
+	case "ns":
+		perSecond = 1e9
+	}
+	var i int64
+	switch t := n.(type) {
+	case int:
+		i = int64(t)
+	case int64:
+		i = t
+	case uint64:
+		if t > math.MaxInt64 {
+			return time.Time{}, false
+		}
+		i = int64(t)
+	default:
+		f, ok := expr.ToFloat(n)
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, false
+		}
+		secs := math.Floor(f / float64(perSecond))
+		nanos := math.Round((f - secs*float64(perSecond)) * float64(1e9/perSecond))
+		return time.Unix(int64(secs), int64(nanos)).UTC(), true
+	}
+	return time.Unix(i/perSecond, i%perSecond*(1e9/perSecond)).UTC(), true
+}
+
+// jsonObject accepts a decoded object or jsonb text as the connector
+// delivers it.
+func jsonObject(v any) (map[string]any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		return t, nil
+	case string:
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(t), &obj); err != nil {
+			return nil, fmt.Errorf("not a JSON object: %w", err)
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("not a JSON object: %T", v)
+}
+
+// flatten emits every leaf of obj under prefix+key. Nested objects are
+// descended into when deep is set, otherwise they are emitted as they are.
+func flatten(obj map[string]any, prefix, sep string, deep bool, emit func(string, any)) {
+	for _, k := range slices.Sorted(maps.Keys(obj)) {
+		if nested, ok := obj[k].(map[string]any); ok && deep {
+			flatten(nested, prefix+k+sep, sep, deep, emit)
+			continue
+		}
+		emit(prefix+k, obj[k])
+	}
+}
+
+// renameKey follows a rename into the primary key and the column list.
+func renameKey(event *events.ChangeEvent, from, to string) {
+	for i, col := range event.PK {
+		if col == from {
+			event.PK[i] = to
+		}
+	}
+	for i := range event.Columns {
+		if event.Columns[i].Name == from {
+			event.Columns[i].Name = to
+		}
+	}
+}
+
+func setColumn(event *events.ChangeEvent, name string, oid uint32) {
+	for i := range event.Columns {
+		if event.Columns[i].Name == name {
+			event.Columns[i].TypeOID = oid
+			return
+		}
+	}
+	event.Columns = append(event.Columns, events.Column{Name: name, TypeOID: oid})
+}
+
+func dropColumn(event *events.ChangeEvent, name string) {
+	event.Columns = slices.DeleteFunc(event.Columns, func(c events.Column) bool {
+		return c.Name == name
+	})
+}
+
+func oidFor(v any) uint32 {
+	switch v.(type) {
+	case bool:
+		return oidBool
+	case time.Time:
+		return oidTimestamptz
+	case float32, float64:
+		return oidFloat8
+	}
+	if _, ok := expr.ToFloat(v); ok {
+		return oidInt8
+	}
+	return oidText
+}
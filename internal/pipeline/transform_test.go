@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+func TestCastValue(t *testing.T) {
+	tests := []struct {
+		in   any
+		to   string
+		unit string
+		want any
+	}{
+		{in: "42", to: "int", want: int64(42)},
+		{in: "3.0", to: "int", want: int64(3)},
+		{in: "1e3", to: "int", want: int64(1000)},
+		{in: -4.0, to: "int", want: int64(-4)},
+		{in: int64(9007199254740993), to: "int", want: int64(9007199254740993)},
+		{in: uint32(7), to: "int", want: int64(7)},
+		{in: int8(-2), to: "float", want: float64(-2)},
+		{in: uint16(0), to: "bool", want: false},
+		{in: 2.5, to: "string", want: "2.5"},
+		{in: int64(1700000000), to: "timestamp", want: time.Unix(1700000000, 0).UTC()},
+		{in: 1700000000.25, to: "timestamp", want: time.Unix(1700000000, 250000000).UTC()},
+		{in: "1700000000.5", to: "timestamp", want: time.Unix(1700000000, 500000000).UTC()},
+		{in: int64(1700000000123), to: "timestamp", unit: "ms", want: time.Unix(1700000000, 123000000).UTC()},
+		{in: 1700000000123.5, to: "timestamp", unit: "ms", want: time.Unix(1700000000, 123500000).UTC()},
+		{in: int64(1700000000123456789), to: "timestamp", unit: "ns", want: time.Unix(1700000000, 123456789).UTC()},
+		{in: "1700000000123456789", to: "timestamp", unit: "ns", want: time.Unix(1700000000, 123456789).UTC()},
+		{in: int64(-1500), to: "timestamp", unit: "ms", want: time.Unix(-2, 500000000).UTC()},
+		{in: int32(60), to: "timestamp", want: time.Unix(60, 0).UTC()},
+	}
+	for _, tt := range tests {
+		got, _, err := castValue(tt.in, tt.to, tt.unit)
+		if err != nil {
+			t.Errorf("cast %#v to %s: %v", tt.in, tt.to, err)
+			continue
+		}
+		if want, ok := tt.want.(time.Time); ok {
+			if !want.Equal(got.(time.Time)) {
+				t.Errorf("cast %#v to %s %s = %v, want %v", tt.in, tt.to, tt.unit, got, want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cast %#v to %s = %#v, want %#v", tt.in, tt.to, got, tt.want)
+		}
+	}
+
+	for _, in := range []any{"abc", []byte("1"), "tomorrow"} {
+		if got, _, err := castValue(in, "timestamp", ""); err == nil {
+			t.Errorf("cast %#v to timestamp = %v, want an error", in, got)
+		}
+	}
+	for _, in := range []any{"forty", "3.7", 3.7, float32(0.5), math.NaN(), math.Inf(1), 1e19, uint64(math.MaxUint64)} {
+		if got, _, err := castValue(in, "int", ""); err == nil {
+			t.Errorf("cast %#v to int = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestFailedTransformIsDeadLettered(t *testing.T) {
+	cfg := loadPipelineConfig(t, `
+  default_route: cdc
+  tables:
+    orders:
+      operations: [INSERT, UPDATE, DELETE]
+      transforms:
+        - {action: rename, field: qty, to: quantity}
+        - {action: cast, field: quantity, to: int}
+`)
+	deadLetters := &memoryDLQ{}
+	p, err := NewPipeline(cfg, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan events.ChangeEvent)
+	out := p.Start(in)
+	go func() {
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/1", After: map[string]any{"qty": "3"}}
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/2", After: map[string]any{"qty": "lots"}}
+		// a fraction is not cut off
+		in <- events.ChangeEvent{Operation: events.OperationInsert, NameSpace: "public", Table: "orders", Lsn: "0/3", After: map[string]any{"qty": "3.7"}}
+		close(in)
+	}()
+
+	var sent []events.ChangeEvent
+	for event := range out {
+		sent = append(sent, event)
+	}
+	if len(sent) != 1 || sent[0].After["quantity"] != int64(3) {
+		t.Errorf("sent %+v, want the event at 0/1 with quantity 3", sent)
+	}
+	if len(deadLetters.entries) != 2 || deadLetters.entries[1].Event.Lsn != "0/3" {
+		t.Fatalf("dead letters = %+v, want the events at 0/2 and 0/3", deadLetters.entries)
+	}
+	entry := deadLetters.entries[0]
+	if entry.Stage != dlq.StageTransform || entry.Sink != "transform" {
+		t.Errorf("dead letter = %+v, want it marked for a transform rerun", entry)
+	}
+	if entry.Event.After["qty"] != "lots" || entry.Event.After["quantity"] != nil {
+		t.Errorf("dead letter row = %v, want it as the transforms got it", entry.Event.After)
+	}
+	if _, err := p.Rerun(entry); err == nil {
+		t.Error("expected a rerun through the same transforms to fail")
+	}
+}
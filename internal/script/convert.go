@@ -0,0 +1,241 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+func eventDict(event events.ChangeEvent) (*starlark.Dict, error) {
+	before, err := toStarlark(mapOrNil(event.Before))
+	if err != nil {
+		return nil, err
+	}
+	after, err := toStarlark(mapOrNil(event.After))
+	if err != nil {
+		return nil, err
+	}
+	pk := make([]starlark.Value, len(event.PK))
+	for i, col := range event.PK {
+		pk[i] = starlark.String(col)
+	}
+
+	d := starlark.NewDict(8)
+	d.SetKey(starlark.String("op"), starlark.String(event.Operation.ToString()))
+	d.SetKey(starlark.String("schema"), starlark.String(event.NameSpace))
+	d.SetKey(starlark.String("table"), starlark.String(event.Table))
+	d.SetKey(starlark.String("lsn"), starlark.String(event.Lsn))
+	d.SetKey(starlark.String("route"), starlark.String(event.Route))
+	d.SetKey(starlark.String("pk"), starlark.NewList(pk))
+	d.SetKey(starlark.String("before"), before)
+	d.SetKey(starlark.String("after"), after)
+	return d, nil
+}
+
+func mapOrNil(m map[string]any) any {
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+// fromDict builds an event from what the script returned, starting from the
+// input so omitted keys keep their values. The LSN cannot be changed, and
+// Columns is left for the caller to update.
+func fromDict(d *starlark.Dict, in events.ChangeEvent) (events.ChangeEvent, error) {
+	out := in
+	for _, item := range d.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return out, fmt.Errorf("event keys must be strings, got %s", item[0].Type())
+		}
+		v, err := fromStarlark(item[1])
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", key, err)
+		}
+		switch key {
+		case "op":
+			if out.Operation, err = parseOperation(v); err != nil {
+				return out, err
+			}
+		case "schema":
+			if out.NameSpace, err = asString(key, v); err != nil {
+				return out, err
+			}
+		case "table":
+			if out.Table, err = asString(key, v); err != nil {
+				return out, err
+			}
+		case "route":
+			if out.Route, err = asString(key, v); err != nil {
+				return out, err
+			}
+		case "before", "after":
+			row, ok := v.(map[string]any)
+			if v != nil && !ok {
+				return out, fmt.Errorf("%s must be a dict or None", key)
+			}
+			if key == "before" {
+				out.Before = row
+			} else {
+				out.After = row
+			}
+		case "pk":
+			cols, ok := v.([]any)
+			if v != nil && !ok {
+				return out, fmt.Errorf("pk must be a list of column names")
+			}
+			out.PK = make([]string, len(cols))
+			for i, c := range cols {
+				if out.PK[i], err = asString("pk", c); err != nil {
+					return out, err
+				}
+			}
+		case "lsn":
+		default:
+			return out, fmt.Errorf("unknown event key %q", key)
+		}
+	}
+	return out, nil
+}
+
+func parseOperation(v any) (events.Operation, error) {
+	switch v {
+	case "INSERT":
+		return events.OperationInsert, nil
+	case "UPDATE":
+		return events.OperationUpdate, nil
+	case "DELETE":
+		return events.OperationDelete, nil
+	}
+	return 0, fmt.Errorf("op must be INSERT, UPDATE or DELETE, got %v", v)
+}
+
+func asString(key string, v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %T", key, v)
+	}
+	return s, nil
+}
+
+func toStarlark(v any) (starlark.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(t), nil
+	case string:
+		return starlark.String(t), nil
+	case int:
+		return starlark.MakeInt(t), nil
+	case int8:
+		return starlark.MakeInt64(int64(t)), nil
+	case int16:
+		return starlark.MakeInt64(int64(t)), nil
+	case int32:
+		return starlark.MakeInt64(int64(t)), nil
+	case int64:
+		return starlark.MakeInt64(t), nil
+	case uint:
+		return starlark.MakeUint(t), nil
+	case uint8:
+		return starlark.MakeUint64(uint64(t)), nil
+	case uint16:
+		return starlark.MakeUint64(uint64(t)), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(t)), nil
+	case uint64:
+		return starlark.MakeUint64(t), nil
+	case float32:
+		return starlark.Float(t), nil
+	case float64:
+		return starlark.Float(t), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("number %s is out of range", t)
+		}
+		return starlark.Float(f), nil
+	case time.Time:
+		return startime.Time(t), nil
+	case []byte:
+		return starlark.Bytes(t), nil
+	case map[string]any:
+		d := starlark.NewDict(len(t))
+		for _, k := range slices.Sorted(maps.Keys(t)) {
+			e, err := toStarlark(t[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			d.SetKey(starlark.String(k), e)
+		}
+		return d, nil
+	case []any:
+		list := make([]starlark.Value, len(t))
+		for i, e := range t {
+			var err error
+			if list[i], err = toStarlark(e); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(list), nil
+	}
+	return nil, fmt.Errorf("cannot pass a %T value to a script", v)
+}
+
+func fromStarlark(v starlark.Value) (any, error) {
+	switch t := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(t), nil
+	case starlark.String:
+		return string(t), nil
+	case starlark.Int:
+		i, ok := t.Int64()
+		if !ok {
+			return nil, fmt.Errorf("integer %s does not fit in 64 bits", t)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(t), nil
+	case startime.Time:
+		return time.Time(t), nil
+	case starlark.Bytes:
+		return []byte(t), nil
+	case *starlark.Dict:
+		m := make(map[string]any, t.Len())
+		for _, item := range t.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			e, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = e
+		}
+		return m, nil
+	case starlark.Indexable:
+		list := make([]any, t.Len())
+		for i := range t.Len() {
+			var err error
+			if list[i], err = fromStarlark(t.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to an event value", v.Type())
+}
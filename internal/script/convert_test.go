@@ -0,0 +1,48 @@
+package script
+
+import (
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestToStarlark(t *testing.T) {
+	tests := []struct {
+		in   any
+		want starlark.Value
+	}{
+		{in: nil, want: starlark.None},
+		{in: int8(-3), want: starlark.MakeInt(-3)},
+		{in: int32(7), want: starlark.MakeInt(7)},
+		{in: uint16(9), want: starlark.MakeInt(9)},
+		{in: uint32(4000000000), want: starlark.MakeUint64(4000000000)},
+		{in: uint64(1 << 63), want: starlark.MakeUint64(1 << 63)},
+		{in: json.Number("12"), want: starlark.MakeInt(12)},
+		{in: json.Number("1.5"), want: starlark.Float(1.5)},
+		{in: []any{"a", int64(1)}, want: starlark.NewList([]starlark.Value{starlark.String("a"), starlark.MakeInt(1)})},
+	}
+	for _, tt := range tests {
+		got, err := toStarlark(tt.in)
+		if err != nil {
+			t.Errorf("toStarlark(%#v): %v", tt.in, err)
+			continue
+		}
+		if eq, err := starlark.Equal(got, tt.want); err != nil || !eq {
+			t.Errorf("toStarlark(%#v) = %s (%s), want %s (%s)", tt.in, got, got.Type(), tt.want, tt.want.Type())
+		}
+	}
+}
+
+func TestToStarlarkRejectsUnknownTypes(t *testing.T) {
+	row := map[string]any{"addr": netip.MustParseAddr("10.0.0.1")}
+	_, err := toStarlark(row)
+	if err == nil || !strings.Contains(err.Error(), "addr") {
+		t.Errorf("toStarlark = %v, want an error naming the column", err)
+	}
+	if _, err := toStarlark(json.Number("1e999")); err == nil {
+		t.Error("expected an out of range number to fail")
+	}
+}
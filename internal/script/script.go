@@ -0,0 +1,185 @@
+// Package script runs Starlark scripts on change events. A script defines
+//
+//	def transform(event):
+//	    ...
+//
+// where event is a dict with op, schema, table, lsn, pk, route, before and
+// after. It returns the event, a list of events, or None to drop it.
+//
+// Scripts are sandboxed: there is no load, file, network or environment
+// access, only the json, math and time modules. Each call is limited in
+// execution steps and wall time.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// reloadCheck is how often the script file is checked for changes.
+const reloadCheck = time.Second
+
+var predeclared = starlark.StringDict{
+	"json": json.Module,
+	"math": math.Module,
+	"time": startime.Module,
+}
+
+// Script is a loaded script file. It picks up edits to the file on its own;
+// a version that fails to load is logged and the previous one kept.
+type Script struct {
+	path     string
+	maxSteps uint64
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	transform starlark.Callable
+	modTime   time.Time
+	checked   time.Time
+}
+
+func Load(path string, maxSteps uint64, timeout time.Duration) (*Script, error) {
+	s := &Script{path: path, maxSteps: maxSteps, timeout: timeout}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.transform, err = s.compile(); err != nil {
+		return nil, err
+	}
+	s.modTime = info.ModTime()
+	s.checked = time.Now()
+	return s, nil
+}
+
+func (s *Script) Path() string {
+	return s.path
+}
+
+func (s *Script) compile() (starlark.Callable, error) {
+	src, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	thread := s.newThread()
+	defer s.limit(thread)()
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, s.path, src, predeclared)
+	if err != nil {
+		return nil, describe(err)
+	}
+	fn, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not define transform(event)", s.path)
+	}
+	globals.Freeze()
+	return fn, nil
+}
+
+// reload swaps in the current file when it changed since the last check.
+func (s *Script) reload() {
+	if time.Since(s.checked) < reloadCheck {
+		return
+	}
+	s.checked = time.Now()
+	info, err := os.Stat(s.path)
+	if err != nil || info.ModTime().Equal(s.modTime) {
+		return
+	}
+	s.modTime = info.ModTime()
+	fn, err := s.compile()
+	if err != nil {
+		fmt.Printf("ERROR: Reloading script %s failed, keeping the previous version: %v\n", s.path, err)
+		return
+	}
+	s.transform = fn
+	fmt.Printf("Reloaded script %s\n", s.path)
+}
+
+func (s *Script) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  s.path,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Printf("SCRIPT %s: %s\n", s.path, msg) },
+	}
+	if s.maxSteps > 0 {
+		thread.SetMaxExecutionSteps(s.maxSteps)
+	}
+	return thread
+}
+
+// limit cancels the thread once the timeout passes. The returned func stops
+// the timer.
+func (s *Script) limit(thread *starlark.Thread) func() {
+	if s.timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(s.timeout, func() {
+		thread.Cancel(fmt.Sprintf("timed out after %s", s.timeout))
+	})
+	return func() { timer.Stop() }
+}
+
+// Run calls transform on the event. Events it returns keep the input's LSN
+// and Xid.
+func (s *Script) Run(event events.ChangeEvent) ([]events.ChangeEvent, error) {
+	s.mu.Lock()
+	s.reload()
+	fn := s.transform
+	s.mu.Unlock()
+
+	arg, err := eventDict(event)
+	if err != nil {
+		return nil, err
+	}
+	thread := s.newThread()
+	stop := s.limit(thread)
+	result, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+	stop()
+	if err != nil {
+		return nil, describe(err)
+	}
+
+	switch r := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.Dict:
+		out, err := fromDict(r, event)
+		if err != nil {
+			return nil, err
+		}
+		return []events.ChangeEvent{out}, nil
+	case *starlark.List:
+		outs := make([]events.ChangeEvent, 0, r.Len())
+		for i := range r.Len() {
+			d, ok := r.Index(i).(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("transform returned a list containing %s, want dicts", r.Index(i).Type())
+			}
+			out, err := fromDict(d, event)
+			if err != nil {
+				return nil, fmt.Errorf("event %d: %w", i, err)
+			}
+			outs = append(outs, out)
+		}
+		return outs, nil
+	}
+	return nil, fmt.Errorf("transform returned %s, want a dict, a list of dicts or None", result.Type())
+}
+
+// describe includes the Starlark stack for script errors.
+func describe(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
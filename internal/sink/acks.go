@@ -0,0 +1,46 @@
+package sink
+
+import "sync"
+
+// lsnTracker hands out acknowledgements in the order events were submitted,
+// so an LSN is only acked once everything before it has been delivered even
+// when delivery callbacks complete out of order.
+type lsnTracker struct {
+	mu      sync.Mutex
+	ack     AckFunc
+	base    uint64
+	pending []pendingLSN
+}
+
+type pendingLSN struct {
+	lsn  string
+	done bool
+}
+
+func newLSNTracker(ack AckFunc) *lsnTracker {
+	return &lsnTracker{ack: ack}
+}
+
+func (t *lsnTracker) track(lsn string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, pendingLSN{lsn: lsn})
+	return t.base + uint64(len(t.pending)) - 1
+}
+
+func (t *lsnTracker) done(seq uint64) {
+	t.mu.Lock()
+	t.pending[seq-t.base].done = true
+
+	var acked string
+	for len(t.pending) > 0 && t.pending[0].done {
+		acked = t.pending[0].lsn
+		t.pending = t.pending[1:]
+		t.base++
+	}
+	t.mu.Unlock()
+
+	if acked != "" && t.ack != nil {
+		t.ack(acked)
+	}
+}
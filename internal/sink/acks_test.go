@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestLSNTracker(t *testing.T) {
+	tests := []struct {
+		name string
+		done []int
+		want []string
+	}{
+		{name: "in order", done: []int{0, 1, 2}, want: []string{"0/0", "0/1", "0/2"}},
+		{name: "reversed", done: []int{2, 1, 0}, want: []string{"0/2"}},
+		{name: "gap filled later", done: []int{0, 2, 3, 1}, want: []string{"0/0", "0/3"}},
+		{name: "head missing", done: []int{1, 2, 3}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acked []string
+			tracker := newLSNTracker(func(lsn string) { acked = append(acked, lsn) })
+			seqs := make([]uint64, 4)
+			for i := range seqs {
+				seqs[i] = tracker.track(fmt.Sprintf("0/%d", i))
+			}
+			for _, i := range tt.done {
+				tracker.done(seqs[i])
+			}
+			if !slices.Equal(acked, tt.want) {
+				t.Errorf("acked %v, want %v", acked, tt.want)
+			}
+		})
+	}
+}
+
+func TestLSNTrackerKeepsSequenceAcrossAcks(t *testing.T) {
+	var acked []string
+	tracker := newLSNTracker(func(lsn string) { acked = append(acked, lsn) })
+	tracker.done(tracker.track("0/1"))
+	a := tracker.track("0/2")
+	b := tracker.track("0/3")
+	tracker.done(b)
+	tracker.done(a)
+	tracker.done(tracker.track("0/4"))
+	if !slices.Equal(acked, []string{"0/1", "0/3", "0/4"}) {
+		t.Errorf("acked %v, want [0/1 0/3 0/4]", acked)
+	}
+}
+
+func TestLSNTrackerConcurrentDone(t *testing.T) {
+	// acks are made outside the lock, so their calls may interleave; the
+	// receivers keep the highest LSN
+	var mu sync.Mutex
+	var acked []string
+	tracker := newLSNTracker(func(lsn string) {
+		mu.Lock()
+		acked = append(acked, lsn)
+		mu.Unlock()
+	})
+	seqs := make([]uint64, 1000)
+	for i := range seqs {
+		seqs[i] = tracker.track(fmt.Sprintf("0/%d", i))
+	}
+	var wg sync.WaitGroup
+	for _, seq := range seqs {
+		wg.Go(func() { tracker.done(seq) })
+	}
+	wg.Wait()
+	if !slices.Contains(acked, "0/999") || len(tracker.pending) != 0 {
+		t.Errorf("acked %d LSNs without 0/999 or with %d pending", len(acked), len(tracker.pending))
+	}
+}
+
+func TestLSNTrackerWithoutAck(t *testing.T) {
+	tracker := newLSNTracker(nil)
+	tracker.done(tracker.track("0/1"))
+	if len(tracker.pending) != 0 {
+		t.Errorf("%d pending, want none", len(tracker.pending))
+	}
+}
@@ -0,0 +1,361 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPSink publishes persistent messages to a RabbitMQ exchange with routing
+// keys "<route>.<schema>.<table>.<op>", the same as NATS subjects. Messages
+// are mandatory, so one that no queue is bound for comes back instead of
+// being dropped. LSNs are acked as publisher confirms arrive, in publish
+// order. A returned or nacked message is published again on its own until it
+// is confirmed. When the channel or connection drops, the sink reconnects and
+// republishes everything that was not confirmed, so consumers may see
+// duplicates with the same message ID. Events that cannot be encoded are
+// dead-lettered.
+type AMQPSink struct {
+	url         string
+	config      *configs.SinkConfig
+	ack         AckFunc
+	deadLetters dlq.Writer
+	conn        *amqp.Connection
+	ch          *amqp.Channel
+	closed      chan *amqp.Error
+	returns     chan amqp.Return
+	pending     []amqpPending
+	ids         msgIDs
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+type amqpPending struct {
+	key     string
+	msg     amqp.Publishing
+	lsn     string
+	confirm *amqp.DeferredConfirmation
+	// returned is set when the broker could not route the message.
+	returned bool
+	// commit is the LSN of a commit marker or dead-lettered event that
+	// followed the message. It is acked in place of lsn once the message is
+	// confirmed.
+	commit string
+}
+
+func NewAMQPSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*AMQPSink, error) {
+	u, err := url.Parse(cfg.AMQP.URL)
+	if err != nil {
+		return nil, fmt.Errorf("AMQP ERR: invalid url: %w", err)
+	}
+	if cfg.AMQP.Password != "" {
+		user := "guest"
+		if u.User != nil && u.User.Username() != "" {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, cfg.AMQP.Password)
+	}
+
+	a := &AMQPSink{
+		url:         u.String(),
+		config:      cfg,
+		ack:         ack,
+		deadLetters: deadLetters,
+		stopChan:    make(chan struct{}),
+	}
+	if err := a.connect(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// connect opens a connection and a channel in confirm mode and declares the
+// exchange.
+func (a *AMQPSink) connect() error {
+	a.close()
+
+	conn, err := amqp.Dial(a.url)
+	if err != nil {
+		return fmt.Errorf("AMQP ERR: failed to connect: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("AMQP ERR: failed to open channel: %w", err)
+	}
+	cfg := a.config.AMQP
+	if err := ch.ExchangeDeclare(cfg.Exchange, cfg.ExchangeType, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return fmt.Errorf("AMQP ERR: could not declare exchange %s: %w", cfg.Exchange, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("AMQP ERR: could not enable publisher confirms: %w", err)
+	}
+
+	a.conn = conn
+	a.ch = ch
+	a.closed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	// Returns arrive before the confirm of the same message. The buffer
+	// covers every message that can be pending, so the client never blocks
+	// delivering one.
+	a.returns = ch.NotifyReturn(make(chan amqp.Return, cfg.MaxPending+1))
+	return nil
+}
+
+func (a *AMQPSink) close() {
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn, a.ch = nil, nil
+	}
+}
+
+// Start publishes until the channel closes, keeping at most max_pending
+// messages waiting for a confirm. Once the pipeline closes the channel it
+// waits for the remaining confirms before returning.
+func (a *AMQPSink) Start(eventCh <-chan events.ChangeEvent) error {
+	a.wg.Go(func() {
+		defer a.close()
+		in := eventCh
+		for {
+			if in == nil && len(a.pending) == 0 {
+				return
+			}
+
+			var confirmed <-chan struct{}
+			if len(a.pending) > 0 {
+				confirmed = a.pending[0].confirm.Done()
+			}
+			next := in
+			if len(a.pending) >= a.config.AMQP.MaxPending {
+				next = nil
+			}
+
+			select {
+			case event, ok := <-next:
+				if !ok {
+					in = nil
+					continue
+				}
+				if event.IsCommit() {
+					a.ackBehind(event.Lsn)
+					continue
+				}
+				p, err := a.handleEvent(event)
+				if err != nil {
+					if !a.deadLetter(event, err) {
+						return
+					}
+					a.ackBehind(event.Lsn)
+					continue
+				}
+				a.pending = append(a.pending, p)
+				if err := a.publish(&a.pending[len(a.pending)-1]); err != nil {
+					fmt.Printf("ERROR: Publish failed: %v\n", err)
+					if !a.recover() {
+						return
+					}
+				}
+			case ret, ok := <-a.returns:
+				if !ok {
+					// the channel closed, a.closed reports why
+					a.returns = nil
+					continue
+				}
+				a.markReturned(ret)
+			case <-confirmed:
+				a.drainReturns()
+				head := &a.pending[0]
+				if !head.confirm.Acked() || head.returned {
+					if head.returned {
+						fmt.Printf("ERROR: Message at %s could not be routed by exchange %s with key %s\n", head.lsn, a.config.AMQP.Exchange, head.key)
+					} else {
+						fmt.Printf("ERROR: Broker did not confirm message at %s\n", head.lsn)
+					}
+					if !a.retry(head) {
+						return
+					}
+					continue
+				}
+				lsn := head.lsn
+				if head.commit != "" {
+					lsn = head.commit
+				}
+				a.pending = a.pending[1:]
+				if a.ack != nil {
+					a.ack(lsn)
+				}
+			case err := <-a.closed:
+				fmt.Printf("ERROR: AMQP channel closed: %v\n", err)
+				if !a.recover() {
+					return
+				}
+			case <-a.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (a *AMQPSink) Stop() {
+	close(a.stopChan)
+	a.wg.Wait()
+}
+
+// Close releases the connection of a sink that was never started; Start
+// closes it on the way out.
+func (a *AMQPSink) Close() {
+	a.close()
+}
+
+func (a *AMQPSink) handleEvent(event events.ChangeEvent) (amqpPending, error) {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return amqpPending{}, err
+	}
+	return amqpPending{
+		key: eventSubject(event),
+		lsn: event.Lsn,
+		msg: amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    a.ids.next(event.Lsn),
+			Timestamp:    time.Now(),
+			Body:         jsonEvent,
+		},
+	}, nil
+}
+
+// ackBehind acks lsn once every message published before it is confirmed.
+func (a *AMQPSink) ackBehind(lsn string) {
+	if len(a.pending) > 0 {
+		a.pending[len(a.pending)-1].commit = lsn
+		return
+	}
+	if a.ack != nil {
+		a.ack(lsn)
+	}
+}
+
+// deadLetter parks an event that cannot be published. Without a DLQ it is
+// dropped. It returns false only when stopping.
+func (a *AMQPSink) deadLetter(event events.ChangeEvent, err error) bool {
+	fmt.Printf("ERROR: Failed to publish event at %s with key %s: %v\n", event.Lsn, eventSubject(event), err)
+	if a.deadLetters == nil {
+		return true
+	}
+	return writeDeadLetter(a.deadLetters, dlq.NewEntry(event, a.name(), 1, err), a.stopChan)
+}
+
+func (a *AMQPSink) name() string {
+	if a.config.Name != "" {
+		return a.config.Name
+	}
+	return "amqp"
+}
+
+func (a *AMQPSink) publish(p *amqpPending) error {
+	if a.ch == nil {
+		return amqp.ErrClosed
+	}
+	confirm, err := a.ch.PublishWithDeferredConfirmWithContext(context.Background(), a.config.AMQP.Exchange, p.key, true, false, p.msg)
+	if err != nil {
+		return err
+	}
+	p.confirm = confirm
+	p.returned = false
+	return nil
+}
+
+func (a *AMQPSink) markReturned(ret amqp.Return) {
+	for i := range a.pending {
+		if a.pending[i].msg.MessageId == ret.MessageId {
+			a.pending[i].returned = true
+			return
+		}
+	}
+}
+
+func (a *AMQPSink) drainReturns() {
+	for {
+		select {
+		case ret, ok := <-a.returns:
+			if !ok {
+				a.returns = nil
+				return
+			}
+			a.markReturned(ret)
+		default:
+			return
+		}
+	}
+}
+
+// retry publishes one returned or nacked message again after a backoff. The
+// messages after it were accepted, so only this one is re-sent; if the
+// channel has gone away everything unconfirmed is. It returns false only when
+// stopping.
+func (a *AMQPSink) retry(p *amqpPending) bool {
+	backoff := 100 * time.Millisecond
+	for {
+		select {
+		case <-a.stopChan:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+
+		if a.ch == nil || a.ch.IsClosed() {
+			return a.recover()
+		}
+		if err := a.publish(p); err != nil {
+			fmt.Printf("ERROR: Republish at %s failed: %v\n", p.lsn, err)
+			continue
+		}
+		return true
+	}
+}
+
+// recover is called once the channel is known to be broken. It reconnects
+// and republishes every unconfirmed message in order, since their confirms
+// were lost with the channel. It returns false only when stopping.
+func (a *AMQPSink) recover() bool {
+	backoff := 100 * time.Millisecond
+	for {
+		err := a.republish()
+		if err == nil {
+			return true
+		}
+		fmt.Printf("ERROR: AMQP recovery failed: %v\n", err)
+
+		select {
+		case <-a.stopChan:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+func (a *AMQPSink) republish() error {
+	if err := a.connect(); err != nil {
+		return err
+	}
+	fmt.Println("AMQP: reconnected")
+	for i := range a.pending {
+		if err := a.publish(&a.pending[i]); err != nil {
+			a.close()
+			return err
+		}
+	}
+	return nil
+}
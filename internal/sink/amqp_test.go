@@ -0,0 +1,430 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// brokerReply is how the stand-in broker answers one publish.
+type brokerReply int
+
+const (
+	replyAck brokerReply = iota
+	replyNack
+	// replyReturn sends the message back as unroutable, then acks it, as
+	// RabbitMQ does for a mandatory message no queue is bound for.
+	replyReturn
+	// replyDrop closes the connection without confirming.
+	replyDrop
+)
+
+type brokerMessage struct {
+	exchange string
+	key      string
+	id       string
+	body     []byte
+}
+
+// fakeBroker speaks enough AMQP 0-9-1 for the sink: the connection
+// handshake, channels, exchange declares, publisher confirms and publishes.
+type fakeBroker struct {
+	lis net.Listener
+
+	mu        sync.Mutex
+	conns     int
+	exchanges []string
+	messages  []brokerMessage
+	reply     func(n int, msg brokerMessage) brokerReply
+}
+
+func runFakeBroker(t *testing.T, reply func(n int, msg brokerMessage) brokerReply) *fakeBroker {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{lis: lis, reply: reply}
+	var wg sync.WaitGroup
+	var open []net.Conn
+	wg.Go(func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns++
+			open = append(open, conn)
+			b.mu.Unlock()
+			wg.Go(func() { b.serve(conn) })
+		}
+	})
+	t.Cleanup(func() {
+		lis.Close()
+		b.mu.Lock()
+		for _, conn := range open {
+			conn.Close()
+		}
+		b.mu.Unlock()
+		wg.Wait()
+	})
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "amqp://guest:guest@" + b.lis.Addr().String() + "/"
+}
+
+func (b *fakeBroker) published() []brokerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.messages)
+}
+
+// amqpChannel is a channel's publish in progress and its confirm count.
+type amqpChannel struct {
+	tag    uint64
+	msg    *brokerMessage
+	header []byte
+	size   uint64
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil {
+		return
+	}
+	writeMethod(conn, 0, 10, 10, []byte{0, 9}, table(), longstr("PLAIN"), longstr("en_US"))
+	readFrame(r) // start-ok
+	writeMethod(conn, 0, 10, 30, u16(2047), u32(131072), u16(0))
+	readFrame(r) // tune-ok
+	readFrame(r) // open
+	writeMethod(conn, 0, 10, 41, shortstr(""))
+
+	channels := make(map[uint16]*amqpChannel)
+	for {
+		typ, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		ch := channels[channel]
+		switch typ {
+		case 1:
+			args := bytes.NewReader(payload[4:])
+			switch [2]uint16{binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])} {
+			case [2]uint16{10, 50}:
+				writeMethod(conn, 0, 10, 51)
+				return
+			case [2]uint16{20, 10}:
+				channels[channel] = &amqpChannel{}
+				writeMethod(conn, channel, 20, 11, longstr(""))
+			case [2]uint16{20, 40}:
+				delete(channels, channel)
+				writeMethod(conn, channel, 20, 41)
+			case [2]uint16{40, 10}:
+				args.Seek(2, io.SeekCurrent)
+				b.mu.Lock()
+				b.exchanges = append(b.exchanges, readShortstr(args))
+				b.mu.Unlock()
+				writeMethod(conn, channel, 40, 11)
+			case [2]uint16{85, 10}:
+				writeMethod(conn, channel, 85, 11)
+			case [2]uint16{60, 40}:
+				args.Seek(2, io.SeekCurrent)
+				ch.msg = &brokerMessage{exchange: readShortstr(args), key: readShortstr(args)}
+			}
+			continue
+		case 2:
+			ch.header = payload
+			ch.size = binary.BigEndian.Uint64(payload[4:])
+			ch.msg.id = messageID(payload[12:])
+		case 3:
+			ch.msg.body = append(ch.msg.body, payload...)
+		default:
+			continue
+		}
+		if ch == nil || ch.msg == nil || uint64(len(ch.msg.body)) < ch.size {
+			continue
+		}
+
+		msg := *ch.msg
+		ch.msg = nil
+		ch.tag++
+		b.mu.Lock()
+		n := len(b.messages)
+		b.messages = append(b.messages, msg)
+		b.mu.Unlock()
+
+		switch b.reply(n, msg) {
+		case replyDrop:
+			return
+		case replyNack:
+			writeMethod(conn, channel, 60, 120, u64(ch.tag), []byte{0})
+			continue
+		case replyReturn:
+			writeMethod(conn, channel, 60, 50, u16(312), shortstr("NO_ROUTE"), shortstr(msg.exchange), shortstr(msg.key))
+			writeFrame(conn, 2, channel, ch.header)
+			writeFrame(conn, 3, channel, msg.body)
+		}
+		writeMethod(conn, channel, 60, 80, u64(ch.tag), []byte{0})
+	}
+}
+
+func readFrame(r *bufio.Reader) (typ byte, channel uint16, payload []byte, err error) {
+	head := make([]byte, 7)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return 0, 0, nil, err
+	}
+	payload = make([]byte, binary.BigEndian.Uint32(head[3:]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err = r.ReadByte(); err != nil {
+		return 0, 0, nil, err
+	}
+	return head[0], binary.BigEndian.Uint16(head[1:]), payload, nil
+}
+
+func writeFrame(w io.Writer, typ byte, channel uint16, payload []byte) {
+	frame := append([]byte{typ}, u16(channel)...)
+	frame = append(frame, u32(uint32(len(payload)))...)
+	frame = append(frame, payload...)
+	w.Write(append(frame, 0xCE))
+}
+
+func writeMethod(w io.Writer, channel, class, method uint16, args ...[]byte) {
+	payload := append(u16(class), u16(method)...)
+	for _, arg := range args {
+		payload = append(payload, arg...)
+	}
+	writeFrame(w, 1, channel, payload)
+}
+
+// messageID reads the message-id property, skipping the ones before it.
+func messageID(props []byte) string {
+	r := bytes.NewReader(props[2:])
+	flags := binary.BigEndian.Uint16(props)
+	for bit := 15; bit > 7; bit-- {
+		if flags&(1<<bit) == 0 {
+			continue
+		}
+		switch bit {
+		case 13: // headers
+			var n uint32
+			binary.Read(r, binary.BigEndian, &n)
+			r.Seek(int64(n), io.SeekCurrent)
+		case 12, 11: // delivery mode, priority
+			r.ReadByte()
+		default:
+			readShortstr(r)
+		}
+	}
+	if flags&(1<<7) == 0 {
+		return ""
+	}
+	return readShortstr(r)
+}
+
+func readShortstr(r *bytes.Reader) string {
+	n, _ := r.ReadByte()
+	s := make([]byte, n)
+	io.ReadFull(r, s)
+	return string(s)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func shortstr(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+func longstr(s string) []byte  { return append(u32(uint32(len(s))), s...) }
+func table() []byte            { return u32(0) }
+
+func startAMQPSink(t *testing.T, b *fakeBroker, acks *ackRecorder, deadLetters dlq.Writer) (*AMQPSink, chan events.ChangeEvent) {
+	t.Helper()
+	cfg := &configs.SinkConfig{
+		Type: "amqp",
+		AMQP: configs.AMQPConfig{URL: b.url(), Exchange: "cdc", ExchangeType: "topic", MaxPending: 8},
+	}
+	a, err := NewAMQPSink(cfg, acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent, 10)
+	if err := a.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	return a, in
+}
+
+func messageIDs(msgs []brokerMessage) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.id
+	}
+	return ids
+}
+
+func TestAMQPSinkPublishesAndAcksOnConfirm(t *testing.T) {
+	b := runFakeBroker(t, func(int, brokerMessage) brokerReply { return replyAck })
+	acks := newAckRecorder(-1)
+	a, in := startAMQPSink(t, b, acks, nil)
+
+	in <- testEvent("0/10", "cdc")
+	in <- testEvent("0/11", "orders")
+	in <- commitEvent("0/20")
+	// a commit that arrives before the confirm is acked in place of the
+	// message
+	got := acks.waitFor(t, "0/20")
+	in <- commitEvent("0/30")
+	got = acks.waitFor(t, "0/30")
+	a.Stop()
+
+	if slices.Contains(got, "0/11") || !slices.IsSorted(got) {
+		t.Errorf("acks = %v, want them in order with 0/20 in place of 0/11", got)
+	}
+	msgs := b.published()
+	if len(msgs) != 2 {
+		t.Fatalf("published %d messages, want 2", len(msgs))
+	}
+	if msgs[0].key != "cdc.public.users.insert" || msgs[1].key != "orders.public.users.insert" {
+		t.Errorf("routing keys = %q and %q", msgs[0].key, msgs[1].key)
+	}
+	b.mu.Lock()
+	exchanges := b.exchanges
+	b.mu.Unlock()
+	if msgs[0].exchange != "cdc" || !slices.Equal(exchanges, []string{"cdc"}) {
+		t.Errorf("published to %q after declaring %v, want cdc", msgs[0].exchange, exchanges)
+	}
+	if !slices.Equal(messageIDs(msgs), []string{"0/10-0", "0/11-0"}) {
+		t.Errorf("message IDs = %v", messageIDs(msgs))
+	}
+	if !bytes.Contains(msgs[0].body, []byte(`"Lsn":"0/10"`)) {
+		t.Errorf("body = %s, want the event", msgs[0].body)
+	}
+}
+
+func TestAMQPSinkRepublishesReturnedAndNackedMessages(t *testing.T) {
+	b := runFakeBroker(t, func(n int, _ brokerMessage) brokerReply {
+		switch n {
+		case 0:
+			return replyReturn
+		case 1:
+			return replyNack
+		}
+		return replyAck
+	})
+	acks := newAckRecorder(-1)
+	a, in := startAMQPSink(t, b, acks, nil)
+
+	in <- testEvent("0/10", "cdc")
+	in <- testEvent("0/11", "cdc")
+	in <- commitEvent("0/20")
+	got := acks.waitFor(t, "0/20")
+	a.Stop()
+
+	if !slices.Equal(got, []string{"0/10", "0/20"}) {
+		t.Errorf("acks = %v, want [0/10 0/20]", got)
+	}
+	// each is sent again on its own, not the messages after it
+	if ids := messageIDs(b.published()); !slices.Equal(ids, []string{"0/10-0", "0/11-0", "0/10-0", "0/11-0"}) {
+		t.Errorf("message IDs = %v", ids)
+	}
+}
+
+func TestAMQPSinkRepublishesAfterConnectionLoss(t *testing.T) {
+	b := runFakeBroker(t, func(n int, _ brokerMessage) brokerReply {
+		if n == 1 {
+			return replyDrop
+		}
+		return replyAck
+	})
+	acks := newAckRecorder(-1)
+	a, in := startAMQPSink(t, b, acks, nil)
+
+	in <- testEvent("0/10", "cdc")
+	in <- testEvent("0/11", "cdc")
+	in <- commitEvent("0/20")
+	got := acks.waitFor(t, "0/20")
+	a.Stop()
+
+	if !slices.IsSorted(got) || got[len(got)-1] != "0/20" {
+		t.Errorf("acks = %v, want them in order up to 0/20", got)
+	}
+	b.mu.Lock()
+	conns := b.conns
+	b.mu.Unlock()
+	if conns != 2 {
+		t.Errorf("%d connections, want a reconnect", conns)
+	}
+	// 0/10 may be sent again if its confirm was not read before the drop
+	ids := messageIDs(b.published())
+	if ids[len(ids)-1] != "0/11-0" || len(slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return id != "0/11-0" })) != 2 {
+		t.Errorf("message IDs = %v, want 0/11-0 sent again on the new connection", ids)
+	}
+}
+
+func TestAMQPSinkWaitsForConfirmsOnClose(t *testing.T) {
+	release := make(chan struct{})
+	b := runFakeBroker(t, func(n int, _ brokerMessage) brokerReply {
+		if n == 0 {
+			<-release
+		}
+		return replyAck
+	})
+	acks := newAckRecorder(1)
+	a, in := startAMQPSink(t, b, acks, nil)
+
+	in <- testEvent("0/10", "cdc")
+	in <- commitEvent("0/20")
+	close(in)
+	time.Sleep(100 * time.Millisecond)
+	if got := acks.acked(); len(got) != 0 {
+		t.Errorf("acked %v before the broker confirmed", got)
+	}
+	close(release)
+	if got := acks.wait(t); !slices.Equal(got, []string{"0/20"}) {
+		t.Errorf("acks = %v, want [0/20]", got)
+	}
+	a.wg.Wait()
+}
+
+func TestAMQPSinkDeadLettersUnencodableEvents(t *testing.T) {
+	b := runFakeBroker(t, func(int, brokerMessage) brokerReply { return replyAck })
+	acks := newAckRecorder(-1)
+	deadLetters := &memoryDLQ{}
+	a, in := startAMQPSink(t, b, acks, deadLetters)
+
+	in <- testEvent("0/10", "cdc")
+	// JSON has no NaN, so the event cannot be encoded
+	bad := testEvent("0/20", "cdc")
+	bad.After = map[string]any{"id": 2, "score": math.NaN()}
+	in <- bad
+	in <- testEvent("0/30", "cdc")
+	in <- commitEvent("0/40")
+	got := acks.waitFor(t, "0/40")
+	a.Stop()
+
+	if !slices.IsSorted(got) {
+		t.Errorf("acks = %v, want them in order", got)
+	}
+	if lsns := deadLetters.lsns(); !slices.Equal(lsns, []string{"0/20"}) {
+		t.Errorf("dead letters = %v, want [0/20]", lsns)
+	}
+	if ids := messageIDs(b.published()); !slices.Equal(ids, []string{"0/10-0", "0/30-0"}) {
+		t.Errorf("published %v, want 0/10 and 0/30", ids)
+	}
+}
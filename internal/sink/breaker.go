@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/metrics"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops a sink from taking new events after too many failed
+// deliveries in a row. Once the open timeout passes a single probe is let
+// through: if it is delivered the breaker closes, otherwise it opens again.
+// A threshold of 0 disables it.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	name        string
+	threshold   int
+	openTimeout time.Duration
+	state       breakerState
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	changed     chan struct{}
+}
+
+func newCircuitBreaker(name string, threshold int, openTimeout time.Duration) *circuitBreaker {
+	metrics.SetGauge(metrics.SinkBreakerState, name, int64(breakerClosed))
+	return &circuitBreaker{
+		name:        name,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		changed:     make(chan struct{}),
+	}
+}
+
+// wait blocks until the sink may take another event. It returns false if
+// stop closes first.
+func (b *circuitBreaker) wait(stop <-chan struct{}) bool {
+	if b.threshold == 0 {
+		return true
+	}
+	for {
+		b.mu.Lock()
+		var timer <-chan time.Time
+		switch b.state {
+		case breakerClosed:
+			b.mu.Unlock()
+			return true
+		case breakerOpen:
+			remaining := b.openTimeout - time.Since(b.openedAt)
+			if remaining <= 0 {
+				b.setState(breakerHalfOpen)
+				b.probing = true
+				b.mu.Unlock()
+				return true
+			}
+			timer = time.After(remaining)
+		case breakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				b.mu.Unlock()
+				return true
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-stop:
+			return false
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
+
+func (b *circuitBreaker) success() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.probing = false
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	case b.state == breakerClosed && b.failures >= b.threshold:
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// skip hands the probe back when the event never reached the destination,
+// e.g. because it could not be serialized.
+func (b *circuitBreaker) skip() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.probing {
+		b.probing = false
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
+
+// setState must be called with mu held.
+func (b *circuitBreaker) setState(state breakerState) {
+	fmt.Printf("BREAKER: Sink %s circuit %s -> %s after %d consecutive failures\n", b.name, b.state, state, b.failures)
+	b.state = state
+	metrics.SetGauge(metrics.SinkBreakerState, b.name, int64(state))
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"testing"
+	"time"
+)
+
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// waitReturns reports whether wait returns within d.
+func waitReturns(b *circuitBreaker, d time.Duration) bool {
+	stop := make(chan struct{})
+	done := make(chan bool, 1)
+	go func() { done <- b.wait(stop) }()
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(d):
+		close(stop)
+		<-done
+		return false
+	}
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := newCircuitBreaker("test", 3, time.Hour)
+	b.failure()
+	b.failure()
+	b.success()
+	b.failure()
+	b.failure()
+	if b.current() != breakerClosed {
+		t.Fatalf("state = %s after a success reset the count, want closed", b.current())
+	}
+	b.failure()
+	if b.current() != breakerOpen {
+		t.Fatalf("state = %s after 3 failures in a row, want open", b.current())
+	}
+	if waitReturns(b, 50*time.Millisecond) {
+		t.Error("wait returned while the breaker was open")
+	}
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	b := newCircuitBreaker("test", 1, 50*time.Millisecond)
+	b.failure()
+
+	if !waitReturns(b, time.Second) {
+		t.Fatal("wait did not return after the open timeout")
+	}
+	if b.current() != breakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", b.current())
+	}
+	// only one probe at a time
+	if waitReturns(b, 20*time.Millisecond) {
+		t.Fatal("second probe let through while the first is in flight")
+	}
+
+	// a failed probe opens the breaker again
+	b.failure()
+	if b.current() != breakerOpen {
+		t.Fatalf("state = %s after a failed probe, want open", b.current())
+	}
+
+	if !waitReturns(b, time.Second) {
+		t.Fatal("wait did not return after the second open timeout")
+	}
+	// a probe that never reached the destination is handed back
+	b.skip()
+	if !waitReturns(b, 20*time.Millisecond) {
+		t.Fatal("probe was not handed back by skip")
+	}
+
+	b.success()
+	if b.current() != breakerClosed {
+		t.Fatalf("state = %s after a delivered probe, want closed", b.current())
+	}
+	if !waitReturns(b, 20*time.Millisecond) {
+		t.Error("wait blocked on a closed breaker")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker("test", 0, time.Hour)
+	for range 10 {
+		b.failure()
+	}
+	if b.current() != breakerClosed || !waitReturns(b, 20*time.Millisecond) {
+		t.Error("breaker with threshold 0 opened")
+	}
+}
+
+func TestCircuitBreakerWaitStops(t *testing.T) {
+	b := newCircuitBreaker("test", 1, time.Hour)
+	b.failure()
+	stop := make(chan struct{})
+	close(stop)
+	if b.wait(stop) {
+		t.Error("wait returned true after stop closed")
+	}
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+)
+
+// deadLetterQueue writes dead letters on its own goroutine so delivery
+// callbacks never wait on the DLQ. While entries are queued the sink should
+// stop taking events (see wait), so a DLQ that is down backs up the pipeline
+// instead of piling up unacknowledged events in memory.
+type deadLetterQueue struct {
+	writer  dlq.Writer
+	stop    <-chan struct{}
+	mu      sync.Mutex
+	pending []deadLetter
+	wake    chan struct{}
+	// empty is closed while nothing is queued
+	empty chan struct{}
+}
+
+type deadLetter struct {
+	entry dlq.Entry
+	done  func()
+}
+
+func newDeadLetterQueue(writer dlq.Writer, stop <-chan struct{}) *deadLetterQueue {
+	empty := make(chan struct{})
+	close(empty)
+	return &deadLetterQueue{
+		writer: writer,
+		stop:   stop,
+		wake:   make(chan struct{}, 1),
+		empty:  empty,
+	}
+}
+
+// add queues an entry; done runs once it is written.
+func (q *deadLetterQueue) add(entry dlq.Entry, done func()) {
+	q.mu.Lock()
+	if len(q.pending) == 0 {
+		q.empty = make(chan struct{})
+	}
+	q.pending = append(q.pending, deadLetter{entry: entry, done: done})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until the queue is empty. It returns false when stopping.
+func (q *deadLetterQueue) wait() bool {
+	q.mu.Lock()
+	empty := q.empty
+	q.mu.Unlock()
+	select {
+	case <-empty:
+		return true
+	case <-q.stop:
+		return false
+	}
+}
+
+func (q *deadLetterQueue) run() {
+	for {
+		select {
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+		for {
+			// a wake left over from an add drained in an earlier pass finds
+			// nothing to do
+			q.mu.Lock()
+			if len(q.pending) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			next := q.pending[0]
+			q.mu.Unlock()
+
+			if !writeDeadLetter(q.writer, next.entry, q.stop) {
+				return
+			}
+			next.done()
+
+			q.mu.Lock()
+			q.pending = q.pending[1:]
+			if len(q.pending) == 0 {
+				close(q.empty)
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// writeDeadLetter keeps writing until the DLQ takes the entry. The writer
+// already retried and applied on_failure, so an error here means the policy
+// is to block. It returns false only when stopping.
+func writeDeadLetter(w dlq.Writer, entry dlq.Entry, stop <-chan struct{}) bool {
+	backoff := 100 * time.Millisecond
+	for {
+		err := w.Write(entry)
+		if err == nil {
+			return true
+		}
+		fmt.Printf("ERROR: Could not dead-letter event at %s, holding its LSN: %v\n", entry.Event.Lsn, err)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
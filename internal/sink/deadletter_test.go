@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// downDLQ fails until up is set.
+type downDLQ struct {
+	memoryDLQ
+	up atomic.Bool
+}
+
+func (d *downDLQ) Write(entry dlq.Entry) error {
+	if !d.up.Load() {
+		return errors.New("unavailable")
+	}
+	return d.memoryDLQ.Write(entry)
+}
+
+func TestDeadLetterQueueHoldsSinkWhileFailing(t *testing.T) {
+	writer := &downDLQ{}
+	stop := make(chan struct{})
+	defer close(stop)
+	q := newDeadLetterQueue(writer, stop)
+	go q.run()
+
+	if !q.wait() {
+		t.Fatal("empty queue should not block")
+	}
+
+	var done atomic.Int32
+	q.add(dlq.NewEntry(testEvent("0/1", "cdc"), "kafka", 1, errors.New("boom")), func() { done.Add(1) })
+
+	waited := make(chan bool)
+	go func() { waited <- q.wait() }()
+	select {
+	case <-waited:
+		t.Fatal("wait returned while the DLQ is down")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	writer.up.Store(true)
+	select {
+	case ok := <-waited:
+		if !ok {
+			t.Fatal("wait reported stopping")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("wait did not return once the DLQ came back")
+	}
+	if done.Load() != 1 {
+		t.Errorf("done ran %d times, want 1", done.Load())
+	}
+	if got := writer.lsns(); len(got) != 1 || got[0] != "0/1" {
+		t.Errorf("written = %v, want [0/1]", got)
+	}
+}
+
+func TestDeadLetterQueueStops(t *testing.T) {
+	stop := make(chan struct{})
+	q := newDeadLetterQueue(&downDLQ{}, stop)
+	go q.run()
+	q.add(dlq.NewEntry(events.ChangeEvent{Lsn: "0/1"}, "kafka", 1, errors.New("boom")), func() {})
+
+	close(stop)
+	if q.wait() {
+		t.Error("wait should report stopping")
+	}
+}
+
+func TestDeadLetterQueueDrainsRepeatedly(t *testing.T) {
+	writer := &downDLQ{}
+	stop := make(chan struct{})
+	defer close(stop)
+	q := newDeadLetterQueue(writer, stop)
+	go q.run()
+
+	// the second add leaves a wake behind that finds the queue drained
+	q.add(dlq.NewEntry(testEvent("0/1", "cdc"), "kafka", 1, errors.New("boom")), func() {})
+	q.add(dlq.NewEntry(testEvent("0/2", "cdc"), "kafka", 1, errors.New("boom")), func() {})
+	writer.up.Store(true)
+	if !q.wait() {
+		t.Fatal("wait reported stopping")
+	}
+	q.add(dlq.NewEntry(testEvent("0/3", "cdc"), "kafka", 1, errors.New("boom")), func() {})
+	if !q.wait() {
+		t.Fatal("wait reported stopping")
+	}
+	if got := writer.lsns(); len(got) != 3 {
+		t.Errorf("written = %v, want [0/1 0/2 0/3]", got)
+	}
+}
@@ -0,0 +1,360 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/jackc/pglogrepl"
+)
+
+// ElasticsearchSink turns events into _bulk index/delete actions. Documents
+// are keyed by primary key values and versioned externally by LSN, so a
+// retried older write can never replace a newer one.
+type ElasticsearchSink struct {
+	client      *http.Client
+	config      *configs.SinkConfig
+	index       *template.Template
+	ack         AckFunc
+	deadLetters dlq.Writer
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+type bulkAction struct {
+	meta  []byte
+	doc   []byte
+	event events.ChangeEvent
+}
+
+// bulkFailure is an action Elasticsearch rejected for good.
+type bulkFailure struct {
+	action bulkAction
+	err    error
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkItemResponse `json:"items"`
+}
+
+type bulkItemResponse struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func NewElasticsearchSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*ElasticsearchSink, error) {
+	if cfg.Elasticsearch.FlushInterval <= 0 || cfg.Elasticsearch.BulkActions <= 0 {
+		return nil, fmt.Errorf("ES ERR: flush_interval and bulk_actions must be positive")
+	}
+	index, err := template.New("index").Option("missingkey=error").Parse(cfg.Elasticsearch.IndexTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("ES ERR: invalid index template: %w", err)
+	}
+
+	return &ElasticsearchSink{
+		client:      &http.Client{Timeout: cfg.Elasticsearch.RequestTimeout},
+		config:      cfg,
+		index:       index,
+		ack:         ack,
+		deadLetters: deadLetters,
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+func (e *ElasticsearchSink) Start(eventCh <-chan events.ChangeEvent) error {
+	e.wg.Go(func() {
+		ticker := time.NewTicker(e.config.Elasticsearch.FlushInterval)
+		defer ticker.Stop()
+
+		// last is acked once the batch is written. It runs ahead of the
+		// batch when a commit marker follows the last action.
+		var batch []bulkAction
+		var last string
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					e.flush(batch, last)
+					return
+				}
+				if event.IsCommit() {
+					if len(batch) == 0 {
+						e.ackLSN(event.Lsn)
+					} else {
+						last = event.Lsn
+					}
+					continue
+				}
+				actions, err := e.handleEvent(event)
+				if err != nil {
+					if !e.deadLetter(event, 1, err) {
+						return
+					}
+					if len(batch) == 0 {
+						e.ackLSN(event.Lsn)
+					} else {
+						last = event.Lsn
+					}
+					continue
+				}
+				batch = append(batch, actions...)
+				last = event.Lsn
+				if len(batch) >= e.config.Elasticsearch.BulkActions {
+					e.flush(batch, last)
+					batch = nil
+				}
+			case <-ticker.C:
+				e.flush(batch, last)
+				batch = nil
+			case <-e.stopChan:
+				e.flush(batch, last)
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (e *ElasticsearchSink) Stop() {
+	close(e.stopChan)
+	e.wg.Wait()
+}
+
+// Resend indexes a dead-lettered event once, for dlq replay.
+func (e *ElasticsearchSink) Resend(event events.ChangeEvent) error {
+	actions, err := e.handleEvent(event)
+	if err != nil {
+		return err
+	}
+	retry, failed, err := e.sendBulk(actions)
+	switch {
+	case err != nil:
+		return err
+	case len(failed) > 0:
+		return failed[0].err
+	case len(retry) > 0:
+		return fmt.Errorf("bulk action for event at %s was rejected, try again later", event.Lsn)
+	}
+	return nil
+}
+
+// handleEvent builds the bulk actions for an event. An update that changes
+// the primary key also deletes the document under the old key, like the
+// redis sink does, so the old row does not linger in the index.
+func (e *ElasticsearchSink) handleEvent(event events.ChangeEvent) ([]bulkAction, error) {
+	if len(event.PK) == 0 {
+		return nil, fmt.Errorf("table %s.%s has no primary key to use as document id", event.NameSpace, event.Table)
+	}
+
+	version, err := pglogrepl.ParseLSN(event.Lsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexName strings.Builder
+	if err := e.index.Execute(&indexName, event); err != nil {
+		return nil, err
+	}
+	if indexName.Len() == 0 {
+		return nil, fmt.Errorf("index template produced an empty index for %s.%s", event.NameSpace, event.Table)
+	}
+	index := strings.ToLower(indexName.String())
+	id := strings.Join(event.PKValues(), "|")
+
+	var actions []bulkAction
+	if event.Operation == events.OperationUpdate && event.Before != nil {
+		if oldID := strings.Join(event.PKValuesOf(event.Before), "|"); oldID != id {
+			meta, err := bulkMeta("delete", index, oldID, uint64(version))
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, bulkAction{meta: meta, event: event})
+		}
+	}
+
+	op := "index"
+	if event.Operation == events.OperationDelete {
+		op = "delete"
+	}
+	meta, err := bulkMeta(op, index, id, uint64(version))
+	if err != nil {
+		return nil, err
+	}
+	action := bulkAction{meta: meta, event: event}
+	if op == "index" {
+		action.doc, err = json.Marshal(event.After)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(actions, action), nil
+}
+
+func bulkMeta(op, index, id string, version uint64) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		op: map[string]any{
+			"_index":       index,
+			"_id":          id,
+			"version":      version,
+			"version_type": "external",
+		},
+	})
+}
+
+// flush sends a batch, retries whatever failed and then acks lsn. Version
+// conflicts mean a newer write already landed and deletes of missing
+// documents are no-ops, so both count as delivered. Actions Elasticsearch
+// rejects, or that still fail after max_retries, are dead-lettered first.
+func (e *ElasticsearchSink) flush(batch []bulkAction, lsn string) {
+	if len(batch) == 0 {
+		return
+	}
+
+	pending := batch
+	var failed []bulkFailure
+	attempts := 0
+	backoff := 100 * time.Millisecond
+	for len(pending) > 0 {
+		retry, rejected, err := e.sendBulk(pending)
+		if err != nil {
+			// the request itself failed, nothing in it was applied
+			fmt.Printf("ERROR: Bulk request failed: %v\n", err)
+		} else {
+			pending = retry
+			failed = append(failed, rejected...)
+			attempts++
+			if len(pending) > 0 && attempts > e.config.Elasticsearch.MaxRetries {
+				for _, a := range pending {
+					failed = append(failed, bulkFailure{action: a, err: fmt.Errorf("bulk action still failing after %d attempts", attempts)})
+				}
+				break
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		select {
+		case <-e.stopChan:
+			// left unacked, the slot replays it and versioning keeps that safe
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+
+	// both actions of a key change share an event, dead-letter it once
+	seen := make(map[string]bool)
+	for _, f := range failed {
+		if seen[f.action.event.Lsn] {
+			continue
+		}
+		seen[f.action.event.Lsn] = true
+		if !e.deadLetter(f.action.event, attempts, f.err) {
+			return
+		}
+	}
+	e.ackLSN(lsn)
+}
+
+// deadLetter parks an event that could not be indexed. Without a DLQ it is
+// dropped. It returns false only when stopping, leaving the event unacked.
+func (e *ElasticsearchSink) deadLetter(event events.ChangeEvent, attempts int, err error) bool {
+	fmt.Printf("ERROR: Failed to index event at %s: %v\n", event.Lsn, err)
+	if e.deadLetters == nil {
+		return true
+	}
+	return writeDeadLetter(e.deadLetters, dlq.NewEntry(event, e.name(), attempts, err), e.stopChan)
+}
+
+func (e *ElasticsearchSink) name() string {
+	if e.config.Name != "" {
+		return e.config.Name
+	}
+	return "elasticsearch"
+}
+
+func (e *ElasticsearchSink) ackLSN(lsn string) {
+	if e.ack != nil {
+		e.ack(lsn)
+	}
+}
+
+// sendBulk posts the actions and returns the ones that failed with a
+// retryable status and the ones that were rejected.
+func (e *ElasticsearchSink) sendBulk(actions []bulkAction) ([]bulkAction, []bulkFailure, error) {
+	var body bytes.Buffer
+	for _, a := range actions {
+		body.Write(a.meta)
+		body.WriteByte('\n')
+		if a.doc != nil {
+			body.Write(a.doc)
+			body.WriteByte('\n')
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(e.config.Elasticsearch.URL, "/")+"/_bulk", &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.config.Elasticsearch.Username != "" {
+		req.SetBasicAuth(e.config.Elasticsearch.Username, e.config.Elasticsearch.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("bulk returned %s: %s", resp.Status, data)
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, nil, fmt.Errorf("could not decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil, nil
+	}
+	if len(result.Items) != len(actions) {
+		return nil, nil, fmt.Errorf("bulk response has %d items for %d actions", len(result.Items), len(actions))
+	}
+
+	var retry []bulkAction
+	var failed []bulkFailure
+	for i, item := range result.Items {
+		for op, res := range item {
+			switch {
+			case res.Status < 300, res.Status == http.StatusConflict:
+			case op == "delete" && res.Status == http.StatusNotFound:
+			case res.Status == http.StatusTooManyRequests || res.Status >= 500:
+				retry = append(retry, actions[i])
+			default:
+				failed = append(failed, bulkFailure{
+					action: actions[i],
+					err:    fmt.Errorf("bulk %s of %s/%s failed (%d): %s", op, res.Index, res.ID, res.Status, res.Error),
+				})
+			}
+		}
+	}
+	return retry, failed, nil
+}
@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+// fakeBulk is a stand-in for the _bulk endpoint. Documents with a "status"
+// field are answered with that status, everything else is applied.
+type fakeBulk struct {
+	mu      sync.Mutex
+	docs    map[string]map[string]any
+	actions []string
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var items []map[string]bulkItemResponse
+	hasErrors := false
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var meta map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for op, m := range meta {
+			key := m.Index + "/" + m.ID
+			f.actions = append(f.actions, op+" "+key)
+			res := bulkItemResponse{Index: m.Index, ID: m.ID, Status: http.StatusOK}
+			if op == "delete" {
+				delete(f.docs, key)
+			} else {
+				scanner.Scan()
+				var doc map[string]any
+				json.Unmarshal(scanner.Bytes(), &doc)
+				if status, ok := doc["status"].(float64); ok {
+					res.Status = int(status)
+					res.Error = json.RawMessage(`{"type":"test"}`)
+					hasErrors = true
+				} else {
+					f.docs[key] = doc
+				}
+			}
+			items = append(items, map[string]bulkItemResponse{op: res})
+		}
+	}
+	json.NewEncoder(w).Encode(bulkResponse{Errors: hasErrors, Items: items})
+}
+
+func newTestElasticsearchSink(t *testing.T, bulk *fakeBulk, ack AckFunc, deadLetters *memoryDLQ) *ElasticsearchSink {
+	t.Helper()
+	srv := httptest.NewServer(bulk)
+	t.Cleanup(srv.Close)
+	cfg := &configs.SinkConfig{
+		Type: "elasticsearch",
+		Elasticsearch: configs.ElasticsearchConfig{
+			URL:            srv.URL,
+			IndexTemplate:  "{{.Table}}",
+			BulkActions:    100,
+			FlushInterval:  time.Hour,
+			RequestTimeout: 5 * time.Second,
+			MaxRetries:     1,
+		},
+	}
+	e, err := NewElasticsearchSink(cfg, ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestElasticsearchSinkMovesDocumentOnKeyChange(t *testing.T) {
+	bulk := &fakeBulk{docs: map[string]map[string]any{}}
+	acks := newAckRecorder(1)
+	e := newTestElasticsearchSink(t, bulk, acks.ack, &memoryDLQ{})
+
+	in := make(chan events.ChangeEvent)
+	if err := e.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- testEvent("0/10", "cdc")
+	update := testEvent("0/20", "cdc")
+	update.Operation = events.OperationUpdate
+	update.Before = map[string]any{"id": 1}
+	update.After = map[string]any{"id": 2}
+	in <- update
+	close(in)
+
+	if got := acks.wait(t); !slices.Equal(got, []string{"0/20"}) {
+		t.Errorf("acks = %v, want [0/20]", got)
+	}
+	e.Stop()
+
+	want := []string{"index users/1", "delete users/1", "index users/2"}
+	if !slices.Equal(bulk.actions, want) {
+		t.Errorf("actions = %v, want %v", bulk.actions, want)
+	}
+	if _, ok := bulk.docs["users/1"]; ok {
+		t.Error("document under the old key was left behind")
+	}
+}
+
+func TestElasticsearchSinkDeadLettersFailedItems(t *testing.T) {
+	bulk := &fakeBulk{docs: map[string]map[string]any{}}
+	acks := newAckRecorder(1)
+	deadLetters := &memoryDLQ{}
+	e := newTestElasticsearchSink(t, bulk, acks.ack, deadLetters)
+
+	in := make(chan events.ChangeEvent)
+	if err := e.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	ok := testEvent("0/10", "cdc")
+	rejected := testEvent("0/20", "cdc")
+	rejected.After = map[string]any{"id": 2, "status": http.StatusBadRequest}
+	throttled := testEvent("0/30", "cdc")
+	throttled.After = map[string]any{"id": 3, "status": http.StatusTooManyRequests}
+	noKey := testEvent("0/40", "cdc")
+	noKey.PK = nil
+	for _, event := range []events.ChangeEvent{ok, rejected, throttled, noKey} {
+		in <- event
+	}
+	in <- commitEvent("0/50")
+	close(in)
+
+	if got := acks.wait(t); !slices.Equal(got, []string{"0/50"}) {
+		t.Errorf("acks = %v, want [0/50]", got)
+	}
+	e.Stop()
+
+	got := deadLetters.lsns()
+	slices.Sort(got)
+	if want := []string{"0/20", "0/30", "0/40"}; !slices.Equal(got, want) {
+		t.Errorf("dead letters = %v, want %v", got, want)
+	}
+	for _, entry := range deadLetters.entries {
+		if entry.Sink != "elasticsearch" {
+			t.Errorf("entry at %s has sink %q", entry.Event.Lsn, entry.Sink)
+		}
+		if entry.Event.Lsn == "0/30" && !strings.Contains(entry.Error, "after 2 attempts") {
+			t.Errorf("throttled entry error = %q", entry.Error)
+		}
+	}
+	if _, ok := bulk.docs["users/1"]; !ok {
+		t.Error("delivered document is missing")
+	}
+}
+
+func TestNewElasticsearchSinkRejectsZeroFlushInterval(t *testing.T) {
+	cfg := &configs.SinkConfig{Type: "opensearch"}
+	if _, err := NewElasticsearchSink(cfg, nil, nil); err == nil {
+		t.Fatal("expected an error for a sink config without defaults")
+	}
+}
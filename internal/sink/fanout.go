@@ -0,0 +1,155 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/jackc/pglogrepl"
+)
+
+// FanoutSink feeds one pipeline output to several sinks, each through its
+// own buffer. The slot is acked up to the lowest LSN every required sink has
+// confirmed; optional sinks never hold it back.
+type FanoutSink struct {
+	targets  []*fanoutTarget
+	ack      AckFunc
+	mu       sync.Mutex
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+type fanoutTarget struct {
+	name     string
+	sink     Sink
+	ch       chan events.ChangeEvent
+	required bool
+	drop     bool
+	acked    pglogrepl.LSN
+	dropped  int
+}
+
+func NewFanoutSink(cfgs []configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*FanoutSink, error) {
+	f := &FanoutSink{
+		ack:      ack,
+		stopChan: make(chan struct{}),
+	}
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		t := &fanoutTarget{
+			name:     cfg.Name,
+			ch:       make(chan events.ChangeEvent, cfg.Buffer),
+			required: !cfg.Optional,
+			drop:     cfg.FailurePolicy == "drop",
+		}
+		s, err := New(cfg, f.ackFrom(t), deadLetters)
+		if err != nil {
+			f.close()
+			return nil, fmt.Errorf("SINK ERR: failed to create sink %s: %w", cfg.Name, err)
+		}
+		t.sink = s
+		f.targets = append(f.targets, t)
+	}
+	return f, nil
+}
+
+func (f *FanoutSink) Start(eventCh <-chan events.ChangeEvent) error {
+	for _, t := range f.targets {
+		if err := t.sink.Start(t.ch); err != nil {
+			return fmt.Errorf("SINK ERR: failed to start sink %s: %w", t.name, err)
+		}
+	}
+
+	f.wg.Go(func() {
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					for _, t := range f.targets {
+						close(t.ch)
+					}
+					return
+				}
+				for _, t := range f.targets {
+					if !f.deliver(t, event) {
+						return
+					}
+				}
+			case <-f.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (f *FanoutSink) Stop() {
+	close(f.stopChan)
+	f.wg.Wait()
+	for _, t := range f.targets {
+		t.sink.Stop()
+	}
+}
+
+// close releases the sinks created before one failed. None of them were
+// started, so they still own their connections.
+func (f *FanoutSink) close() {
+	for _, t := range f.targets {
+		if c, ok := t.sink.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+// deliver hands the event to one sink. It returns false only when stopping.
+func (f *FanoutSink) deliver(t *fanoutTarget, event events.ChangeEvent) bool {
+	if t.drop {
+		select {
+		case t.ch <- event:
+		default:
+			t.dropped++
+			if t.dropped == 1 || t.dropped%1000 == 0 {
+				fmt.Printf("WARN: Sink %s is full, %d events dropped so far\n", t.name, t.dropped)
+			}
+		}
+		return true
+	}
+
+	select {
+	case t.ch <- event:
+		return true
+	case <-f.stopChan:
+		return false
+	}
+}
+
+func (f *FanoutSink) ackFrom(t *fanoutTarget) AckFunc {
+	return func(lsn string) {
+		if !t.required {
+			return
+		}
+		parsed, err := pglogrepl.ParseLSN(lsn)
+		if err != nil {
+			fmt.Printf("ERROR: Sink %s acked unparseable LSN %q\n", t.name, lsn)
+			return
+		}
+
+		f.mu.Lock()
+		t.acked = max(t.acked, parsed)
+		var low pglogrepl.LSN
+		first := true
+		for _, other := range f.targets {
+			if other.required && (first || other.acked < low) {
+				low = other.acked
+				first = false
+			}
+		}
+		f.mu.Unlock()
+
+		if low > 0 && f.ack != nil {
+			f.ack(low.String())
+		}
+	}
+}
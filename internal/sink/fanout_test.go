@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+func TestFanoutSinkClosesCreatedSinksOnError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	// the second sink cannot listen on the address the first one holds
+	grpcSink := configs.SinkConfig{Type: "grpc", GRPC: configs.GRPCConfig{Addr: addr, ReplayBuffer: 16, ClientBuffer: 16}}
+	first, second := grpcSink, grpcSink
+	first.Name, second.Name = "feed", "mirror"
+	if _, err := NewFanoutSink([]configs.SinkConfig{first, second}, nil, nil); err == nil {
+		t.Fatal("expected the second sink to fail")
+	}
+
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("the first sink still holds %s: %v", addr, err)
+	}
+	lis.Close()
+}
+
+func TestFanoutSinkAcksLowestRequiredLSN(t *testing.T) {
+	acks := newAckRecorder(-1)
+	f := &FanoutSink{ack: acks.ack}
+	kafka := &fanoutTarget{name: "kafka", required: true}
+	search := &fanoutTarget{name: "search", required: true}
+	dashboard := &fanoutTarget{name: "dashboard"}
+	f.targets = []*fanoutTarget{kafka, search, dashboard}
+
+	// nothing moves while a required sink has acked nothing
+	f.ackFrom(kafka)("0/50")
+	// optional sinks are ignored, however far they get
+	f.ackFrom(dashboard)("0/90")
+	if got := acks.acked(); len(got) != 0 {
+		t.Fatalf("acked %v before every required sink acked", got)
+	}
+
+	// the lagging required sink holds the slot back
+	f.ackFrom(search)("0/20")
+	f.ackFrom(kafka)("0/60")
+	f.ackFrom(search)("0/40")
+	// a late, lower ack does not move anything backwards
+	f.ackFrom(search)("0/30")
+	f.ackFrom(search)("0/70")
+
+	want := []string{"0/20", "0/20", "0/40", "0/40", "0/60"}
+	if got := acks.acked(); !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/pkg/changefeed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCSink serves the cdc.ChangeFeed service from pkg/changefeed.
+// Subscribers that fall a full buffer behind are disconnected with
+// RESOURCE_EXHAUSTED and can resume from the last LSN they received while it
+// is still in the replay buffer; after that, resuming fails with
+// OUT_OF_RANGE. Events are only acked once they leave the replay buffer, so
+// after a restart the slot sends the buffered events again and subscribers
+// can resume across it.
+type GRPCSink struct {
+	changefeed.UnimplementedChangeFeedServer
+	server   *grpc.Server
+	listener net.Listener
+	hub      *hub
+	config   *configs.SinkConfig
+	ack      AckFunc
+	stopChan chan struct{}
+	// closing is closed once the sink stops serving, whether it was stopped
+	// or the pipeline closed its channel.
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// grpcPending is an LSN the sink has not acked yet, of an event in the
+// replay buffer or of a commit marker after one.
+type grpcPending struct {
+	lsn      string
+	buffered bool
+}
+
+func NewGRPCSink(cfg *configs.SinkConfig, ack AckFunc) (*GRPCSink, error) {
+	lis, err := net.Listen("tcp", cfg.GRPC.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("GRPC ERR: failed to listen on %s: %w", cfg.GRPC.Addr, err)
+	}
+
+	g := &GRPCSink{
+		server:   grpc.NewServer(),
+		listener: lis,
+		hub:      newHub(cfg.GRPC.ReplayBuffer),
+		config:   cfg,
+		ack:      ack,
+		stopChan: make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	changefeed.RegisterChangeFeedServer(g.server, g)
+	return g, nil
+}
+
+// Start serves subscribers and feeds them from the pipeline. There is no
+// durable consumer behind this sink, so the replay buffer stands in for one:
+// an event is acked once it is pushed out of the buffer, together with the
+// commit markers after it. Until then the slot keeps its WAL.
+func (g *GRPCSink) Start(eventCh <-chan events.ChangeEvent) error {
+	g.wg.Go(func() {
+		if err := g.server.Serve(g.listener); err != nil {
+			fmt.Printf("ERROR: gRPC server stopped: %v\n", err)
+		}
+	})
+	g.wg.Go(func() {
+		defer g.shutdown()
+		var unacked []grpcPending
+		buffered := 0
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				if !event.IsCommit() {
+					g.hub.publish(event)
+					buffered++
+				}
+				unacked = append(unacked, grpcPending{lsn: event.Lsn, buffered: !event.IsCommit()})
+
+				var lsn string
+				for len(unacked) > 0 && (!unacked[0].buffered || buffered > g.config.GRPC.ReplayBuffer) {
+					if unacked[0].buffered {
+						buffered--
+					}
+					lsn = unacked[0].lsn
+					unacked = unacked[1:]
+				}
+				if lsn != "" && g.ack != nil {
+					g.ack(lsn)
+				}
+			case <-g.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (g *GRPCSink) Stop() {
+	close(g.stopChan)
+	g.wg.Wait()
+}
+
+// Close frees the address of a server that was never started. Once started,
+// the listener belongs to the server and is closed with it.
+func (g *GRPCSink) Close() {
+	g.listener.Close()
+}
+
+func (g *GRPCSink) shutdown() {
+	close(g.closing)
+	g.hub.close()
+	g.server.GracefulStop()
+}
+
+// Subscribe implements changefeed.ChangeFeedServer.
+func (g *GRPCSink) Subscribe(req *changefeed.SubscribeRequest, stream grpc.ServerStreamingServer[changefeed.ChangeEvent]) error {
+	filter := eventFilter{Tables: req.Tables, Operations: req.Operations, Routes: req.Routes}
+	sub, replay, err := g.hub.subscribe(filter, req.FromLsn, g.config.GRPC.ClientBuffer, slowEvict)
+	if err != nil {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	defer g.hub.unsubscribe(sub)
+
+	cursor := req.FromLsn
+	for _, event := range replay {
+		if err := sendFeedEvent(stream, event); err != nil {
+			return err
+		}
+		cursor = event.Lsn
+	}
+
+	for {
+		select {
+		case event := <-sub.ch:
+			if err := sendFeedEvent(stream, event); err != nil {
+				return err
+			}
+			cursor = event.Lsn
+		case <-sub.evicted:
+			// what is still buffered is contiguous, send it before giving up
+			for len(sub.ch) > 0 {
+				event := <-sub.ch
+				if err := sendFeedEvent(stream, event); err != nil {
+					return err
+				}
+				cursor = event.Lsn
+			}
+			select {
+			case <-g.closing:
+				return status.Errorf(codes.Unavailable, "server shutting down, resume from %s", cursor)
+			default:
+				return status.Errorf(codes.ResourceExhausted, "subscriber fell behind, resume from %s", cursor)
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// sendFeedEvent sends one event. An event whose rows cannot be written as
+// JSON is logged and left out rather than ending the stream, since every
+// resume would run into it again.
+func sendFeedEvent(stream grpc.ServerStreamingServer[changefeed.ChangeEvent], event events.ChangeEvent) error {
+	msg, err := feedEvent(event)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to encode event at %s for the gRPC feed: %v\n", event.Lsn, err)
+		return nil
+	}
+	return stream.Send(msg)
+}
+
+func feedEvent(event events.ChangeEvent) (*changefeed.ChangeEvent, error) {
+	msg := &changefeed.ChangeEvent{
+		Schema:     event.NameSpace,
+		Table:      event.Table,
+		Lsn:        event.Lsn,
+		Route:      event.Route,
+		PrimaryKey: event.PK,
+		Xid:        event.Xid,
+	}
+	switch event.Operation {
+	case events.OperationInsert:
+		msg.Operation = changefeed.Operation_OPERATION_INSERT
+	case events.OperationUpdate:
+		msg.Operation = changefeed.Operation_OPERATION_UPDATE
+	case events.OperationDelete:
+		msg.Operation = changefeed.Operation_OPERATION_DELETE
+	}
+
+	var err error
+	if event.Before != nil {
+		if msg.Before, err = json.Marshal(event.Before); err != nil {
+			return nil, err
+		}
+	}
+	if event.After != nil {
+		if msg.After, err = json.Marshal(event.After); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
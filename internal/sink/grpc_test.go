@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/pkg/changefeed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func startGRPCSink(t *testing.T, replayBuffer int, ack AckFunc) (*GRPCSink, chan events.ChangeEvent, changefeed.ChangeFeedClient) {
+	t.Helper()
+	cfg := &configs.SinkConfig{
+		Type: "grpc",
+		GRPC: configs.GRPCConfig{Addr: "127.0.0.1:0", ReplayBuffer: replayBuffer, ClientBuffer: 16},
+	}
+	g, err := NewGRPCSink(cfg, ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent)
+	if err := g.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.NewClient(g.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return g, in, changefeed.NewChangeFeedClient(cc)
+}
+
+func subscribeStream(t *testing.T, ctx context.Context, client changefeed.ChangeFeedClient, req *changefeed.SubscribeRequest) grpc.ServerStreamingClient[changefeed.ChangeEvent] {
+	t.Helper()
+	stream, err := client.Subscribe(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func waitForSubscribers(t *testing.T, h *hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.Lock()
+		subs := len(h.subs)
+		h.mu.Unlock()
+		if subs >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d subscribers", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGRPCSinkResumeAfterRestart(t *testing.T) {
+	g, in, client := startGRPCSink(t, 16, nil)
+	defer g.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a fresh server has not seen what the client last received
+	stream := subscribeStream(t, ctx, client, &changefeed.SubscribeRequest{FromLsn: "0/10"})
+	if _, err := stream.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("resume on a fresh server: %v, want OUT_OF_RANGE", err)
+	}
+
+	in <- testEvent("0/20", "cdc")
+	in <- testEvent("0/30", "cdc")
+	stream = subscribeStream(t, ctx, client, &changefeed.SubscribeRequest{FromLsn: "0/20"})
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Lsn != "0/30" {
+		t.Errorf("resumed at %s, want 0/30", event.Lsn)
+	}
+	if event.Operation != changefeed.Operation_OPERATION_INSERT || event.Schema != "public" || event.Table != "users" {
+		t.Errorf("event = %v %s.%s, want an INSERT on public.users", event.Operation, event.Schema, event.Table)
+	}
+	if string(event.After) != `{"id":1}` || event.Before != nil {
+		t.Errorf("rows = %s / %s, want only after {\"id\":1}", event.Before, event.After)
+	}
+}
+
+func TestGRPCSinkAcksWhatLeavesTheReplayBuffer(t *testing.T) {
+	acks := newAckRecorder(2)
+	g, in, _ := startGRPCSink(t, 2, acks.ack)
+	defer g.Stop()
+
+	in <- testEvent("0/10", "cdc")
+	in <- commitEvent("0/20")
+	in <- testEvent("0/30", "cdc")
+	in <- commitEvent("0/40")
+	if got := acks.acked(); len(got) != 0 {
+		t.Errorf("acked %v while both events are buffered", got)
+	}
+	// 0/10 is pushed out, and the commit right after it goes with it
+	in <- testEvent("0/50", "cdc")
+	in <- testEvent("0/60", "cdc")
+	got := acks.wait(t)
+
+	if want := []string{"0/20", "0/40"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+}
+
+func TestGRPCSinkClosedChannelIsUnavailable(t *testing.T) {
+	g, in, client := startGRPCSink(t, 16, nil)
+	defer g.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream := subscribeStream(t, ctx, client, &changefeed.SubscribeRequest{})
+	waitForSubscribers(t, g.hub, 1)
+	in <- testEvent("0/10", "cdc")
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	close(in)
+	_, err := stream.Recv()
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("after the pipeline closed: %v, want UNAVAILABLE", err)
+	}
+}
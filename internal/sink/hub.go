@@ -0,0 +1,180 @@
+package sink
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/jackc/pglogrepl"
+)
+
+// hub fans events out to live subscribers and keeps a bounded history so a
+// subscriber can resume from an LSN it has already seen.
+type hub struct {
+	mu          sync.Mutex
+	subs        map[*subscriber]struct{}
+	history     []hubEvent
+	historySize int
+	// floor is the LSN of the newest event that is no longer in history,
+	// or of the first event when nothing was dropped yet. Resuming from
+	// anything older would leave a gap. Until the first event it is unknown:
+	// the events before it were acked by an earlier run and are gone.
+	floor     pglogrepl.LSN
+	published bool
+}
+
+type hubEvent struct {
+	event events.ChangeEvent
+	lsn   pglogrepl.LSN
+}
+
+type slowPolicy int
+
+const (
+	// slowEvict disconnects a subscriber whose buffer is full.
+	slowEvict slowPolicy = iota
+	// slowSkip drops events for a subscriber while its buffer is full.
+	slowSkip
+)
+
+type subscriber struct {
+	filter  eventFilter
+	policy  slowPolicy
+	ch      chan events.ChangeEvent
+	evicted chan struct{}
+	skipped int
+}
+
+type eventFilter struct {
+	Tables     []string
+	Operations []string
+	Routes     []string
+}
+
+func newHub(historySize int) *hub {
+	return &hub{
+		subs:        make(map[*subscriber]struct{}),
+		historySize: historySize,
+	}
+}
+
+// matches accepts tables as either "table" or "schema.table". Empty lists
+// match everything.
+func (f eventFilter) matches(event events.ChangeEvent) bool {
+	if len(f.Tables) > 0 &&
+		!slices.Contains(f.Tables, event.Table) &&
+		!slices.Contains(f.Tables, event.NameSpace+"."+event.Table) {
+		return false
+	}
+	if len(f.Operations) > 0 && !slices.ContainsFunc(f.Operations, func(op string) bool {
+		return strings.EqualFold(op, event.Operation.ToString())
+	}) {
+		return false
+	}
+	if len(f.Routes) > 0 && !slices.Contains(f.Routes, event.Route) {
+		return false
+	}
+	return true
+}
+
+func (h *hub) publish(event events.ChangeEvent) {
+	lsn, _ := pglogrepl.ParseLSN(event.Lsn)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.published {
+		h.floor, h.published = lsn, true
+	}
+	if h.historySize > 0 {
+		if len(h.history) >= h.historySize {
+			h.floor = h.history[0].lsn
+			h.history = h.history[1:]
+		}
+		h.history = append(h.history, hubEvent{event: event, lsn: lsn})
+	} else {
+		h.floor = lsn
+	}
+
+	for sub := range h.subs {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			if sub.policy == slowSkip {
+				sub.skipped++
+				continue
+			}
+			delete(h.subs, sub)
+			close(sub.evicted)
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the retained events after
+// fromLSN that it has to be sent before anything on its channel. Both are
+// taken under the same lock so nothing falls between them. It fails when
+// events after fromLSN may have been dropped, since history is only kept in
+// memory and those events were already acked.
+func (h *hub) subscribe(filter eventFilter, fromLSN string, buffer int, policy slowPolicy) (*subscriber, []events.ChangeEvent, error) {
+	sub := &subscriber{
+		filter:  filter,
+		policy:  policy,
+		ch:      make(chan events.ChangeEvent, buffer),
+		evicted: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []events.ChangeEvent
+	if fromLSN != "" {
+		from, err := pglogrepl.ParseLSN(fromLSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid from_lsn %q: %w", fromLSN, err)
+		}
+		if !h.published {
+			return nil, nil, fmt.Errorf("LSN %s is not retained, nothing was received since the server started", fromLSN)
+		}
+		if from < h.floor {
+			return nil, nil, fmt.Errorf("LSN %s is no longer retained, the oldest resumable LSN is %s", fromLSN, h.floor)
+		}
+		for _, he := range h.history {
+			if he.lsn > from && filter.matches(he.event) {
+				replay = append(replay, he.event)
+			}
+		}
+	}
+
+	h.subs[sub] = struct{}{}
+	return sub, replay, nil
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// takeSkipped returns and resets how many events the subscriber missed.
+func (h *hub) takeSkipped(sub *subscriber) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := sub.skipped
+	sub.skipped = 0
+	return n
+}
+
+// close evicts every subscriber, used when the sink shuts down.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.evicted)
+	}
+}
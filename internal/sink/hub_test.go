@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"testing"
+)
+
+func TestHubResume(t *testing.T) {
+	tests := []struct {
+		name      string
+		history   int
+		published []string
+		from      string
+		want      []string
+		err       bool
+	}{
+		{name: "live only", history: 4, published: nil, from: "", want: nil},
+		{name: "nothing since start", history: 4, published: nil, from: "0/10", err: true},
+		{name: "before the first event after a restart", history: 4, published: []string{"0/20", "0/30"}, from: "0/10", err: true},
+		{name: "from the first event", history: 4, published: []string{"0/20", "0/30"}, from: "0/20", want: []string{"0/30"}},
+		{name: "within history", history: 2, published: []string{"0/10", "0/20", "0/30"}, from: "0/20", want: []string{"0/30"}},
+		{name: "at the trimmed event", history: 2, published: []string{"0/10", "0/20", "0/30"}, from: "0/10", want: []string{"0/20", "0/30"}},
+		{name: "trimmed away", history: 2, published: []string{"0/10", "0/20", "0/30", "0/40"}, from: "0/10", err: true},
+		{name: "no history, caught up", history: 0, published: []string{"0/10", "0/20"}, from: "0/20", want: nil},
+		{name: "no history, behind", history: 0, published: []string{"0/10", "0/20"}, from: "0/10", err: true},
+		{name: "invalid lsn", history: 4, published: []string{"0/10"}, from: "nope", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub(tt.history)
+			for _, lsn := range tt.published {
+				h.publish(testEvent(lsn, "cdc"))
+			}
+			_, replay, err := h.subscribe(eventFilter{}, tt.from, 1, slowEvict)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, event := range replay {
+				got = append(got, event.Lsn)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replay = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("replay = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,63 +1,136 @@
 package sink
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
+	"github.com/MathewBravo/cdc-pipeline/internal/blob"
 	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
 	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/internal/metrics"
+	"github.com/MathewBravo/cdc-pipeline/pkg/claimcheck"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 // kgo.SeedBrokers("10.255.255.254:9092 WSL WINDOWS IP")
 type KafkaSink struct {
-	client   *kgo.Client
-	config   *configs.SinkConfig
-	stopChan chan struct{}
-	wg       sync.WaitGroup
+	client      *kgo.Client
+	config      *configs.SinkConfig
+	acks        *lsnTracker
+	deadLetters *deadLetterQueue
+	claims      blob.Store
+	keys        map[string]*template.Template
+	topics      *topicManager
+	breaker     *circuitBreaker
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
 }
 
-func NewKafkaSink(cfg *configs.SinkConfig) (*KafkaSink, error) {
+func NewKafkaSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*KafkaSink, error) {
 	cmp := getCompression(cfg.Compression)
 	batch := cfg.BatchSize * 1024
 
-	cl, err := kgo.NewClient(
+	opts := []kgo.Opt{
 		kgo.SeedBrokers(cfg.Brokers...),
 		kgo.ProducerBatchCompression(cmp),
 		kgo.ProducerBatchMaxBytes(int32(batch)),
 		kgo.ProducerLinger(cfg.FlushInterval),
-	)
+		kgo.RecordPartitioner(getPartitioner(cfg.Partitioner)),
+		kgo.RetryBackoffFn(retryBackoff(cfg.Retry)),
+	}
+	opts = append(opts, producerOpts(&cfg.Producer)...)
+
+	cl, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := newTopicManager(cl, cfg)
 	if err != nil {
+		cl.Close()
 		return nil, err
 	}
+	keys, err := parsePartitionKeys(cfg.PartitionKeys)
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	var claims blob.Store
+	if cfg.ClaimCheck.Threshold > 0 {
+		if claims, err = blob.New(&cfg.ClaimCheck.Store); err != nil {
+			cl.Close()
+			return nil, err
+		}
+	}
 
-	return &KafkaSink{
+	k := &KafkaSink{
 		client:   cl,
 		config:   cfg,
+		acks:     newLSNTracker(ack),
+		claims:   claims,
+		keys:     keys,
+		topics:   topics,
 		stopChan: make(chan struct{}),
-	}, nil
+	}
+	if deadLetters != nil {
+		k.deadLetters = newDeadLetterQueue(deadLetters, k.stopChan)
+	}
+	k.breaker = newCircuitBreaker(k.name(), cfg.CircuitBreaker.FailureThreshold, cfg.CircuitBreaker.OpenTimeout)
+	return k, nil
 }
 
+// Start produces events until the channel closes. While the circuit breaker
+// is open or dead letters are waiting to be written it stops reading, which
+// backs up the pipeline and in turn pauses WAL reading in the connector.
 func (k *KafkaSink) Start(eventCh <-chan events.ChangeEvent) error {
+	if k.deadLetters != nil {
+		k.wg.Go(k.deadLetters.run)
+	}
 	k.wg.Go(func() {
 		for {
+			if !k.breaker.wait(k.stopChan) || (k.deadLetters != nil && !k.deadLetters.wait()) {
+				k.Close()
+				return
+			}
 			select {
 			case event, ok := <-eventCh:
 				if !ok {
-					k.client.Close()
+					k.Close()
+					if k.deadLetters != nil {
+						k.deadLetters.wait()
+					}
 					return
 				}
-				record, err := k.handleEvent(event)
+				if event.IsCommit() {
+					k.acks.done(k.acks.track(event.Lsn))
+					continue
+				}
+				records, err := k.handleEvent(event)
+				if transientKafkaError(err) {
+					records, err = k.retryTransient(event, err)
+					if errors.Is(err, errStopping) {
+						k.Close()
+						return
+					}
+				}
 				if err != nil {
-					fmt.Printf("ERROR: Failed to handle event: %v\n", err)
+					k.breaker.skip()
+					k.deadLetter(event, k.acks.track(event.Lsn), 1, err)
 					continue
 				}
-				k.produceRecord(record)
+				for _, record := range records {
+					k.produceRecord(event, record, k.acks.track(event.Lsn))
+				}
 			case <-k.stopChan:
-				k.client.Close()
+				k.Close()
 				return
 			}
 		}
@@ -70,29 +143,228 @@ func (k *KafkaSink) Stop() {
 	k.wg.Wait()
 }
 
-func (k *KafkaSink) handleEvent(event events.ChangeEvent) (*kgo.Record, error) {
-	jsonEvent, err := json.Marshal(event)
+// Close flushes buffered records and releases the client. Start calls it on
+// the way out; it is only needed directly when the sink was never started.
+func (k *KafkaSink) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := k.client.Flush(ctx); err != nil {
+		fmt.Printf("ERROR: Could not flush buffered records: %v\n", err)
+	}
+	k.client.Close()
+}
+
+// Resend produces one event synchronously, used to replay dead letters.
+func (k *KafkaSink) Resend(event events.ChangeEvent) error {
+	records, err := k.handleEvent(event)
 	if err != nil {
+		return err
+	}
+	return k.client.ProduceSync(context.Background(), records...).FirstErr()
+}
+
+// handleEvent builds the records for one event. Deletes can be followed by,
+// or replaced with, a tombstone so that compacted topics drop the row.
+// Records without a key have nothing to compact on and never get tombstones.
+func (k *KafkaSink) handleEvent(event events.ChangeEvent) ([]*kgo.Record, error) {
+	if err := k.topics.forEvent(event); err != nil {
 		return nil, err
 	}
+	key, err := k.recordKey(event)
+	if err != nil {
+		return nil, err
+	}
+	tombstones := k.config.Tombstones
+	if event.Operation != events.OperationDelete || key == nil {
+		tombstones = "none"
+	}
+	tombstone := &kgo.Record{Topic: event.Route, Key: key}
 
-	pk := strings.Join(event.PK, "|")
+	if tombstones == "only" {
+		return []*kgo.Record{tombstone}, nil
+	}
 
-	return &kgo.Record{
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	record := &kgo.Record{
 		Topic: event.Route,
-		Key:   []byte(pk),
+		Key:   key,
 		Value: jsonEvent,
-	}, nil
+	}
+	if k.claims != nil && len(jsonEvent) > k.config.ClaimCheck.Threshold {
+		if err := k.claimCheck(event, record); err != nil {
+			return nil, err
+		}
+	}
+	records := []*kgo.Record{record}
+	if tombstones == "append" {
+		records = append(records, tombstone)
+	}
+	return records, nil
 }
 
-func (k *KafkaSink) produceRecord(record *kgo.Record) {
-	k.client.Produce(context.Background(), record, func(r *kgo.Record, err error) {
-		if err != nil {
-			fmt.Printf("ERROR: Produce failed: %v\n", err)
+// recordKey is the primary key values joined by "|", or the table's
+// partition key template when it has one. Tables without a primary key get a
+// null key, which the murmur2 partitioner spreads like the Java client does.
+func (k *KafkaSink) recordKey(event events.ChangeEvent) ([]byte, error) {
+	if entry, ok := k.config.PartitionKey(event.NameSpace, event.Table); ok {
+		tmpl := k.keys[entry]
+		var key bytes.Buffer
+		if err := tmpl.Execute(&key, &event); err != nil {
+			return nil, fmt.Errorf("partition key for %s.%s: %w", event.NameSpace, event.Table, err)
 		}
+		return key.Bytes(), nil
+	}
+
+	if len(event.PK) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(event.PKValues(), "|")), nil
+}
+
+// errClaimCheck marks events whose payload could not be stored.
+var errClaimCheck = errors.New("could not store claim check")
+
+// claimCheck stores the record's value in the blob store and replaces it
+// with a reference. Objects are named by their checksum, so storing the same
+// event again on a retry or replay overwrites rather than duplicates it.
+func (k *KafkaSink) claimCheck(event events.ChangeEvent, record *kgo.Record) error {
+	ref := claimcheck.New(record.Value)
+	ref.Operation = int(event.Operation)
+	ref.NameSpace, ref.Table = event.NameSpace, event.Table
+	ref.Lsn, ref.Route = event.Lsn, event.Route
+	ref.Key = path.Join(k.config.ClaimCheck.Store.Prefix, event.NameSpace+"."+event.Table, ref.SHA256+".json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := k.claims.Put(ctx, ref.Key, record.Value); err != nil {
+		return fmt.Errorf("%w for event at %s: %w", errClaimCheck, event.Lsn, err)
+	}
+
+	value, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	record.Value = value
+	record.Headers = append(record.Headers, kgo.RecordHeader{Key: claimcheck.Header, Value: []byte("1")})
+	return nil
+}
+
+// transientKafkaError reports whether building an event's records failed on
+// something other than the event: a blob store or topic admin request that
+// did not go through.
+func transientKafkaError(err error) bool {
+	return errors.Is(err, errClaimCheck) || errors.Is(err, errTopicAdmin)
+}
+
+// retryTransient waits for the blob store or the brokers instead of
+// dead-lettering. Neither says anything about the event. Without a DLQ a
+// dead letter would drop it, and a claim-checked event is as oversized for a
+// Kafka DLQ as it was for its topic.
+func (k *KafkaSink) retryTransient(event events.ChangeEvent, err error) ([]*kgo.Record, error) {
+	backoff := 100 * time.Millisecond
+	for {
+		fmt.Printf("ERROR: %v, retrying\n", err)
+		select {
+		case <-k.stopChan:
+			return nil, errStopping
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+
+		var records []*kgo.Record
+		records, err = k.handleEvent(event)
+		if !transientKafkaError(err) {
+			return records, err
+		}
+	}
+}
+
+// produceRecord hands a record to the client. Failed produce requests are
+// retried inside the client, which keeps each partition in order; a record
+// that still fails has used up producer.retries or producer.delivery_timeout
+// and is dead-lettered.
+func (k *KafkaSink) produceRecord(event events.ChangeEvent, record *kgo.Record, seq uint64) {
+	k.client.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
+		if err == nil {
+			k.breaker.success()
+			metrics.SinkDelivered.Add(k.name(), 1)
+			k.acks.done(seq)
+			return
+		}
+		k.breaker.failure()
+		metrics.SinkFailures.Add(k.name(), 1)
+		k.deadLetter(event, seq, 1, err)
 	})
 }
 
+// deadLetter parks an event that could not be delivered. Without a DLQ it is
+// dropped. Otherwise it is queued for the dead-letter goroutine and its LSN
+// is acked once the entry is written.
+func (k *KafkaSink) deadLetter(event events.ChangeEvent, seq uint64, attempts int, err error) {
+	fmt.Printf("ERROR: Failed to deliver event at %s: %v\n", event.Lsn, err)
+	if k.deadLetters == nil {
+		k.acks.done(seq)
+		return
+	}
+	k.deadLetters.add(dlq.NewEntry(event, k.name(), attempts, err), func() { k.acks.done(seq) })
+}
+
+func (k *KafkaSink) name() string {
+	if k.config.Name != "" {
+		return k.config.Name
+	}
+	return "kafka"
+}
+
+func producerOpts(cfg *configs.ProducerConfig) []kgo.Opt {
+	var opts []kgo.Opt
+	switch cfg.Acks {
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if cfg.Idempotent != nil && !*cfg.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+	if cfg.MaxInFlight > 0 {
+		opts = append(opts, kgo.MaxProduceRequestsInflightPerBroker(cfg.MaxInFlight))
+	}
+	if cfg.RequestTimeout > 0 {
+		opts = append(opts, kgo.ProduceRequestTimeout(cfg.RequestTimeout))
+	}
+	if cfg.DeliveryTimeout > 0 {
+		opts = append(opts, kgo.RecordDeliveryTimeout(cfg.DeliveryTimeout))
+	}
+	if cfg.Retries != nil {
+		opts = append(opts, kgo.RecordRetries(*cfg.Retries))
+	}
+	if cfg.MaxBufferedRecords > 0 {
+		opts = append(opts, kgo.MaxBufferedRecords(cfg.MaxBufferedRecords))
+	}
+	if cfg.ClientID != "" {
+		opts = append(opts, kgo.ClientID(cfg.ClientID))
+	}
+	return opts
+}
+
+func parsePartitionKeys(exprs map[string]string) (map[string]*template.Template, error) {
+	keys := make(map[string]*template.Template, len(exprs))
+	for table, expr := range exprs {
+		tmpl, err := template.New(table).Option("missingkey=error").Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("KAFKA ERR: invalid partition key for %s: %w", table, err)
+		}
+		keys[table] = tmpl
+	}
+	return keys, nil
+}
+
 func getCompression(compression string) kgo.CompressionCodec {
 	switch compression {
 	case "gzip":
@@ -0,0 +1,335 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/MathewBravo/cdc-pipeline/pkg/claimcheck"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// flakyStore fails its first failures puts.
+type flakyStore struct {
+	mu       sync.Mutex
+	failures int
+	objects  map[string][]byte
+}
+
+func (s *flakyStore) Put(_ context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store is down")
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *flakyStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key], nil
+}
+
+func newTestKafkaSink(t *testing.T, cluster *kfake.Cluster, ack AckFunc) *KafkaSink {
+	t.Helper()
+	cfg := &configs.SinkConfig{
+		Type:          "kafka",
+		Brokers:       cluster.ListenAddrs(),
+		BatchSize:     1024,
+		FlushInterval: time.Millisecond,
+		Retry:         configs.RetryConfig{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, Multiplier: 2},
+		CircuitBreaker: configs.CircuitBreakerConfig{
+			FailureThreshold: 100,
+			OpenTimeout:      time.Second,
+		},
+	}
+	k, err := NewKafkaSink(cfg, ack, &memoryDLQ{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKafkaSinkRetriesClaimCheckStore(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	acks := newAckRecorder(-1)
+	k := newTestKafkaSink(t, cluster, acks.ack)
+	deadLetters := k.deadLetters.writer.(*memoryDLQ)
+	store := &flakyStore{failures: 2, objects: map[string][]byte{}}
+	k.claims = store
+	k.config.ClaimCheck.Threshold = 100
+
+	in := make(chan events.ChangeEvent)
+	if err := k.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent("0/10", "cdc")
+	event.After["body"] = strings.Repeat("x", 1000)
+	in <- event
+	in <- commitEvent("0/20")
+
+	// acks of a run of delivered LSNs coalesce into the last one
+	acks.waitFor(t, "0/20")
+	close(in)
+	k.Stop()
+
+	if lsns := deadLetters.lsns(); len(lsns) > 0 {
+		t.Errorf("dead letters = %v, want none", lsns)
+	}
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics("cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fetches := cl.PollRecords(ctx, 1)
+	if err := fetches.Err(); err != nil {
+		t.Fatal(err)
+	}
+	record := fetches.Records()[0]
+	var resolved events.ChangeEvent
+	err = claimcheck.Resolve(record.Value, func(key string) ([]byte, error) {
+		return store.Get(ctx, key)
+	}, &resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Lsn != "0/10" || resolved.After["body"] != event.After["body"] {
+		t.Errorf("resolved event at %s with %d byte body", resolved.Lsn, len(resolved.After["body"].(string)))
+	}
+}
+
+// failProduce answers every produce request with err for each partition.
+func failProduce(cluster *kfake.Cluster, err *kerr.Error) {
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic = topic.Topic
+			rt.TopicID = topic.TopicID
+			for _, partition := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = partition.Partition
+				rp.ErrorCode = err.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+}
+
+func TestKafkaSinkDeliveryFailuresOpenBreaker(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	// a retryable error, so only the delivery timeout fails the records; the
+	// client waits out its metadata refresh interval first, about 5s
+	failProduce(cluster, kerr.NotEnoughReplicas)
+
+	acks := newAckRecorder(2)
+	cfg := &configs.SinkConfig{
+		Type:          "kafka",
+		Brokers:       cluster.ListenAddrs(),
+		BatchSize:     1024,
+		FlushInterval: time.Millisecond,
+		Producer:      configs.ProducerConfig{DeliveryTimeout: time.Second},
+		Retry:         configs.RetryConfig{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 2},
+		CircuitBreaker: configs.CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      time.Hour,
+		},
+	}
+	deadLetters := &memoryDLQ{}
+	k, err := NewKafkaSink(cfg, acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent)
+	if err := k.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- testEvent("0/10", "cdc")
+	in <- testEvent("0/20", "cdc")
+
+	// dead-lettered records are acked
+	acks.wait(t)
+	if k.breaker.current() != breakerOpen {
+		t.Errorf("breaker is %s after the records timed out, want open", k.breaker.current())
+	}
+	if got := deadLetters.lsns(); len(got) != 2 {
+		t.Errorf("dead letters = %v, want 0/10 and 0/20", got)
+	}
+	k.Stop()
+}
+
+func TestKafkaSinkPausesWhileBreakerIsOpen(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	acks := newAckRecorder(2)
+	k := newTestKafkaSink(t, cluster, acks.ack)
+	k.breaker = newCircuitBreaker(k.name(), 1, 300*time.Millisecond)
+	k.breaker.failure()
+
+	in := make(chan events.ChangeEvent)
+	if err := k.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case in <- testEvent("0/10", "cdc"):
+		t.Fatal("sink took an event while its breaker was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// after the open timeout the event goes through as the probe
+	in <- testEvent("0/10", "cdc")
+	in <- testEvent("0/20", "cdc")
+	acks.wait(t)
+	close(in)
+	k.Stop()
+	if k.breaker.current() != breakerClosed {
+		t.Errorf("breaker is %s after the probe was delivered, want closed", k.breaker.current())
+	}
+}
+
+func deleteEvent(lsn string, pk []string) events.ChangeEvent {
+	event := testEvent(lsn, "cdc")
+	event.Operation = events.OperationDelete
+	event.Before, event.After = map[string]any{"id": 7}, nil
+	event.PK = pk
+	return event
+}
+
+func TestKafkaSinkTombstones(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	k := newTestKafkaSink(t, cluster, nil)
+	defer k.Close()
+
+	// nil values are tombstones
+	tests := []struct {
+		name       string
+		tombstones string
+		event      events.ChangeEvent
+		keys       []string
+		values     []bool
+	}{
+		{"none", "none", deleteEvent("0/10", []string{"id"}), []string{"7"}, []bool{true}},
+		{"append", "append", deleteEvent("0/10", []string{"id"}), []string{"7", "7"}, []bool{true, false}},
+		{"only", "only", deleteEvent("0/10", []string{"id"}), []string{"7"}, []bool{false}},
+		{"insert", "append", testEvent("0/10", "cdc"), []string{"1"}, []bool{true}},
+		// nothing to compact on, so no tombstone even when asked for one
+		{"no primary key", "append", deleteEvent("0/10", nil), []string{""}, []bool{true}},
+		{"no primary key only", "only", deleteEvent("0/10", nil), []string{""}, []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k.config.Tombstones = tt.tombstones
+			records, err := k.handleEvent(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.keys) {
+				t.Fatalf("got %d records, want %d", len(records), len(tt.keys))
+			}
+			for i, record := range records {
+				if tt.keys[i] == "" && record.Key != nil {
+					t.Errorf("record %d has key %q, want a null key", i, record.Key)
+				} else if string(record.Key) != tt.keys[i] {
+					t.Errorf("record %d has key %q, want %q", i, record.Key, tt.keys[i])
+				}
+				if (record.Value != nil) != tt.values[i] {
+					t.Errorf("record %d has value %q, want value: %v", i, record.Value, tt.values[i])
+				}
+				if record.Topic != "cdc" {
+					t.Errorf("record %d went to %s, want cdc", i, record.Topic)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaSinkProducesDeleteAndTombstone(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	acks := newAckRecorder(-1)
+	k := newTestKafkaSink(t, cluster, acks.ack)
+	k.config.Tombstones = "append"
+	in := make(chan events.ChangeEvent)
+	if err := k.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- deleteEvent("0/10", []string{"id"})
+	in <- deleteEvent("0/20", nil)
+	in <- commitEvent("0/30")
+	acks.waitFor(t, "0/30")
+	close(in)
+	k.Stop()
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics("cdc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < 3 {
+		fetches := cl.PollFetches(ctx)
+		if err := fetches.Err(); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, fetches.Records()...)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+
+	var deleted events.ChangeEvent
+	if err := json.Unmarshal(records[0].Value, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if string(records[0].Key) != "7" || deleted.Operation != events.OperationDelete || deleted.Lsn != "0/10" {
+		t.Errorf("first record is operation %d at %s under key %q, want the delete at 0/10 under 7", deleted.Operation, deleted.Lsn, records[0].Key)
+	}
+	if string(records[1].Key) != "7" || records[1].Value != nil {
+		t.Errorf("second record has key %q and value %q, want a tombstone under 7", records[1].Key, records[1].Value)
+	}
+	if records[2].Key != nil || records[2].Value == nil {
+		t.Errorf("third record has key %q and value %q, want the keyless delete", records[2].Key, records[2].Value)
+	}
+}
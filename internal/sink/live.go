@@ -0,0 +1,293 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/coder/websocket"
+)
+
+// LiveSink streams events to browsers over Server-Sent Events (/events) and
+// WebSocket (/ws). Clients filter with repeated or comma separated table, op
+// and route query parameters. A client whose buffer fills is either dropped
+// or, with slow_client: sample, skips events until it catches up.
+type LiveSink struct {
+	server   *http.Server
+	listener net.Listener
+	hub      *hub
+	config   *configs.SinkConfig
+	ack      AckFunc
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+type liveMessage struct {
+	Type  string              `json:"type"`
+	Event *events.ChangeEvent `json:"event,omitempty"`
+	Count int                 `json:"count,omitempty"`
+}
+
+const liveHeartbeat = 15 * time.Second
+
+func NewLiveSink(cfg *configs.SinkConfig, ack AckFunc) (*LiveSink, error) {
+	lis, err := net.Listen("tcp", cfg.Live.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("LIVE ERR: failed to listen on %s: %w", cfg.Live.Addr, err)
+	}
+
+	l := &LiveSink{
+		listener: lis,
+		hub:      newHub(cfg.Live.ReplayBuffer),
+		config:   cfg,
+		ack:      ack,
+		stopChan: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", l.serveSSE)
+	mux.HandleFunc("GET /ws", l.serveWebSocket)
+	l.server = &http.Server{Handler: mux}
+	return l, nil
+}
+
+// Start serves clients and feeds them from the pipeline. Like the gRPC
+// server, events are acked once they are handed to the hub.
+func (l *LiveSink) Start(eventCh <-chan events.ChangeEvent) error {
+	l.wg.Go(func() {
+		if err := l.server.Serve(l.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("ERROR: Live feed server stopped: %v\n", err)
+		}
+	})
+	l.wg.Go(func() {
+		defer l.shutdown()
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				if !event.IsCommit() {
+					l.hub.publish(event)
+				}
+				if l.ack != nil {
+					l.ack(event.Lsn)
+				}
+			case <-l.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (l *LiveSink) Stop() {
+	close(l.stopChan)
+	l.wg.Wait()
+}
+
+// Close frees the address of a server that was never started. Once started,
+// the listener belongs to the server and is closed with it.
+func (l *LiveSink) Close() {
+	l.listener.Close()
+}
+
+func (l *LiveSink) shutdown() {
+	l.hub.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l.server.Shutdown(ctx)
+}
+
+func (l *LiveSink) slowPolicy() slowPolicy {
+	if l.config.Live.SlowClient == "sample" {
+		return slowSkip
+	}
+	return slowEvict
+}
+
+// serveSSE honours Last-Event-ID, so the browser's automatic reconnect picks
+// up where it left off while the events are still in the replay buffer.
+// Cross-origin requests are held to allowed_origins, as WebSocket upgrades
+// are.
+func (l *LiveSink) serveSSE(w http.ResponseWriter, r *http.Request) {
+	if err := checkOrigin(r, l.config.Live.AllowedOrigins); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+
+	sub, replay, err := l.hub.subscribe(filterFromQuery(r.URL.Query()), r.Header.Get("Last-Event-ID"), l.config.Live.ClientBuffer, l.slowPolicy())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer l.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	rc := http.NewResponseController(w)
+
+	send := func(event events.ChangeEvent) error {
+		if skipped := l.hub.takeSkipped(sub); skipped > 0 {
+			fmt.Fprintf(w, "event: skipped\ndata: {\"count\":%d}\n\n", skipped)
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(l.config.Live.WriteTimeout))
+		fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", event.Lsn, data)
+		return rc.Flush()
+	}
+
+	for _, event := range replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-sub.ch:
+			if err := send(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-sub.evicted:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (l *LiveSink) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sub, replay, err := l.hub.subscribe(filterFromQuery(query), query.Get("from_lsn"), l.config.Live.ClientBuffer, l.slowPolicy())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer l.hub.unsubscribe(sub)
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: l.config.Live.AllowedOrigins})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	ctx := conn.CloseRead(r.Context())
+
+	write := func(msg liveMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		wctx, cancel := context.WithTimeout(ctx, l.config.Live.WriteTimeout)
+		defer cancel()
+		return conn.Write(wctx, websocket.MessageText, data)
+	}
+	send := func(event events.ChangeEvent) error {
+		if skipped := l.hub.takeSkipped(sub); skipped > 0 {
+			if err := write(liveMessage{Type: "skipped", Count: skipped}); err != nil {
+				return err
+			}
+		}
+		return write(liveMessage{Type: "change", Event: &event})
+	}
+
+	for _, event := range replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event := <-sub.ch:
+			if err := send(event); err != nil {
+				return
+			}
+		case <-sub.evicted:
+			conn.Close(websocket.StatusTryAgainLater, "client fell behind")
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkOrigin applies the rules websocket.Accept uses for OriginPatterns, so
+// both endpoints admit the same pages. Requests without an Origin, which do
+// not come from a browser page, and same-origin requests always pass.
+// Patterns are matched with path.Match against the origin's host, or against
+// scheme://host when they contain "://".
+func checkOrigin(r *http.Request, patterns []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("failed to parse Origin header %q: %w", origin, err)
+	}
+	if strings.EqualFold(r.Host, u.Host) {
+		return nil
+	}
+	for _, pattern := range patterns {
+		target := u.Host
+		if strings.Contains(pattern, "://") {
+			target = u.Scheme + "://" + u.Host
+		}
+		matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(target))
+		if err != nil {
+			return fmt.Errorf("bad origin pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", origin)
+}
+
+func filterFromQuery(q url.Values) eventFilter {
+	return eventFilter{
+		Tables:     queryList(q, "table"),
+		Operations: queryList(q, "op"),
+		Routes:     queryList(q, "route"),
+	}
+}
+
+func queryList(q url.Values, key string) []string {
+	var list []string
+	for _, v := range q[key] {
+		for item := range strings.SplitSeq(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,242 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/coder/websocket"
+)
+
+func newTestLiveSink(t *testing.T, slowClient string, allowedOrigins ...string) *LiveSink {
+	t.Helper()
+	cfg := &configs.SinkConfig{
+		Type: "live",
+		Live: configs.LiveConfig{
+			Addr:           "127.0.0.1:0",
+			ReplayBuffer:   16,
+			ClientBuffer:   1,
+			SlowClient:     slowClient,
+			WriteTimeout:   time.Second,
+			AllowedOrigins: allowedOrigins,
+		},
+	}
+	l, err := NewLiveSink(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handlers are driven through httptest instead
+	l.Close()
+	return l
+}
+
+// stalledWriter is a response that blocks in Write until released, like a
+// client that stopped reading.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	mu       sync.Mutex
+	writing  chan struct{}
+	once     sync.Once
+	released chan struct{}
+}
+
+func newStalledWriter() *stalledWriter {
+	return &stalledWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		writing:          make(chan struct{}),
+		released:         make(chan struct{}),
+	}
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.released
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseRecorder.Write(p)
+}
+
+func (w *stalledWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ResponseRecorder.Flush()
+}
+
+func (w *stalledWriter) body() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Body.String()
+}
+
+// serveStalledSSE runs an SSE request whose client stalls on the first
+// event, publishes three events and releases the client.
+func serveStalledSSE(t *testing.T, l *LiveSink, ctx context.Context) (*stalledWriter, chan struct{}) {
+	t.Helper()
+	w := newStalledWriter()
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.serveSSE(w, r)
+	}()
+	waitForSubscribers(t, l.hub, 1)
+
+	l.hub.publish(testEvent("0/10", "cdc"))
+	select {
+	case <-w.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first event to be written")
+	}
+	// 0/20 fills the one-event buffer, so 0/30 finds it full
+	l.hub.publish(testEvent("0/20", "cdc"))
+	l.hub.publish(testEvent("0/30", "cdc"))
+	close(w.released)
+	return w, done
+}
+
+func TestLiveSinkSSEDropsSlowClients(t *testing.T) {
+	l := newTestLiveSink(t, "drop")
+	w, done := serveStalledSSE(t, l, context.Background())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow client was not disconnected")
+	}
+	body := w.body()
+	if !strings.Contains(body, "id: 0/10\n") {
+		t.Errorf("body %q is missing 0/10", body)
+	}
+	if strings.Contains(body, "id: 0/30\n") {
+		t.Errorf("body %q has 0/30, which came after the client fell behind", body)
+	}
+}
+
+func TestLiveSinkSSESamplesSlowClients(t *testing.T) {
+	l := newTestLiveSink(t, "sample")
+	ctx, cancel := context.WithCancel(context.Background())
+	w, done := serveStalledSSE(t, l, ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(w.body(), "id: 0/20\n") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for 0/20, body %q", w.body())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	body := w.body()
+	skipped := "event: skipped\ndata: {\"count\":1}\n\n"
+	if !strings.Contains(body, skipped) || strings.Index(body, skipped) > strings.Index(body, "id: 0/20\n") {
+		t.Errorf("body %q does not report the skipped event before 0/20", body)
+	}
+	if strings.Contains(body, "id: 0/30\n") {
+		t.Errorf("body %q has the skipped 0/30", body)
+	}
+}
+
+func TestLiveSinkSSEResumesFromLastEventID(t *testing.T) {
+	l := newTestLiveSink(t, "drop")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a fresh server holds nothing to resume from
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	r.Header.Set("Last-Event-ID", "0/10")
+	l.serveSSE(w, r)
+	if w.Code != http.StatusGone {
+		t.Errorf("resume on a fresh server: status %d, want 410", w.Code)
+	}
+
+	l.hub.publish(testEvent("0/10", "cdc"))
+	l.hub.publish(testEvent("0/20", "cdc"))
+	w = httptest.NewRecorder()
+	l.serveSSE(w, r)
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("content type = %q", w.Header().Get("Content-Type"))
+	}
+	if strings.Contains(body, "id: 0/10\n") || !strings.Contains(body, "id: 0/20\nevent: change\n") {
+		t.Errorf("body %q, want only 0/20", body)
+	}
+}
+
+func TestLiveSinkSSEChecksOrigin(t *testing.T) {
+	l := newTestLiveSink(t, "drop", "app.example", "https://*.corp.example")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusOK},
+		{"http://example.com", http.StatusOK}, // same origin as the request
+		{"https://app.example", http.StatusOK},
+		{"https://dash.corp.example", http.StatusOK},
+		{"http://dash.corp.example", http.StatusForbidden},
+		{"https://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/events", nil).WithContext(ctx)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		l.serveSSE(w, r)
+		if w.Code != tt.want {
+			t.Errorf("origin %q: status %d, want %d", tt.origin, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && w.Header().Get("Access-Control-Allow-Origin") != tt.origin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q", tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
+
+func TestLiveSinkWebSocket(t *testing.T) {
+	l := newTestLiveSink(t, "drop", "app.example")
+	srv := httptest.NewServer(l.server.Handler)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	header := http.Header{"Origin": {"https://evil.example"}}
+	if _, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: header}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("dial from a foreign origin: %v, want 403", err)
+	}
+
+	header = http.Header{"Origin": {"https://app.example"}}
+	conn, _, err := websocket.Dial(ctx, url+"?route=orders", &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	waitForSubscribers(t, l.hub, 1)
+	l.hub.publish(testEvent("0/10", "cdc"))
+	l.hub.publish(testEvent("0/20", "orders"))
+
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Type  string
+		Event struct{ Lsn, Route string }
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "change" || msg.Event.Lsn != "0/20" || msg.Event.Route != "orders" {
+		t.Errorf("message = %s, want the change at 0/20 on orders", data)
+	}
+}
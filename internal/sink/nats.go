@@ -0,0 +1,306 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Subjects are "<route>.<schema>.<table>.<op>", e.g. cdc.public.users.insert.
+// A missing route falls back to "cdc". A message JetStream does not ack after
+// max_retries synchronous retries is dead-lettered.
+type NATSSink struct {
+	conn        *nats.Conn
+	js          jetstream.JetStream
+	config      *configs.SinkConfig
+	ack         AckFunc
+	deadLetters dlq.Writer
+	pending     chan natsPending
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+	ids         msgIDs
+}
+
+// natsPending is a published message waiting for its ack, an event that
+// could not be encoded when err is set, or a commit marker otherwise.
+type natsPending struct {
+	msg    *nats.Msg
+	id     string
+	lsn    string
+	event  events.ChangeEvent
+	future jetstream.PubAckFuture
+	err    error
+}
+
+func NewNATSSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*NATSSink, error) {
+	nc, err := nats.Connect(cfg.NATS.URL)
+	if err != nil {
+		return nil, fmt.Errorf("NATS ERR: failed to connect: %w", err)
+	}
+
+	js, err := jetstream.New(nc,
+		jetstream.WithPublishAsyncMaxPending(cfg.NATS.MaxPending),
+		jetstream.WithPublishAsyncTimeout(cfg.NATS.AckTimeout),
+	)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	if err := ensureStream(js, cfg.NATS); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &NATSSink{
+		conn:        nc,
+		js:          js,
+		config:      cfg,
+		ack:         ack,
+		deadLetters: deadLetters,
+		pending:     make(chan natsPending, cfg.NATS.MaxPending),
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+func ensureStream(js jetstream.JetStream, cfg configs.NATSConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.Stream(ctx, cfg.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     cfg.Stream,
+			Subjects: cfg.Subjects,
+		})
+		if err != nil {
+			return fmt.Errorf("NATS ERR: could not create stream %s: %w", cfg.Stream, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("NATS ERR: could not look up stream %s: %w", cfg.Stream, err)
+	}
+
+	// an existing stream may predate a new route
+	existing := stream.CachedInfo().Config.Subjects
+	for _, subject := range cfg.Subjects {
+		if !configs.SubjectsCover(existing, subject) {
+			return fmt.Errorf("NATS ERR: stream %s listens on %v, which does not cover %s", cfg.Stream, existing, subject)
+		}
+	}
+	return nil
+}
+
+func (n *NATSSink) Start(eventCh <-chan events.ChangeEvent) error {
+	n.wg.Go(n.ackLoop)
+	n.wg.Go(func() {
+		defer close(n.pending)
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				if event.IsCommit() {
+					n.enqueue(natsPending{lsn: event.Lsn})
+					continue
+				}
+				msg, err := n.handleEvent(event)
+				if err != nil {
+					// dead-lettered by ackLoop, in order with the rest
+					n.enqueue(natsPending{lsn: event.Lsn, event: event, err: err})
+					continue
+				}
+				n.publish(msg, n.ids.next(event.Lsn), event)
+			case <-n.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (n *NATSSink) Stop() {
+	close(n.stopChan)
+	n.wg.Wait()
+}
+
+// Close releases the connection. Start closes it on the way out; it is only
+// needed directly when the sink was never started.
+func (n *NATSSink) Close() {
+	n.conn.Close()
+}
+
+// Resend publishes one event synchronously, used to replay dead letters.
+func (n *NATSSink) Resend(event events.ChangeEvent) error {
+	msg, err := n.handleEvent(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.NATS.AckTimeout)
+	defer cancel()
+	_, err = n.js.PublishMsg(ctx, msg, jetstream.WithMsgID(n.ids.next(event.Lsn)))
+	return err
+}
+
+func (n *NATSSink) handleEvent(event events.ChangeEvent) (*nats.Msg, error) {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nats.Msg{
+		Subject: eventSubject(event),
+		Data:    jsonEvent,
+	}, nil
+}
+
+// eventSubject is also used as the AMQP routing key, so it avoids the
+// wildcard characters of both.
+func eventSubject(event events.ChangeEvent) string {
+	route := event.Route
+	if route == "" {
+		route = "cdc"
+	}
+	return strings.Join([]string{
+		configs.SubjectRoute(route),
+		configs.SubjectToken(event.NameSpace),
+		configs.SubjectToken(event.Table),
+		strings.ToLower(event.Operation.ToString()),
+	}, ".")
+}
+
+// msgIDs numbers events within an LSN. Brokers drop duplicates by message
+// ID, so IDs have to come out the same when the slot replays events after a
+// restart.
+type msgIDs struct {
+	lastLSN string
+	seq     int
+}
+
+func (m *msgIDs) next(lsn string) string {
+	if lsn == m.lastLSN {
+		m.seq++
+	} else {
+		m.lastLSN = lsn
+		m.seq = 0
+	}
+	return fmt.Sprintf("%s-%d", lsn, m.seq)
+}
+
+func (n *NATSSink) publish(msg *nats.Msg, id string, event events.ChangeEvent) {
+	future, err := n.js.PublishMsgAsync(msg, jetstream.WithMsgID(id))
+	if err != nil {
+		// ackLoop retries synchronously when there is no future to wait on
+		fmt.Printf("ERROR: Async publish failed: %v\n", err)
+	}
+	n.enqueue(natsPending{msg: msg, id: id, lsn: event.Lsn, event: event, future: future})
+}
+
+func (n *NATSSink) enqueue(p natsPending) {
+	select {
+	case n.pending <- p:
+	case <-n.stopChan:
+	}
+}
+
+// ackLoop waits for publish acks in the order messages were sent and only
+// then acknowledges their LSNs.
+func (n *NATSSink) ackLoop() {
+	defer n.conn.Close()
+	for p := range n.pending {
+		if p.err != nil {
+			// retrying cannot encode it either
+			if !n.deadLetter(p, 1, p.err) {
+				return
+			}
+			n.ackLSN(p.lsn)
+			continue
+		}
+		if p.msg == nil {
+			n.ackLSN(p.lsn)
+			continue
+		}
+		if p.future != nil {
+			select {
+			case <-p.future.Ok():
+				n.ackLSN(p.lsn)
+				continue
+			case err := <-p.future.Err():
+				fmt.Printf("ERROR: Publish not acknowledged: %v\n", err)
+			}
+		}
+		err := n.publishSync(p)
+		if errors.Is(err, errStopping) {
+			return
+		}
+		if err != nil && !n.deadLetter(p, n.config.NATS.MaxRetries, err) {
+			return
+		}
+		n.ackLSN(p.lsn)
+	}
+}
+
+var errStopping = errors.New("sink is stopping")
+
+// publishSync retries a message until JetStream acks it, at most max_retries
+// times. The message ID is kept, so a retry of a publish that did land is
+// dropped as a duplicate.
+func (n *NATSSink) publishSync(p natsPending) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), n.config.NATS.AckTimeout)
+		_, err := n.js.PublishMsg(ctx, p.msg, jetstream.WithMsgID(p.id))
+		cancel()
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("ERROR: Publish retry %d to %s failed: %v\n", attempt, p.msg.Subject, err)
+		if attempt >= n.config.NATS.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-n.stopChan:
+			return errStopping
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+// deadLetter parks an event that could not be encoded or that JetStream
+// would not take. Without a DLQ it is dropped. The write happens here rather
+// than on a separate goroutine since acks have to stay in order; it returns
+// false only when stopping.
+func (n *NATSSink) deadLetter(p natsPending, attempts int, err error) bool {
+	fmt.Printf("ERROR: Failed to deliver event at %s to %s: %v\n", p.lsn, eventSubject(p.event), err)
+	if n.deadLetters == nil {
+		return true
+	}
+	return writeDeadLetter(n.deadLetters, dlq.NewEntry(p.event, n.name(), attempts, err), n.stopChan)
+}
+
+func (n *NATSSink) name() string {
+	if n.config.Name != "" {
+		return n.config.Name
+	}
+	return "nats"
+}
+
+func (n *NATSSink) ackLSN(lsn string) {
+	if n.ack != nil {
+		n.ack(lsn)
+	}
+}
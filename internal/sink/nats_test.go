@@ -0,0 +1,296 @@
+package sink
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// memoryDLQ collects dead letters for assertions.
+type memoryDLQ struct {
+	mu      sync.Mutex
+	entries []dlq.Entry
+}
+
+func (m *memoryDLQ) Write(entry dlq.Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memoryDLQ) Close() error { return nil }
+
+func (m *memoryDLQ) lsns() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lsns []string
+	for _, e := range m.entries {
+		lsns = append(lsns, e.Event.Lsn)
+	}
+	return lsns
+}
+
+// ackRecorder collects acked LSNs and signals once want of them arrived.
+type ackRecorder struct {
+	mu   sync.Mutex
+	lsns []string
+	want int
+	done chan struct{}
+}
+
+func newAckRecorder(want int) *ackRecorder {
+	return &ackRecorder{want: want, done: make(chan struct{})}
+}
+
+func (a *ackRecorder) ack(lsn string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lsns = append(a.lsns, lsn)
+	if len(a.lsns) == a.want {
+		close(a.done)
+	}
+}
+
+func (a *ackRecorder) acked() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.lsns)
+}
+
+// waitFor waits until lsn is acked, for sinks whose acks of the events
+// before it coalesce depending on timing.
+func (a *ackRecorder) waitFor(t *testing.T, lsn string) []string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if acked := a.acked(); slices.Contains(acked, lsn) {
+			return acked
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be acked", lsn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (a *ackRecorder) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case <-a.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %d acks", a.want)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.lsns)
+}
+
+func runJetStream(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func testEvent(lsn, route string) events.ChangeEvent {
+	return events.ChangeEvent{
+		Operation: events.OperationInsert,
+		NameSpace: "public",
+		Table:     "users",
+		After:     map[string]any{"id": 1},
+		PK:        []string{"id"},
+		Lsn:       lsn,
+		Route:     route,
+	}
+}
+
+func commitEvent(lsn string) events.ChangeEvent {
+	return events.ChangeEvent{Operation: events.OperationCommit, Lsn: lsn}
+}
+
+func TestNATSSinkPublishesAndDeadLetters(t *testing.T) {
+	srv := runJetStream(t)
+	cfg := &configs.SinkConfig{
+		Type: "nats",
+		NATS: configs.NATSConfig{
+			URL:        srv.ClientURL(),
+			Stream:     "CDC",
+			Subjects:   []string{"cdc.>"},
+			MaxPending: 16,
+			AckTimeout: 200 * time.Millisecond,
+			MaxRetries: 2,
+		},
+	}
+	acks := newAckRecorder(4)
+	deadLetters := &memoryDLQ{}
+	s, err := NewNATSSink(cfg, acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan events.ChangeEvent)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- testEvent("0/10", "cdc")
+	in <- commitEvent("0/20")
+	// no stream listens on other.>, so this one ends up dead-lettered
+	in <- testEvent("0/30", "other")
+	in <- commitEvent("0/40")
+
+	got := acks.wait(t)
+	close(in)
+	s.Stop()
+
+	if want := []string{"0/10", "0/20", "0/30", "0/40"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+	if got := deadLetters.lsns(); !slices.Equal(got, []string{"0/30"}) {
+		t.Errorf("dead letters = %v, want [0/30]", got)
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := js.Stream(ctx, "CDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := stream.GetLastMsgForSubject(ctx, "cdc.public.users.insert")
+	if err != nil {
+		t.Fatalf("event not in stream: %v", err)
+	}
+	if msg.Header.Get(nats.MsgIdHdr) != "0/10-0" {
+		t.Errorf("message id = %q, want 0/10-0", msg.Header.Get(nats.MsgIdHdr))
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 1 {
+		t.Errorf("stream holds %d messages, want 1", info.State.Msgs)
+	}
+}
+
+func TestNATSSinkDeadLettersUnencodableEvents(t *testing.T) {
+	srv := runJetStream(t)
+	cfg := &configs.SinkConfig{
+		Type: "nats",
+		NATS: configs.NATSConfig{
+			URL:        srv.ClientURL(),
+			Stream:     "CDC",
+			Subjects:   []string{"cdc.>"},
+			MaxPending: 16,
+			AckTimeout: time.Second,
+			MaxRetries: 1,
+		},
+	}
+	acks := newAckRecorder(3)
+	deadLetters := &memoryDLQ{}
+	s, err := NewNATSSink(cfg, acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan events.ChangeEvent)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	// JSON has no NaN, so the event cannot be encoded
+	bad := testEvent("0/10", "cdc")
+	bad.After = map[string]any{"id": 1, "score": math.NaN()}
+	in <- bad
+	in <- testEvent("0/20", "cdc")
+	in <- commitEvent("0/30")
+
+	got := acks.wait(t)
+	close(in)
+	s.Stop()
+
+	if want := []string{"0/10", "0/20", "0/30"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+	if got := deadLetters.lsns(); !slices.Equal(got, []string{"0/10"}) {
+		t.Errorf("dead letters = %v, want [0/10]", got)
+	}
+}
+
+func TestNATSSinkRejectsStreamNotCoveringSubjects(t *testing.T) {
+	srv := runJetStream(t)
+	cfg := &configs.SinkConfig{
+		Type: "nats",
+		NATS: configs.NATSConfig{
+			URL:        srv.ClientURL(),
+			Stream:     "CDC",
+			Subjects:   []string{"cdc.>"},
+			MaxPending: 16,
+			AckTimeout: time.Second,
+			MaxRetries: 1,
+		},
+	}
+	s, err := NewNATSSink(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.conn.Close()
+
+	cfg.NATS.Subjects = []string{"cdc.>", "orders.>"}
+	if _, err := NewNATSSink(cfg, nil, nil); err == nil {
+		t.Fatal("expected an error for a stream that does not cover orders.>")
+	}
+}
+
+func TestEventSubject(t *testing.T) {
+	tests := []struct {
+		route string
+		ns    string
+		table string
+		want  string
+	}{
+		{"cdc", "public", "users", "cdc.public.users.insert"},
+		{"", "public", "users", "cdc.public.users.insert"},
+		{"orders.eu", "sales", "orders", "orders.eu.sales.orders.insert"},
+		{"orders..x", "sales", "orders", "orders._.x.sales.orders.insert"},
+		{"my route.*", "a.b", "t>1", "my_route._.a_b.t_1.insert"},
+	}
+	for _, tt := range tests {
+		event := testEvent("0/1", tt.route)
+		event.NameSpace, event.Table = tt.ns, tt.table
+		if got := eventSubject(event); got != tt.want {
+			t.Errorf("eventSubject(route %q) = %q, want %q", tt.route, got, tt.want)
+		}
+	}
+}
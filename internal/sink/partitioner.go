@@ -0,0 +1,62 @@
+package sink
+
+import "github.com/twmb/franz-go/pkg/kgo"
+
+// getPartitioner picks how records map to partitions. Keyed records are
+// always hashed with murmur2 exactly like the Java client's default
+// partitioner, so topics written here co-partition with Java producers and
+// Kafka Streams. The setting only decides where records without a key go:
+// murmur2 spreads them in sticky batches as Java does, sticky keeps filling
+// one partition per batch, and round_robin rotates record by record.
+func getPartitioner(partitioner string) kgo.Partitioner {
+	keyed := kgo.StickyKeyPartitioner(nil)
+	switch partitioner {
+	case "sticky":
+		return keylessPartitioner{keyed: keyed, keyless: kgo.StickyPartitioner()}
+	case "round_robin":
+		return keylessPartitioner{keyed: keyed, keyless: kgo.RoundRobinPartitioner()}
+	default:
+		return keyed
+	}
+}
+
+// keylessPartitioner sends keyed records to one partitioner and null-key
+// records to another.
+type keylessPartitioner struct {
+	keyed   kgo.Partitioner
+	keyless kgo.Partitioner
+}
+
+func (p keylessPartitioner) ForTopic(topic string) kgo.TopicPartitioner {
+	return &keylessTopicPartitioner{
+		keyed:   p.keyed.ForTopic(topic),
+		keyless: p.keyless.ForTopic(topic),
+	}
+}
+
+type keylessTopicPartitioner struct {
+	keyed   kgo.TopicPartitioner
+	keyless kgo.TopicPartitioner
+}
+
+func (p *keylessTopicPartitioner) pick(r *kgo.Record) kgo.TopicPartitioner {
+	if r.Key == nil {
+		return p.keyless
+	}
+	return p.keyed
+}
+
+func (p *keylessTopicPartitioner) RequiresConsistency(r *kgo.Record) bool {
+	return p.pick(r).RequiresConsistency(r)
+}
+
+func (p *keylessTopicPartitioner) Partition(r *kgo.Record, n int) int {
+	return p.pick(r).Partition(r, n)
+}
+
+// OnNewBatch lets the sticky partitioner move on to its next partition.
+func (p *keylessTopicPartitioner) OnNewBatch() {
+	if nb, ok := p.keyless.(kgo.TopicPartitionerOnNewBatch); ok {
+		nb.OnNewBatch()
+	}
+}
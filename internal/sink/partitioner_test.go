@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestPartitionerMatchesJavaForKeys(t *testing.T) {
+	// murmur2 hashes from the Java client's own tests; it picks
+	// toPositive(hash) % partitions
+	tests := []struct {
+		key  string
+		hash int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+	for _, name := range []string{"", "murmur2", "sticky", "round_robin"} {
+		p := getPartitioner(name).ForTopic("orders")
+		for _, tt := range tests {
+			r := &kgo.Record{Key: []byte(tt.key)}
+			want := int(tt.hash&0x7fffffff) % 12
+			if got := p.Partition(r, 12); got != want {
+				t.Errorf("%q partitioner put %q on %d, want %d", name, tt.key, got, want)
+			}
+			if !p.RequiresConsistency(r) {
+				t.Errorf("%q partitioner does not keep %q on one partition", name, tt.key)
+			}
+		}
+	}
+}
+
+func TestPartitionerKeylessRecords(t *testing.T) {
+	p := getPartitioner("round_robin").ForTopic("orders")
+	for i := range 6 {
+		if got := p.Partition(&kgo.Record{}, 3); got != i%3 {
+			t.Errorf("round_robin record %d went to %d, want %d", i, got, i%3)
+		}
+	}
+
+	p = getPartitioner("sticky").ForTopic("orders")
+	first := p.Partition(&kgo.Record{}, 3)
+	for range 5 {
+		if got := p.Partition(&kgo.Record{}, 3); got != first {
+			t.Fatalf("sticky record went to %d before a new batch, want %d", got, first)
+		}
+	}
+	// a keyed record in between does not move the keyless batch
+	p.Partition(&kgo.Record{Key: []byte("foobar")}, 3)
+	if got := p.Partition(&kgo.Record{}, 3); got != first {
+		t.Errorf("sticky record went to %d after a keyed one, want %d", got, first)
+	}
+	p.(kgo.TopicPartitionerOnNewBatch).OnNewBatch()
+	if got := p.Partition(&kgo.Record{}, 3); got == first {
+		t.Errorf("sticky record stayed on %d after a new batch", got)
+	}
+}
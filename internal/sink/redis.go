@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSink appends every event to a stream named after its route. With
+// cache_rows enabled it also keeps one hash per primary key holding the
+// latest row image, e.g. cdc:row:public.users:42. Events that cannot be
+// encoded or that Redis refuses are dead-lettered; connection failures are
+// retried.
+type RedisSink struct {
+	client      *redis.Client
+	config      *configs.SinkConfig
+	ack         AckFunc
+	deadLetters dlq.Writer
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewRedisSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*RedisSink, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("REDIS ERR: failed to connect: %w", err)
+	}
+
+	return &RedisSink{
+		client:      client,
+		config:      cfg,
+		ack:         ack,
+		deadLetters: deadLetters,
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+func (r *RedisSink) Start(eventCh <-chan events.ChangeEvent) error {
+	r.wg.Go(func() {
+		defer r.client.Close()
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				if !event.IsCommit() && !r.writeWithRetry(event) {
+					return
+				}
+				if r.ack != nil {
+					r.ack(event.Lsn)
+				}
+			case <-r.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (r *RedisSink) Stop() {
+	close(r.stopChan)
+	r.wg.Wait()
+}
+
+// Close releases the client. Start closes it on the way out; it is only
+// needed directly when the sink was never started.
+func (r *RedisSink) Close() {
+	r.client.Close()
+}
+
+// Resend writes one event, used to replay dead letters.
+func (r *RedisSink) Resend(event events.ChangeEvent) error {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return r.write(event, jsonEvent)
+}
+
+// writeWithRetry retries a write until Redis takes it. An event that cannot
+// be encoded or that Redis refuses would fail the same way every time, so it
+// is dead-lettered instead. It returns false only when stopping.
+func (r *RedisSink) writeWithRetry(event events.ChangeEvent) bool {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return r.deadLetter(event, 1, err)
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := r.write(event, jsonEvent)
+		if err == nil {
+			return true
+		}
+		if redisRefused(err) {
+			return r.deadLetter(event, attempt, err)
+		}
+		fmt.Printf("ERROR: Redis write failed: %v\n", err)
+
+		select {
+		case <-r.stopChan:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+// redisRefused reports whether Redis answered with an error that sending the
+// command again would repeat, such as WRONGTYPE when the stream key holds
+// something else. Replies that only mean Redis cannot take writes right now
+// are retried.
+func redisRefused(err error) bool {
+	var reply redis.Error
+	if !errors.As(err, &reply) {
+		return false
+	}
+	return !redis.IsLoadingError(err) && !redis.IsReadOnlyError(err) &&
+		!redis.IsClusterDownError(err) && !redis.IsTryAgainError(err) &&
+		!redis.IsMasterDownError(err) && !redis.IsMaxClientsError(err) &&
+		!redis.IsOOMError(err) && !redis.IsAuthError(err) && !redis.IsPermissionError(err)
+}
+
+// deadLetter parks an event Redis cannot store. Without a DLQ it is dropped.
+// It returns false only when stopping.
+func (r *RedisSink) deadLetter(event events.ChangeEvent, attempts int, err error) bool {
+	fmt.Printf("ERROR: Failed to write event at %s to stream %s: %v\n", event.Lsn, redisStream(event), err)
+	if r.deadLetters == nil {
+		return true
+	}
+	return writeDeadLetter(r.deadLetters, dlq.NewEntry(event, r.name(), attempts, err), r.stopChan)
+}
+
+func (r *RedisSink) name() string {
+	if r.config.Name != "" {
+		return r.config.Name
+	}
+	return "redis"
+}
+
+// write adds the stream entry and updates the row cache in one MULTI so the
+// cache never runs ahead of the stream.
+func (r *RedisSink) write(event events.ChangeEvent, jsonEvent []byte) error {
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: redisStream(event),
+			MaxLen: r.config.Redis.MaxLen,
+			Approx: r.config.Redis.MaxLen > 0 && r.config.Redis.ApproxTrim,
+			Values: map[string]any{
+				"op":    event.Operation.ToString(),
+				"table": event.NameSpace + "." + event.Table,
+				"lsn":   event.Lsn,
+				"event": jsonEvent,
+			},
+		})
+		if r.config.Redis.CacheRows && len(event.PK) > 0 {
+			r.updateRowCache(ctx, pipe, event)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisSink) updateRowCache(ctx context.Context, pipe redis.Pipeliner, event events.ChangeEvent) {
+	key := r.rowKey(event, event.PKValues())
+
+	// an update can move the row to a new key, drop the old one
+	if event.Operation == events.OperationUpdate && event.Before != nil {
+		if oldKey := r.rowKey(event, event.PKValuesOf(event.Before)); oldKey != key {
+			pipe.Del(ctx, oldKey)
+		}
+	}
+
+	pipe.Del(ctx, key)
+	if event.Operation == events.OperationDelete {
+		return
+	}
+
+	fields := make(map[string]any, len(event.After))
+	for col, val := range event.After {
+		if val == nil {
+			continue
+		}
+		fields[col] = fmt.Sprintf("%v", val)
+	}
+	if len(fields) > 0 {
+		pipe.HSet(ctx, key, fields)
+	}
+}
+
+func (r *RedisSink) rowKey(event events.ChangeEvent, pkValues []string) string {
+	return fmt.Sprintf("%s:%s.%s:%s", r.config.Redis.CachePrefix, event.NameSpace, event.Table, strings.Join(pkValues, ":"))
+}
+
+func redisStream(event events.ChangeEvent) string {
+	if event.Route == "" {
+		return "cdc"
+	}
+	return event.Route
+}
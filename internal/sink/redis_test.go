@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func redisConfig(m *miniredis.Miniredis) *configs.SinkConfig {
+	return &configs.SinkConfig{
+		Type: "redis",
+		Redis: configs.RedisConfig{
+			Addr:        m.Addr(),
+			CacheRows:   true,
+			CachePrefix: "cdc:row",
+		},
+	}
+}
+
+func TestRedisSinkWritesStreamsAndRowCache(t *testing.T) {
+	m := miniredis.RunT(t)
+	acks := newAckRecorder(5)
+	s, err := NewRedisSink(redisConfig(m), acks.ack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+
+	in <- testEvent("0/10", "cdc")
+	// the key moves from 1 to 2, so the cached row under 1 has to go
+	moved := testEvent("0/20", "cdc")
+	moved.Operation = events.OperationUpdate
+	moved.Before = map[string]any{"id": 1}
+	moved.After = map[string]any{"id": 2, "name": "ada", "email": nil}
+	in <- moved
+	in <- commitEvent("0/30")
+	in <- events.ChangeEvent{
+		Operation: events.OperationDelete,
+		NameSpace: "public",
+		Table:     "orders",
+		Before:    map[string]any{"id": 7},
+		PK:        []string{"id"},
+		Lsn:       "0/40",
+		Route:     "orders",
+	}
+	in <- commitEvent("0/50")
+
+	got := acks.wait(t)
+	close(in)
+	s.Stop()
+
+	if want := []string{"0/10", "0/20", "0/30", "0/40", "0/50"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+
+	entries, err := m.Stream("cdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("stream cdc has %d entries, want 2", len(entries))
+	}
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(entries[1].Values); i += 2 {
+		fields[entries[1].Values[i]] = entries[1].Values[i+1]
+	}
+	if fields["op"] != "UPDATE" || fields["table"] != "public.users" || fields["lsn"] != "0/20" {
+		t.Errorf("entry fields = %v, want an UPDATE of public.users at 0/20", fields)
+	}
+	if orders, _ := m.Stream("orders"); len(orders) != 1 {
+		t.Errorf("stream orders has %d entries, want 1", len(orders))
+	}
+
+	if m.Exists("cdc:row:public.users:1") {
+		t.Error("row under the old key is still cached")
+	}
+	if got := m.HGet("cdc:row:public.users:2", "name"); got != "ada" {
+		t.Errorf("cached name = %q, want ada", got)
+	}
+	// null columns are left out of the hash
+	if cached, _ := m.HKeys("cdc:row:public.users:2"); !slices.Equal(cached, []string{"id", "name"}) {
+		t.Errorf("cached fields = %v, want [id name]", cached)
+	}
+	if m.Exists("cdc:row:public.orders:7") {
+		t.Error("deleted row is still cached")
+	}
+}
+
+func TestRedisSinkDeadLettersWhatRedisCannotTake(t *testing.T) {
+	m := miniredis.RunT(t)
+	// XADD to a key holding a string gets WRONGTYPE
+	m.Set("taken", "not a stream")
+
+	acks := newAckRecorder(3)
+	deadLetters := &memoryDLQ{}
+	s, err := NewRedisSink(redisConfig(m), acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+
+	in <- testEvent("0/10", "taken")
+	// JSON has no infinity, so the event cannot be encoded
+	bad := testEvent("0/20", "cdc")
+	bad.After = map[string]any{"id": 1, "score": math.Inf(1)}
+	in <- bad
+	in <- testEvent("0/30", "cdc")
+
+	got := acks.wait(t)
+	close(in)
+	s.Stop()
+
+	if want := []string{"0/10", "0/20", "0/30"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+	if got := deadLetters.lsns(); !slices.Equal(got, []string{"0/10", "0/20"}) {
+		t.Errorf("dead letters = %v, want [0/10 0/20]", got)
+	}
+	if entries, _ := m.Stream("cdc"); len(entries) != 1 {
+		t.Errorf("stream cdc has %d entries, want 1", len(entries))
+	}
+}
+
+func TestRedisSinkRetriesWhileRedisIsDown(t *testing.T) {
+	m := miniredis.RunT(t)
+	acks := newAckRecorder(1)
+	deadLetters := &memoryDLQ{}
+	s, err := NewRedisSink(redisConfig(m), acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	in <- testEvent("0/10", "cdc")
+	// give the write time to fail at least once
+	time.Sleep(300 * time.Millisecond)
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := acks.wait(t)
+	close(in)
+	s.Stop()
+
+	if !slices.Equal(got, []string{"0/10"}) {
+		t.Errorf("acks = %v, want [0/10]", got)
+	}
+	if got := deadLetters.lsns(); len(got) != 0 {
+		t.Errorf("dead letters = %v, want none", got)
+	}
+	if entries, _ := m.Stream("cdc"); len(entries) != 1 {
+		t.Errorf("stream cdc has %d entries, want 1", len(entries))
+	}
+}
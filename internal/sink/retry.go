@@ -0,0 +1,21 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+)
+
+// retryBackoff is the wait franz-go applies before retrying a failed produce
+// request. Retries happen inside the client, which keeps records of a
+// partition in order, so a retried record can never land after a later one.
+// tries starts at 1 for the first retry.
+func retryBackoff(cfg configs.RetryConfig) func(int) time.Duration {
+	return func(tries int) time.Duration {
+		d := float64(cfg.InitialBackoff)
+		for range tries - 1 {
+			d *= cfg.Multiplier
+		}
+		return min(time.Duration(d), cfg.MaxBackoff)
+	}
+}
@@ -0,0 +1,41 @@
+package sink
+
+import (
+	"fmt"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+)
+
+type Sink interface {
+	Start(eventCh <-chan events.ChangeEvent) error
+	Stop()
+}
+
+// AckFunc is called with an event's LSN once the sink has delivered that
+// event and every event before it.
+type AckFunc func(lsn string)
+
+func New(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (Sink, error) {
+	switch cfg.Type {
+	case "", "kafka":
+		return NewKafkaSink(cfg, ack, deadLetters)
+	case "nats":
+		return NewNATSSink(cfg, ack, deadLetters)
+	case "redis":
+		return NewRedisSink(cfg, ack, deadLetters)
+	case "amqp", "rabbitmq":
+		return NewAMQPSink(cfg, ack, deadLetters)
+	case "elasticsearch", "opensearch":
+		return NewElasticsearchSink(cfg, ack, deadLetters)
+	case "sqlite":
+		return NewSQLiteSink(cfg, ack, deadLetters)
+	case "grpc":
+		return NewGRPCSink(cfg, ack)
+	case "live":
+		return NewLiveSink(cfg, ack)
+	default:
+		return nil, fmt.Errorf("SINK ERR: unknown sink type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,410 @@
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/dlq"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/jackc/pglogrepl"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSink mirrors captured tables into a local SQLite file. Tables in the
+// public schema keep their name, others become "<schema>.<table>". Each source
+// transaction is applied as one SQLite transaction together with its commit
+// LSN, so replays after a restart are skipped instead of applied twice.
+// A transaction SQLite refuses is dead-lettered as a whole.
+type SQLiteSink struct {
+	db          *sql.DB
+	config      *configs.SinkConfig
+	ack         AckFunc
+	deadLetters dlq.Writer
+	tables      map[string]map[string]bool
+	appliedLSN  pglogrepl.LSN
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+const sqliteMetaTable = "_cdc_meta"
+
+func NewSQLiteSink(cfg *configs.SinkConfig, ack AckFunc, deadLetters dlq.Writer) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite3", cfg.SQLite.Path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("SQLITE ERR: failed to open %s: %w", cfg.SQLite.Path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + sqliteMetaTable + ` (key TEXT PRIMARY KEY, value TEXT NOT NULL)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("SQLITE ERR: failed to create metadata table: %w", err)
+	}
+
+	var applied pglogrepl.LSN
+	var lsnStr string
+	err = db.QueryRow(`SELECT value FROM ` + sqliteMetaTable + ` WHERE key = 'applied_lsn'`).Scan(&lsnStr)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		db.Close()
+		return nil, fmt.Errorf("SQLITE ERR: failed to read applied LSN: %w", err)
+	default:
+		applied, err = pglogrepl.ParseLSN(lsnStr)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("SQLITE ERR: bad applied LSN %q: %w", lsnStr, err)
+		}
+	}
+
+	return &SQLiteSink{
+		db:          db,
+		config:      cfg,
+		ack:         ack,
+		deadLetters: deadLetters,
+		tables:      make(map[string]map[string]bool),
+		appliedLSN:  applied,
+		stopChan:    make(chan struct{}),
+	}, nil
+}
+
+// Start collects the events of a source transaction and applies them when
+// its commit marker arrives. A transaction that is cut off by shutdown is
+// dropped; it was never acked, so the slot sends it again.
+func (s *SQLiteSink) Start(eventCh <-chan events.ChangeEvent) error {
+	s.wg.Go(func() {
+		defer s.db.Close()
+
+		var txn []events.ChangeEvent
+		for {
+			select {
+			case event, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				if !event.IsCommit() {
+					txn = append(txn, event)
+					continue
+				}
+				lsn, err := pglogrepl.ParseLSN(event.Lsn)
+				if err != nil {
+					fmt.Printf("ERROR: Failed to parse commit LSN: %v\n", err)
+					continue
+				}
+				switch {
+				case lsn <= s.appliedLSN, len(txn) == 0:
+					// applied before a restart, or nothing to write; either
+					// way the slot may move on
+					if s.ack != nil {
+						s.ack(event.Lsn)
+					}
+				case !s.applyWithRetry(txn, event.Lsn):
+					return
+				}
+				txn = txn[:0]
+			case <-s.stopChan:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (s *SQLiteSink) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+}
+
+// Close releases the database of a sink that was never started; Start closes
+// it on the way out.
+func (s *SQLiteSink) Close() {
+	s.db.Close()
+}
+
+// applyWithRetry applies a transaction until it commits. A failed event rolls
+// back the whole transaction rather than leaving it half applied. Failures
+// that would repeat, such as a constraint violation or an update on a table
+// without a primary key, dead-letter the transaction instead of retrying it.
+// It returns false only when stopping.
+func (s *SQLiteSink) applyWithRetry(txn []events.ChangeEvent, lsn string) bool {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := s.applyTxn(txn, lsn)
+		if err == nil {
+			return true
+		}
+		if len(txn) > 0 && !sqliteTransient(err) {
+			return s.deadLetter(txn, lsn, attempt, err)
+		}
+		fmt.Printf("ERROR: Failed to apply transaction committed at %s, retrying: %v\n", lsn, err)
+
+		select {
+		case <-s.stopChan:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+// sqliteTransient reports whether an error may clear up by itself, like a
+// database another process holds locked or a full disk.
+func sqliteTransient(err error) bool {
+	var serr sqlite3.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	switch serr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrNomem, sqlite3.ErrReadonly,
+		sqlite3.ErrIoErr, sqlite3.ErrFull, sqlite3.ErrCantOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+// deadLetter parks every event of a transaction SQLite will not apply, then
+// records its commit so that a replay skips it. Without a DLQ the events are
+// dropped. It returns false only when stopping.
+func (s *SQLiteSink) deadLetter(txn []events.ChangeEvent, lsn string, attempts int, err error) bool {
+	fmt.Printf("ERROR: Failed to apply transaction committed at %s, dead-lettering its %d events: %v\n", lsn, len(txn), err)
+	if s.deadLetters != nil {
+		for _, event := range txn {
+			if !writeDeadLetter(s.deadLetters, dlq.NewEntry(event, s.name(), attempts, err), s.stopChan) {
+				return false
+			}
+		}
+	}
+	return s.applyWithRetry(nil, lsn)
+}
+
+func (s *SQLiteSink) name() string {
+	if s.config.Name != "" {
+		return s.config.Name
+	}
+	return "sqlite"
+}
+
+func (s *SQLiteSink) applyTxn(txn []events.ChangeEvent, lsn string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, event := range txn {
+		if err := s.apply(tx, event); err != nil {
+			s.rollback(tx)
+			return fmt.Errorf("%s on %s.%s at %s: %w", event.Operation.ToString(), event.NameSpace, event.Table, event.Lsn, err)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO `+sqliteMetaTable+` (key, value) VALUES ('applied_lsn', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, lsn)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		s.rollback(tx)
+		return err
+	}
+
+	s.appliedLSN, _ = pglogrepl.ParseLSN(lsn)
+	if s.ack != nil {
+		s.ack(lsn)
+	}
+	return nil
+}
+
+func (s *SQLiteSink) rollback(tx *sql.Tx) {
+	tx.Rollback()
+	// DDL may have been rolled back with it
+	s.tables = make(map[string]map[string]bool)
+}
+
+func (s *SQLiteSink) apply(tx *sql.Tx, event events.ChangeEvent) error {
+	table := sqliteTableName(event)
+	if err := s.ensureTable(tx, table, event); err != nil {
+		return err
+	}
+
+	switch event.Operation {
+	case events.OperationInsert:
+		return sqliteUpsert(tx, table, event.PK, event.After)
+	case events.OperationUpdate:
+		if len(event.PK) == 0 {
+			return fmt.Errorf("table has no primary key to match the update on")
+		}
+		if event.Before != nil && !slices.Equal(event.PKValuesOf(event.Before), event.PKValues()) {
+			if err := sqliteDelete(tx, table, event.PK, event.Before); err != nil {
+				return err
+			}
+		}
+		return sqliteUpsert(tx, table, event.PK, event.After)
+	case events.OperationDelete:
+		if len(event.PK) == 0 {
+			return fmt.Errorf("table has no primary key to match the delete on")
+		}
+		return sqliteDelete(tx, table, event.PK, event.Before)
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+}
+
+// ensureTable creates the table from the relation's columns on first sight
+// and adds any columns that showed up since.
+func (s *SQLiteSink) ensureTable(tx *sql.Tx, table string, event events.ChangeEvent) error {
+	cols := event.Columns
+	if len(cols) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(event.Row())) {
+			cols = append(cols, events.Column{Name: name})
+		}
+	}
+
+	known, ok := s.tables[table]
+	if !ok {
+		defs := make([]string, len(cols))
+		for i, col := range cols {
+			defs[i] = quoteIdent(col.Name) + " " + sqliteAffinity(col.TypeOID)
+		}
+		if len(event.PK) > 0 {
+			defs = append(defs, "PRIMARY KEY ("+quoteIdents(event.PK)+")")
+		}
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS ` + quoteIdent(table) + ` (` + strings.Join(defs, ", ") + `)`); err != nil {
+			return err
+		}
+
+		existing, err := sqliteColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		known = existing
+		s.tables[table] = known
+	}
+
+	for _, col := range cols {
+		if known[col.Name] {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + quoteIdent(table) + ` ADD COLUMN ` + quoteIdent(col.Name) + ` ` + sqliteAffinity(col.TypeOID)); err != nil {
+			return err
+		}
+		known[col.Name] = true
+	}
+	return nil
+}
+
+func sqliteColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+func sqliteUpsert(tx *sql.Tx, table string, pk []string, row map[string]any) error {
+	cols := slices.Sorted(maps.Keys(row))
+	if len(cols) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(cols))
+	placeholders := make([]string, len(cols))
+	var updates []string
+	for i, col := range cols {
+		args[i] = sqliteValue(row[col])
+		placeholders[i] = "?"
+		if !slices.Contains(pk, col) {
+			updates = append(updates, quoteIdent(col)+" = excluded."+quoteIdent(col))
+		}
+	}
+
+	stmt := `INSERT INTO ` + quoteIdent(table) + ` (` + quoteIdents(cols) + `) VALUES (` + strings.Join(placeholders, ", ") + `)`
+	switch {
+	case len(pk) == 0:
+	case len(updates) == 0:
+		stmt += ` ON CONFLICT (` + quoteIdents(pk) + `) DO NOTHING`
+	default:
+		stmt += ` ON CONFLICT (` + quoteIdents(pk) + `) DO UPDATE SET ` + strings.Join(updates, ", ")
+	}
+
+	_, err := tx.Exec(stmt, args...)
+	return err
+}
+
+func sqliteDelete(tx *sql.Tx, table string, pk []string, row map[string]any) error {
+	conds := make([]string, len(pk))
+	args := make([]any, len(pk))
+	for i, col := range pk {
+		conds[i] = quoteIdent(col) + " = ?"
+		args[i] = sqliteValue(row[col])
+	}
+	_, err := tx.Exec(`DELETE FROM `+quoteIdent(table)+` WHERE `+strings.Join(conds, " AND "), args...)
+	return err
+}
+
+// sqliteTableName keeps public tables under their own name. Others are
+// qualified with their schema, which is unambiguous since a public table
+// named with a dot gets the prefix too.
+func sqliteTableName(event events.ChangeEvent) string {
+	if (event.NameSpace == "" || event.NameSpace == "public") && !strings.Contains(event.Table, ".") {
+		return event.Table
+	}
+	return event.NameSpace + "." + event.Table
+}
+
+func sqliteAffinity(oid uint32) string {
+	switch oid {
+	case 16, 20, 21, 23: // bool, int8, int2, int4
+		return "INTEGER"
+	case 700, 701, 1700: // float4, float8, numeric
+		return "REAL"
+	case 17: // bytea
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+func sqliteValue(v any) any {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case map[string]any, []any:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(b)
+	default:
+		return v
+	}
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,160 @@
+package sink
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/mattn/go-sqlite3"
+)
+
+func sqliteEvent(lsn, schema, table string, op events.Operation, id int) events.ChangeEvent {
+	event := events.ChangeEvent{
+		Operation: op,
+		NameSpace: schema,
+		Table:     table,
+		PK:        []string{"id"},
+		Lsn:       lsn,
+		Columns:   []events.Column{{Name: "id", TypeOID: 23}},
+	}
+	row := map[string]any{"id": id}
+	if op == events.OperationDelete {
+		event.Before = row
+	} else {
+		event.After = row
+	}
+	return event
+}
+
+func countRows(t *testing.T, path, table string) int {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM ` + quoteIdent(table)).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteSinkAppliesWholeTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.db")
+	cfg := &configs.SinkConfig{Type: "sqlite", SQLite: configs.SQLiteConfig{Path: path}}
+	acks := newAckRecorder(3)
+	deadLetters := &memoryDLQ{}
+	s, err := NewSQLiteSink(cfg, acks.ack, deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent, 10)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+
+	in <- sqliteEvent("0/10", "public", "audit_users", events.OperationInsert, 1)
+	in <- sqliteEvent("0/11", "audit", "users", events.OperationInsert, 1)
+	in <- commitEvent("0/20")
+	// the second transaction fails on its last event for good, so it is
+	// dead-lettered whole rather than half applied or retried
+	in <- sqliteEvent("0/30", "audit", "users", events.OperationInsert, 2)
+	bad := sqliteEvent("0/31", "audit", "users", events.OperationDelete, 1)
+	bad.PK = nil
+	in <- bad
+	in <- commitEvent("0/40")
+	in <- sqliteEvent("0/50", "audit", "users", events.OperationInsert, 3)
+	in <- commitEvent("0/60")
+
+	got := acks.wait(t)
+	s.Stop()
+
+	if want := []string{"0/20", "0/40", "0/60"}; !slices.Equal(got, want) {
+		t.Errorf("acks = %v, want %v", got, want)
+	}
+	if got := deadLetters.lsns(); !slices.Equal(got, []string{"0/30", "0/31"}) {
+		t.Errorf("dead letters = %v, want [0/30 0/31]", got)
+	}
+	if n := countRows(t, path, "audit_users"); n != 1 {
+		t.Errorf("audit_users has %d rows, want 1", n)
+	}
+	if n := countRows(t, path, "audit.users"); n != 2 {
+		t.Errorf("audit.users has %d rows, want 2", n)
+	}
+
+	s, err = NewSQLiteSink(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	if s.appliedLSN.String() != "0/60" {
+		t.Errorf("applied LSN = %s, want 0/60", s.appliedLSN)
+	}
+}
+
+func TestSQLiteTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{fmt.Errorf("INSERT on public.users at 0/1: %w", sqlite3.Error{Code: sqlite3.ErrLocked}), true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{sqlite3.Error{Code: sqlite3.ErrMismatch}, false},
+		{sqlite3.Error{Code: sqlite3.ErrError}, false},
+		{errors.New("table has no primary key to match the delete on"), false},
+	}
+	for _, tt := range tests {
+		if got := sqliteTransient(tt.err); got != tt.want {
+			t.Errorf("sqliteTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteSinkAcksReplayedCommits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.db")
+	cfg := &configs.SinkConfig{Type: "sqlite", SQLite: configs.SQLiteConfig{Path: path}}
+	s, err := NewSQLiteSink(cfg, newAckRecorder(-1).ack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan events.ChangeEvent, 10)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- sqliteEvent("0/10", "public", "users", events.OperationInsert, 1)
+	in <- commitEvent("0/20")
+	close(in)
+	s.wg.Wait()
+
+	// after a restart the slot sends the applied transaction again, and it
+	// has to be acked for the slot to move past it
+	acks := newAckRecorder(2)
+	s, err = NewSQLiteSink(cfg, acks.ack, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = make(chan events.ChangeEvent, 10)
+	if err := s.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	in <- sqliteEvent("0/10", "public", "users", events.OperationInsert, 1)
+	in <- commitEvent("0/20")
+	in <- sqliteEvent("0/30", "public", "users", events.OperationInsert, 2)
+	in <- commitEvent("0/40")
+	got := acks.wait(t)
+	s.Stop()
+
+	if !slices.Equal(got, []string{"0/20", "0/40"}) {
+		t.Errorf("acks = %v, want [0/20 0/40]", got)
+	}
+	if n := countRows(t, path, "users"); n != 2 {
+		t.Errorf("users has %d rows, want 2", n)
+	}
+}
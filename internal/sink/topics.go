@@ -0,0 +1,226 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// topicManager creates the topics routes send to when they are missing and
+// checks the ones that exist. Settings are keyed by route as written in the
+// pipeline config: a static route is one topic and is handled at startup, a
+// route template covers every topic it renders and each of those is handled
+// before its first record. Mismatches are logged, or returned as an error
+// with strict_topics, since a topic with the wrong cleanup policy silently
+// loses data instead of failing.
+type topicManager struct {
+	adm    *kadm.Client
+	topics map[string]configs.TopicConfig
+	strict bool
+	mu     sync.Mutex
+	// checked holds the outcome for every topic handled so far
+	checked map[string]error
+}
+
+func newTopicManager(cl *kgo.Client, cfg *configs.SinkConfig) (*topicManager, error) {
+	m := &topicManager{
+		adm:     kadm.NewClient(cl),
+		topics:  cfg.Topics,
+		strict:  cfg.StrictTopics,
+		checked: make(map[string]error),
+	}
+
+	var static []string
+	for route := range cfg.Topics {
+		if !strings.Contains(route, "{{") {
+			static = append(static, route)
+		}
+	}
+	slices.Sort(static)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, route := range static {
+		if err := m.ensure(ctx, configs.RouteName(strings.TrimSpace(route)), cfg.Topics[route]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// forEvent handles the event's topic if its route has settings. Events
+// replayed from a DLQ have lost the route they came from and are matched by
+// topic name.
+func (m *topicManager) forEvent(event events.ChangeEvent) error {
+	topic, ok := m.topics[event.RouteSource]
+	if !ok {
+		topic, ok = m.topics[event.Route]
+	}
+	if !ok {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err, done := m.checked[event.Route]; done {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return m.ensure(ctx, event.Route, topic)
+}
+
+// errTopicAdmin marks admin requests that failed on the way to or inside the
+// brokers rather than on the topic's settings. They say nothing about the
+// event, so the sink retries them instead of dead-lettering.
+var errTopicAdmin = errors.New("topic admin request failed")
+
+// adminError wraps err in errTopicAdmin unless the broker refused the
+// request for good, e.g. with a policy violation or an invalid replication
+// factor.
+func adminError(err error) error {
+	var kerror *kerr.Error
+	if errors.As(err, &kerror) && !kerror.Retriable {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errTopicAdmin, err)
+}
+
+func (m *topicManager) ensure(ctx context.Context, name string, topic configs.TopicConfig) error {
+	details, err := m.adm.ListTopics(ctx, name)
+	if err != nil {
+		// not recorded, the next event tries again
+		return fmt.Errorf("KAFKA ERR: could not list topics: %w", adminError(err))
+	}
+	if !details.Has(name) {
+		err = createTopic(ctx, m.adm, name, topic)
+	} else {
+		var mismatches []string
+		if mismatches, err = checkTopic(ctx, m.adm, details[name], topic); err != nil {
+			return err
+		}
+		for _, mismatch := range mismatches {
+			fmt.Printf("WARN: Topic mismatch: %s\n", mismatch)
+		}
+		if m.strict && len(mismatches) > 0 {
+			// kept, a topic does not fix itself between events
+			err = fmt.Errorf("KAFKA ERR: %d setting(s) of topic %s do not match the config", len(mismatches), name)
+			m.checked[name] = err
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	m.checked[name] = nil
+	return nil
+}
+
+func createTopic(ctx context.Context, adm *kadm.Client, name string, topic configs.TopicConfig) error {
+	partitions, replication := topic.Partitions, topic.ReplicationFactor
+	if partitions == 0 {
+		partitions = -1
+	}
+	if replication == 0 {
+		replication = -1
+	}
+	resp, err := adm.CreateTopic(ctx, partitions, replication, topicSettings(topic), name)
+	if err == nil {
+		err = resp.Err
+	}
+	if errors.Is(err, kerr.TopicAlreadyExists) {
+		// created by someone else since we listed, check it on the next start
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("KAFKA ERR: could not create topic %s: %w", name, adminError(err))
+	}
+	fmt.Printf("Created topic %s (partitions=%d, replication=%d)\n", name, resp.NumPartitions, resp.ReplicationFactor)
+	return nil
+}
+
+// topicSettings maps the config to broker topic configs, leaving out anything
+// that was not set.
+func topicSettings(topic configs.TopicConfig) map[string]*string {
+	settings := make(map[string]*string)
+	if topic.CleanupPolicy != "" {
+		settings["cleanup.policy"] = kadm.StringPtr(topic.CleanupPolicy)
+	}
+	if topic.Retention != 0 {
+		settings["retention.ms"] = kadm.StringPtr(strconv.FormatInt(topic.Retention.Milliseconds(), 10))
+	}
+	if topic.MinInsyncReplicas != 0 {
+		settings["min.insync.replicas"] = kadm.StringPtr(strconv.Itoa(topic.MinInsyncReplicas))
+	}
+	return settings
+}
+
+func checkTopic(ctx context.Context, adm *kadm.Client, detail kadm.TopicDetail, topic configs.TopicConfig) ([]string, error) {
+	name := detail.Topic
+	var mismatches []string
+	if topic.Partitions != 0 && int32(len(detail.Partitions)) != topic.Partitions {
+		mismatches = append(mismatches, fmt.Sprintf("%s has %d partitions, config wants %d", name, len(detail.Partitions), topic.Partitions))
+	}
+	if topic.ReplicationFactor != 0 {
+		if p, ok := detail.Partitions[0]; ok && int16(len(p.Replicas)) != topic.ReplicationFactor {
+			mismatches = append(mismatches, fmt.Sprintf("%s has replication factor %d, config wants %d", name, len(p.Replicas), topic.ReplicationFactor))
+		}
+	}
+
+	want := topicSettings(topic)
+	if len(want) == 0 {
+		return mismatches, nil
+	}
+	described, err := adm.DescribeTopicConfigs(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("KAFKA ERR: could not describe topic %s: %w", name, adminError(err))
+	}
+	rc, err := described.On(name, nil)
+	if err == nil {
+		err = rc.Err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("KAFKA ERR: could not describe topic %s: %w", name, adminError(err))
+	}
+
+	have := make(map[string]string)
+	for _, c := range rc.Configs {
+		if c.Value != nil {
+			have[c.Key] = *c.Value
+		}
+	}
+	for key, value := range want {
+		if !sameSetting(key, have[key], *value) {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %s=%s, config wants %s", name, key, have[key], *value))
+		}
+	}
+	return mismatches, nil
+}
+
+// sameSetting compares cleanup policies as sets, the broker may list them in
+// either order.
+func sameSetting(key, have, want string) bool {
+	if key != "cleanup.policy" {
+		return have == want
+	}
+	h, w := strings.Split(have, ","), strings.Split(want, ",")
+	if len(h) != len(w) {
+		return false
+	}
+	for _, policy := range w {
+		if !strings.Contains(","+have+",", ","+strings.TrimSpace(policy)+",") {
+			return false
+		}
+	}
+	return true
+}
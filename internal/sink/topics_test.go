@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MathewBravo/cdc-pipeline/internal/configs"
+	"github.com/MathewBravo/cdc-pipeline/internal/events"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func partitions(t *testing.T, adm *kadm.Client, topic string) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	details, err := adm.ListTopics(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !details.Has(topic) {
+		return 0
+	}
+	return len(details[topic].Partitions)
+}
+
+func TestTopicManager(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "existing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	cl, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	adm := kadm.NewClient(cl)
+
+	cfg := &configs.SinkConfig{
+		Topics: map[string]configs.TopicConfig{
+			"audit":          {Partitions: 2},
+			"cdc.{{.Table}}": {Partitions: 3},
+			"existing":       {Partitions: 4},
+		},
+		StrictTopics: true,
+	}
+	if _, err := newTopicManager(cl, cfg); err == nil {
+		t.Fatal("expected the partition count of existing to be rejected")
+	}
+
+	cfg.Topics["existing"] = configs.TopicConfig{Partitions: 1}
+	m, err := newTopicManager(cl, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := partitions(t, adm, "audit"); n != 2 {
+		t.Errorf("audit has %d partitions, want 2", n)
+	}
+	if n := partitions(t, adm, "cdc.users"); n != 0 {
+		t.Errorf("cdc.users exists before any event was routed there")
+	}
+
+	event := testEvent("0/10", "cdc.users")
+	event.RouteSource = "cdc.{{.Table}}"
+	if err := m.forEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if n := partitions(t, adm, "cdc.users"); n != 3 {
+		t.Errorf("cdc.users has %d partitions, want 3", n)
+	}
+
+	// a replayed event lost its route source and is matched by name
+	replayed := events.ChangeEvent{Route: "audit", Lsn: "0/20"}
+	if err := m.forEvent(replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	// a script route without settings is left alone
+	if err := m.forEvent(testEvent("0/30", "scripted")); err != nil {
+		t.Fatal(err)
+	}
+	if n := partitions(t, adm, "scripted"); n != 0 {
+		t.Errorf("scripted was created by the sink")
+	}
+}
+
+// failCreateTopics answers the next n CreateTopics requests with err.
+func failCreateTopics(cluster *kfake.Cluster, n int, err *kerr.Error) {
+	var calls atomic.Int32
+	cluster.ControlKey(int16(kmsg.CreateTopics), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		if int(calls.Add(1)) >= n {
+			cluster.DropControl()
+		} else {
+			cluster.KeepControl()
+		}
+		create := req.(*kmsg.CreateTopicsRequest)
+		resp := create.ResponseKind().(*kmsg.CreateTopicsResponse)
+		for _, topic := range create.Topics {
+			rt := kmsg.NewCreateTopicsResponseTopic()
+			rt.Topic = topic.Topic
+			rt.ErrorCode = err.Code
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+}
+
+func TestTopicManagerSeparatesAdminFailuresFromConflicts(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	cl, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	m, err := newTopicManager(cl, &configs.SinkConfig{
+		Topics: map[string]configs.TopicConfig{"cdc.{{.Table}}": {Partitions: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent("0/10", "cdc.users")
+	event.RouteSource = "cdc.{{.Table}}"
+
+	failCreateTopics(cluster, 1, kerr.RequestTimedOut)
+	if err := m.forEvent(event); !errors.Is(err, errTopicAdmin) {
+		t.Fatalf("timed out create: err = %v, want a topic admin failure", err)
+	}
+	// the failure is not remembered
+	if err := m.forEvent(event); err != nil {
+		t.Fatal(err)
+	}
+
+	failCreateTopics(cluster, 1, kerr.PolicyViolation)
+	event.Route = "cdc.orders"
+	if err := m.forEvent(event); err == nil || errors.Is(err, errTopicAdmin) {
+		t.Fatalf("refused create: err = %v, want a conflict", err)
+	}
+}
+
+func TestKafkaSinkRetriesTopicAdminFailures(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	acks := newAckRecorder(1)
+	k := newTestKafkaSink(t, cluster, acks.ack)
+	deadLetters := k.deadLetters.writer.(*memoryDLQ)
+	k.topics.topics = map[string]configs.TopicConfig{"cdc.{{.Table}}": {Partitions: 3}}
+	failCreateTopics(cluster, 3, kerr.RequestTimedOut)
+
+	in := make(chan events.ChangeEvent)
+	if err := k.Start(in); err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent("0/10", "cdc.users")
+	event.RouteSource = "cdc.{{.Table}}"
+	in <- event
+	acks.wait(t)
+	if n := partitions(t, kadm.NewClient(k.client), "cdc.users"); n != 3 {
+		t.Errorf("cdc.users has %d partitions, want 3", n)
+	}
+	close(in)
+	k.Stop()
+
+	if lsns := deadLetters.lsns(); len(lsns) > 0 {
+		t.Errorf("dead letters = %v, want none", lsns)
+	}
+}
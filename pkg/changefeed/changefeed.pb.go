@@ -0,0 +1,346 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: changefeed.proto
+
+package changefeed
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Operation int32
+
+const (
+	Operation_OPERATION_UNSPECIFIED Operation = 0
+	Operation_OPERATION_INSERT      Operation = 1
+	Operation_OPERATION_UPDATE      Operation = 2
+	Operation_OPERATION_DELETE      Operation = 3
+)
+
+// Enum value maps for Operation.
+var (
+	Operation_name = map[int32]string{
+		0: "OPERATION_UNSPECIFIED",
+		1: "OPERATION_INSERT",
+		2: "OPERATION_UPDATE",
+		3: "OPERATION_DELETE",
+	}
+	Operation_value = map[string]int32{
+		"OPERATION_UNSPECIFIED": 0,
+		"OPERATION_INSERT":      1,
+		"OPERATION_UPDATE":      2,
+		"OPERATION_DELETE":      3,
+	}
+)
+
+func (x Operation) Enum() *Operation {
+	p := new(Operation)
+	*p = x
+	return p
+}
+
+func (x Operation) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Operation) Descriptor() protoreflect.EnumDescriptor {
+	return file_changefeed_proto_enumTypes[0].Descriptor()
+}
+
+func (Operation) Type() protoreflect.EnumType {
+	return &file_changefeed_proto_enumTypes[0]
+}
+
+func (x Operation) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Operation.Descriptor instead.
+func (Operation) EnumDescriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{0}
+}
+
+// SubscribeRequest filters the feed. Empty lists match everything. Tables
+// are "table" or "schema.table", operations are INSERT, UPDATE or DELETE in
+// any case.
+type SubscribeRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Tables     []string               `protobuf:"bytes,1,rep,name=tables,proto3" json:"tables,omitempty"`
+	Operations []string               `protobuf:"bytes,2,rep,name=operations,proto3" json:"operations,omitempty"`
+	Routes     []string               `protobuf:"bytes,3,rep,name=routes,proto3" json:"routes,omitempty"`
+	// from_lsn is the last LSN the subscriber received, e.g. "0/16B3748".
+	FromLsn       string `protobuf:"bytes,4,opt,name=from_lsn,json=fromLsn,proto3" json:"from_lsn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_changefeed_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetTables() []string {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetRoutes() []string {
+	if x != nil {
+		return x.Routes
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetFromLsn() string {
+	if x != nil {
+		return x.FromLsn
+	}
+	return ""
+}
+
+// ChangeEvent is one captured row change. Row images are JSON objects, as
+// the other sinks write them, so numbers keep their full precision.
+type ChangeEvent struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Operation Operation              `protobuf:"varint,1,opt,name=operation,proto3,enum=cdc.Operation" json:"operation,omitempty"`
+	Schema    string                 `protobuf:"bytes,2,opt,name=schema,proto3" json:"schema,omitempty"`
+	Table     string                 `protobuf:"bytes,3,opt,name=table,proto3" json:"table,omitempty"`
+	// before is set for updates and deletes when the table's replica
+	// identity provides it.
+	Before []byte `protobuf:"bytes,4,opt,name=before,proto3" json:"before,omitempty"`
+	// after is set for inserts and updates.
+	After         []byte   `protobuf:"bytes,5,opt,name=after,proto3" json:"after,omitempty"`
+	Lsn           string   `protobuf:"bytes,6,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Route         string   `protobuf:"bytes,7,opt,name=route,proto3" json:"route,omitempty"`
+	PrimaryKey    []string `protobuf:"bytes,8,rep,name=primary_key,json=primaryKey,proto3" json:"primary_key,omitempty"`
+	Xid           uint32   `protobuf:"varint,9,opt,name=xid,proto3" json:"xid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangeEvent) Reset() {
+	*x = ChangeEvent{}
+	mi := &file_changefeed_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeEvent) ProtoMessage() {}
+
+func (x *ChangeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_changefeed_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeEvent.ProtoReflect.Descriptor instead.
+func (*ChangeEvent) Descriptor() ([]byte, []int) {
+	return file_changefeed_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChangeEvent) GetOperation() Operation {
+	if x != nil {
+		return x.Operation
+	}
+	return Operation_OPERATION_UNSPECIFIED
+}
+
+func (x *ChangeEvent) GetSchema() string {
+	if x != nil {
+		return x.Schema
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetBefore() []byte {
+	if x != nil {
+		return x.Before
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetAfter() []byte {
+	if x != nil {
+		return x.After
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetLsn() string {
+	if x != nil {
+		return x.Lsn
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetRoute() string {
+	if x != nil {
+		return x.Route
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetPrimaryKey() []string {
+	if x != nil {
+		return x.PrimaryKey
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetXid() uint32 {
+	if x != nil {
+		return x.Xid
+	}
+	return 0
+}
+
+var File_changefeed_proto protoreflect.FileDescriptor
+
+const file_changefeed_proto_rawDesc = "" +
+	"\n" +
+	"\x10changefeed.proto\x12\x03cdc\"}\n" +
+	"\x10SubscribeRequest\x12\x16\n" +
+	"\x06tables\x18\x01 \x03(\tR\x06tables\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x02 \x03(\tR\n" +
+	"operations\x12\x16\n" +
+	"\x06routes\x18\x03 \x03(\tR\x06routes\x12\x19\n" +
+	"\bfrom_lsn\x18\x04 \x01(\tR\afromLsn\"\xf2\x01\n" +
+	"\vChangeEvent\x12,\n" +
+	"\toperation\x18\x01 \x01(\x0e2\x0e.cdc.OperationR\toperation\x12\x16\n" +
+	"\x06schema\x18\x02 \x01(\tR\x06schema\x12\x14\n" +
+	"\x05table\x18\x03 \x01(\tR\x05table\x12\x16\n" +
+	"\x06before\x18\x04 \x01(\fR\x06before\x12\x14\n" +
+	"\x05after\x18\x05 \x01(\fR\x05after\x12\x10\n" +
+	"\x03lsn\x18\x06 \x01(\tR\x03lsn\x12\x14\n" +
+	"\x05route\x18\a \x01(\tR\x05route\x12\x1f\n" +
+	"\vprimary_key\x18\b \x03(\tR\n" +
+	"primaryKey\x12\x10\n" +
+	"\x03xid\x18\t \x01(\rR\x03xid*h\n" +
+	"\tOperation\x12\x19\n" +
+	"\x15OPERATION_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10OPERATION_INSERT\x10\x01\x12\x14\n" +
+	"\x10OPERATION_UPDATE\x10\x02\x12\x14\n" +
+	"\x10OPERATION_DELETE\x10\x032D\n" +
+	"\n" +
+	"ChangeFeed\x126\n" +
+	"\tSubscribe\x12\x15.cdc.SubscribeRequest\x1a\x10.cdc.ChangeEvent0\x01B4Z2github.com/MathewBravo/cdc-pipeline/pkg/changefeedb\x06proto3"
+
+var (
+	file_changefeed_proto_rawDescOnce sync.Once
+	file_changefeed_proto_rawDescData []byte
+)
+
+func file_changefeed_proto_rawDescGZIP() []byte {
+	file_changefeed_proto_rawDescOnce.Do(func() {
+		file_changefeed_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_changefeed_proto_rawDesc), len(file_changefeed_proto_rawDesc)))
+	})
+	return file_changefeed_proto_rawDescData
+}
+
+var file_changefeed_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_changefeed_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_changefeed_proto_goTypes = []any{
+	(Operation)(0),           // 0: cdc.Operation
+	(*SubscribeRequest)(nil), // 1: cdc.SubscribeRequest
+	(*ChangeEvent)(nil),      // 2: cdc.ChangeEvent
+}
+var file_changefeed_proto_depIdxs = []int32{
+	0, // 0: cdc.ChangeEvent.operation:type_name -> cdc.Operation
+	1, // 1: cdc.ChangeFeed.Subscribe:input_type -> cdc.SubscribeRequest
+	2, // 2: cdc.ChangeFeed.Subscribe:output_type -> cdc.ChangeEvent
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_changefeed_proto_init() }
+func file_changefeed_proto_init() {
+	if File_changefeed_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_changefeed_proto_rawDesc), len(file_changefeed_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_changefeed_proto_goTypes,
+		DependencyIndexes: file_changefeed_proto_depIdxs,
+		EnumInfos:         file_changefeed_proto_enumTypes,
+		MessageInfos:      file_changefeed_proto_msgTypes,
+	}.Build()
+	File_changefeed_proto = out.File
+	file_changefeed_proto_goTypes = nil
+	file_changefeed_proto_depIdxs = nil
+}
@@ -0,0 +1,132 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: changefeed.proto
+
+package changefeed
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ChangeFeed_Subscribe_FullMethodName = "/cdc.ChangeFeed/Subscribe"
+)
+
+// ChangeFeedClient is the client API for ChangeFeed service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ChangeFeed is served by the gRPC sink.
+type ChangeFeedClient interface {
+	// Subscribe streams change events matching the request, starting after
+	// from_lsn when it is set.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChangeEvent], error)
+}
+
+type changeFeedClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChangeFeedClient(cc grpc.ClientConnInterface) ChangeFeedClient {
+	return &changeFeedClient{cc}
+}
+
+func (c *changeFeedClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChangeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ChangeFeed_ServiceDesc.Streams[0], ChangeFeed_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, ChangeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ChangeFeed_SubscribeClient = grpc.ServerStreamingClient[ChangeEvent]
+
+// ChangeFeedServer is the server API for ChangeFeed service.
+// All implementations must embed UnimplementedChangeFeedServer
+// for forward compatibility.
+//
+// ChangeFeed is served by the gRPC sink.
+type ChangeFeedServer interface {
+	// Subscribe streams change events matching the request, starting after
+	// from_lsn when it is set.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[ChangeEvent]) error
+	mustEmbedUnimplementedChangeFeedServer()
+}
+
+// UnimplementedChangeFeedServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChangeFeedServer struct{}
+
+func (UnimplementedChangeFeedServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[ChangeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedChangeFeedServer) mustEmbedUnimplementedChangeFeedServer() {}
+func (UnimplementedChangeFeedServer) testEmbeddedByValue()                    {}
+
+// UnsafeChangeFeedServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChangeFeedServer will
+// result in compilation errors.
+type UnsafeChangeFeedServer interface {
+	mustEmbedUnimplementedChangeFeedServer()
+}
+
+func RegisterChangeFeedServer(s grpc.ServiceRegistrar, srv ChangeFeedServer) {
+	// If the following call pancis, it indicates UnimplementedChangeFeedServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ChangeFeed_ServiceDesc, srv)
+}
+
+func _ChangeFeed_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChangeFeedServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, ChangeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ChangeFeed_SubscribeServer = grpc.ServerStreamingServer[ChangeEvent]
+
+// ChangeFeed_ServiceDesc is the grpc.ServiceDesc for ChangeFeed service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChangeFeed_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cdc.ChangeFeed",
+	HandlerType: (*ChangeFeedServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _ChangeFeed_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "changefeed.proto",
+}
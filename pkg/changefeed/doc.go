@@ -0,0 +1,6 @@
+// Package changefeed holds the protobuf messages and gRPC stubs of the
+// cdc.ChangeFeed service that the gRPC sink serves, for subscribers written
+// in Go. Other languages generate theirs from changefeed.proto.
+package changefeed
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative changefeed.proto
@@ -0,0 +1,64 @@
+// Package claimcheck describes the references the Kafka sink sends in place
+// of events that are too large for the broker, and resolves them back into
+// events for consumers.
+package claimcheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Header marks records whose value is a Reference instead of the event
+// itself.
+const Header = "cdc-claim-check"
+
+// Reference stands in for an event that was too large to send. The
+// serialized event is stored under Key and SHA256 is the hex digest of it.
+// The identifying fields are copied so consumers can route or skip the
+// record without fetching the payload.
+type Reference struct {
+	Key       string `json:"claim_check_key"`
+	SHA256    string `json:"claim_check_sha256"`
+	Size      int    `json:"claim_check_size"`
+	Operation int    `json:"Operation"`
+	NameSpace string `json:"NameSpace"`
+	Table     string `json:"Table"`
+	Lsn       string `json:"Lsn"`
+	Route     string `json:"Route"`
+}
+
+// New builds the reference for a serialized event. The caller fills in the
+// identifying fields and sets Key once it knows where the payload is stored.
+func New(payload []byte) Reference {
+	sum := sha256.Sum256(payload)
+	return Reference{
+		SHA256: hex.EncodeToString(sum[:]),
+		Size:   len(payload),
+	}
+}
+
+// Resolve decodes a record value into v, which is typically a struct
+// mirroring the event or a map. Plain events are decoded as is; references
+// are fetched with fetch and verified against their checksum first.
+func Resolve(value []byte, fetch func(key string) ([]byte, error), v any) error {
+	var ref Reference
+	if err := json.Unmarshal(value, &ref); err != nil {
+		return err
+	}
+
+	payload := value
+	if ref.Key != "" {
+		data, err := fetch(ref.Key)
+		if err != nil {
+			return fmt.Errorf("could not fetch claim check %s: %w", ref.Key, err)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != ref.SHA256 {
+			return fmt.Errorf("claim check %s does not match its checksum", ref.Key)
+		}
+		payload = data
+	}
+	return json.Unmarshal(payload, v)
+}
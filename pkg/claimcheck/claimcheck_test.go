@@ -0,0 +1,69 @@
+package claimcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type event struct {
+	Table string
+	After map[string]any
+}
+
+func TestResolve(t *testing.T) {
+	payload, err := json.Marshal(event{Table: "users", After: map[string]any{"id": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := New(payload)
+	ref.Key = "public.users/" + ref.SHA256 + ".json"
+	ref.Table = "users"
+	value, err := json.Marshal(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := map[string][]byte{ref.Key: payload}
+	fetch := func(key string) ([]byte, error) {
+		data, ok := store[key]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return data, nil
+	}
+
+	tests := []struct {
+		name    string
+		value   []byte
+		store   []byte
+		wantErr bool
+	}{
+		{"plain event", payload, payload, false},
+		{"reference", value, payload, false},
+		{"tampered payload", value, []byte(`{"Table":"users","After":{"id":"2"}}`), true},
+		{"missing payload", value, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.store == nil {
+				delete(store, ref.Key)
+			} else {
+				store[ref.Key] = tt.store
+			}
+			var got event
+			err := Resolve(tt.value, fetch, &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Table != "users" || got.After["id"] != "1" {
+				t.Errorf("resolved %+v", got)
+			}
+		})
+	}
+}
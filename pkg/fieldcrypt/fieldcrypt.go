@@ -0,0 +1,232 @@
+// Package fieldcrypt encrypts and decrypts individual field values. It is
+// used by the pipeline's encrypt mask and can be imported by consumers that
+// are allowed to read the plaintext.
+//
+// Encrypted values look like
+//
+//	enc:<key id>:gcm:<base64>   AES-256-GCM, random nonce
+//	enc:<key id>:siv:<base64>   AES-SIV, deterministic so values can be joined
+//
+// Both ciphers use subkeys derived with HKDF-SHA256 from the key in the
+// keyring, so one keyring entry serves both modes.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/tink-crypto/tink-go/v2/daead/subtle"
+)
+
+const Prefix = "enc:"
+
+const (
+	ModeRandom        = "gcm"
+	ModeDeterministic = "siv"
+)
+
+// Keyring is loaded from a YAML file:
+//
+//	active: k2
+//	keys:
+//	  k1: <base64, 32 bytes>
+//	  k2: <base64, 32 bytes>
+//
+// New values are encrypted under the active key unless a key is named; old
+// keys stay in the file so earlier values can still be decrypted.
+type Keyring struct {
+	active string
+	keys   map[string]*key
+}
+
+type key struct {
+	gcm cipher.AEAD
+	siv *subtle.AESSIV
+}
+
+type keyringFile struct {
+	Active string            `yaml:"active"`
+	Keys   map[string]string `yaml:"keys"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyringFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	k := &Keyring{active: file.Active, keys: make(map[string]*key, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("keyring %s: key id %q must be non-empty and without ':'", path, id)
+		}
+		master, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: key %s is not base64: %w", path, id, err)
+		}
+		if len(master) != 32 {
+			return nil, fmt.Errorf("keyring %s: key %s must be 32 bytes, got %d", path, id, len(master))
+		}
+		if k.keys[id], err = newKey(master); err != nil {
+			return nil, fmt.Errorf("keyring %s: key %s: %w", path, id, err)
+		}
+	}
+	if _, ok := k.keys[k.active]; k.active != "" && !ok {
+		return nil, fmt.Errorf("keyring %s: active key %q is not defined", path, k.active)
+	}
+	return k, nil
+}
+
+func newKey(master []byte) (*key, error) {
+	gcmKey, err := hkdf.Key(sha256.New, master, nil, "fieldcrypt gcm", 32)
+	if err != nil {
+		return nil, err
+	}
+	sivKey, err := hkdf.Key(sha256.New, master, nil, "fieldcrypt siv", 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(gcmKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	siv, err := subtle.NewAESSIV(sivKey)
+	if err != nil {
+		return nil, err
+	}
+	return &key{gcm: gcm, siv: siv}, nil
+}
+
+// HasKey reports whether id is in the keyring.
+func (k *Keyring) HasKey(id string) bool {
+	_, ok := k.keys[id]
+	return ok
+}
+
+// Active is the key new values are encrypted under by default.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// Encrypt encrypts value under keyID, or the active key when keyID is empty.
+func (k *Keyring) Encrypt(keyID, mode, value string) (string, error) {
+	if keyID == "" {
+		keyID = k.active
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("unknown key %q", keyID)
+	}
+
+	var ciphertext []byte
+	switch mode {
+	case ModeDeterministic:
+		var err error
+		if ciphertext, err = key.siv.EncryptDeterministically([]byte(value), nil); err != nil {
+			return "", err
+		}
+	case ModeRandom, "":
+		mode = ModeRandom
+		nonce := make([]byte, key.gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		ciphertext = key.gcm.Seal(nonce, nonce, []byte(value), nil)
+	default:
+		return "", fmt.Errorf("unknown mode %q", mode)
+	}
+	return Prefix + keyID + ":" + mode + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// IsEncrypted reports whether value looks like the output of Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix) && strings.Count(value, ":") == 3
+}
+
+// Decrypt reverses Encrypt with whichever key the value names.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return "", fmt.Errorf("not an encrypted value")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	keyID, mode, encoded := parts[0], parts[1], parts[2]
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("value was encrypted with key %q, which is not in the keyring", keyID)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	var plaintext []byte
+	switch mode {
+	case ModeDeterministic:
+		plaintext, err = key.siv.DecryptDeterministically(ciphertext, nil)
+	case ModeRandom:
+		n := key.gcm.NonceSize()
+		if len(ciphertext) < n {
+			return "", fmt.Errorf("malformed encrypted value")
+		}
+		plaintext, err = key.gcm.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	default:
+		return "", fmt.Errorf("unknown mode %q", mode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("decryption failed: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// DecryptAll walks a decoded JSON document, such as an event's before or
+// after image, and decrypts every encrypted string in place. Values under
+// keys it does not hold are left encrypted and reported in the error.
+func (k *Keyring) DecryptAll(doc any) (any, error) {
+	var firstErr error
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch t := v.(type) {
+		case string:
+			if !IsEncrypted(t) {
+				return t
+			}
+			plain, err := k.Decrypt(t)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return t
+			}
+			return plain
+		case map[string]any:
+			for key, value := range t {
+				t[key] = walk(value)
+			}
+		case []any:
+			for i, value := range t {
+				t[i] = walk(value)
+			}
+		}
+		return v
+	}
+	return walk(doc), firstErr
+}
@@ -0,0 +1,145 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	var file strings.Builder
+	file.WriteString("active: " + active + "\nkeys:\n")
+	for i, id := range ids {
+		file.WriteString("  " + id + ": " + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)) + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	if err := os.WriteFile(path, []byte(file.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := testKeyring(t, "k2", "k1", "k2")
+	tests := []struct {
+		keyID, mode, value string
+		wantKey            string
+	}{
+		{"", ModeRandom, "ann@example.com", "k2"},
+		{"", "", "", "k2"},
+		{"k1", ModeRandom, "ünïcode ✓", "k1"},
+		{"", ModeDeterministic, "4111111111111111", "k2"},
+		{"k1", ModeDeterministic, "a:b:c", "k1"},
+	}
+	for _, tt := range tests {
+		enc, err := keys.Encrypt(tt.keyID, tt.mode, tt.value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q, %q): %v", tt.keyID, tt.mode, err)
+		}
+		if !IsEncrypted(enc) || !strings.HasPrefix(enc, Prefix+tt.wantKey+":") {
+			t.Errorf("Encrypt(%q, %q) = %q, want a value under %s", tt.keyID, tt.mode, enc, tt.wantKey)
+		}
+		plain, err := keys.Decrypt(enc)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", enc, err)
+		}
+		if plain != tt.value {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q", tt.value, plain)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	a, _ := keys.Encrypt("", ModeDeterministic, "same")
+	b, _ := keys.Encrypt("", ModeDeterministic, "same")
+	if a != b {
+		t.Errorf("deterministic values differ: %q, %q", a, b)
+	}
+	a, _ = keys.Encrypt("", ModeRandom, "same")
+	b, _ = keys.Encrypt("", ModeRandom, "same")
+	if a == b {
+		t.Errorf("random values are equal: %q", a)
+	}
+	if _, err := keys.Encrypt("", "ecb", "x"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	other := testKeyring(t, "k9", "k9")
+	enc, _ := keys.Encrypt("", ModeRandom, "secret")
+	foreign, _ := other.Encrypt("", ModeRandom, "secret")
+
+	// flip a byte of the ciphertext
+	i := strings.LastIndex(enc, ":") + 5
+	tampered := enc[:i] + string(enc[i]^1) + enc[i+1:]
+
+	for name, value := range map[string]string{
+		"plaintext":   "secret",
+		"unknown key": foreign,
+		"tampered":    tampered,
+		"bad base64":  "enc:k1:gcm:***",
+		"short":       "enc:k1:gcm:AAAA",
+		"bad mode":    "enc:k1:xyz:AAAA",
+	} {
+		if _, err := keys.Decrypt(value); err == nil {
+			t.Errorf("%s: Decrypt(%q) succeeded", name, value)
+		}
+	}
+}
+
+func TestDecryptAll(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	other := testKeyring(t, "k9", "k9")
+	email, _ := keys.Encrypt("", ModeRandom, "ann@example.com")
+	card, _ := keys.Encrypt("", ModeDeterministic, "4111")
+	foreign, _ := other.Encrypt("", ModeRandom, "x")
+
+	doc := map[string]any{
+		"email": email,
+		"cards": []any{card, "plain"},
+		"nested": map[string]any{
+			"other": foreign,
+			"n":     1.5,
+		},
+	}
+	got, err := keys.DecryptAll(doc)
+	if err == nil {
+		t.Error("expected the value under k9 to be reported")
+	}
+	m := got.(map[string]any)
+	if m["email"] != "ann@example.com" || m["cards"].([]any)[0] != "4111" || m["cards"].([]any)[1] != "plain" {
+		t.Errorf("DecryptAll = %v", m)
+	}
+	if nested := m["nested"].(map[string]any); nested["other"] != foreign || nested["n"] != 1.5 {
+		t.Errorf("nested = %v", nested)
+	}
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for name, file := range map[string]string{
+		"short key":      "keys:\n  k1: " + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n",
+		"not base64":     "keys:\n  k1: '***'\n",
+		"colon in id":    "keys:\n  'a:b': " + good + "\n",
+		"missing active": "active: k2\nkeys:\n  k1: " + good + "\n",
+	} {
+		path := filepath.Join(t.TempDir(), "keyring.yaml")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyring(path); err == nil {
+			t.Errorf("%s: LoadKeyring succeeded", name)
+		}
+	}
+}